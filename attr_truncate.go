@@ -0,0 +1,118 @@
+package trace2receiver
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// The suffix appended to an attribute key to form the name of the
+// companion attribute that marks a truncated value.  For example,
+// `trace2.cmd.argv.truncated`.
+const attributeTruncatedSuffix string = ".truncated"
+
+// The attribute value marker used to indicate that a value (or the
+// tail of an argv array) was truncated.
+const attributeEllipsis string = "..."
+
+// Get the attribute value size limit to use for this detail level.
+// Zero means no limit.
+func (tr2 *trace2Dataset) maxAttributeBytes(dl FilterDetailLevel) int {
+	if tr2.rcvr_base == nil || WantUnabridgedOutput(dl) {
+		return 0
+	}
+	return tr2.rcvr_base.RcvrConfig.MaxAttributeBytes
+}
+
+// Truncate the string to at most `maxBytes` bytes (including the
+// ellipsis marker) if it is longer than that.  We are careful not to
+// split a multi-byte UTF-8 character.  A `maxBytes` of zero means no
+// limit.
+func truncateAttributeValue(s string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(s) <= maxBytes {
+		return s, false
+	}
+
+	keep := maxBytes - len(attributeEllipsis)
+	if keep < 0 {
+		keep = 0
+	}
+	for keep > 0 && !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+
+	return s[:keep] + attributeEllipsis, true
+}
+
+// Serialize an argv array into a JSON string of at most `maxBytes`
+// bytes.  Rather than cutting the serialized string (and creating
+// invalid JSON), we truncate the individual elements and then drop
+// trailing elements (and replace them with an ellipsis element)
+// until it fits.
+func marshalArgv(argv []interface{}, maxBytes int) (string, bool) {
+	jargs, _ := json.Marshal(argv)
+	if maxBytes <= 0 || len(jargs) <= maxBytes {
+		return string(jargs), false
+	}
+
+	elements := make([]interface{}, len(argv))
+	for k, a := range argv {
+		if s, ok := a.(string); ok {
+			a, _ = truncateAttributeValue(s, maxBytes)
+		}
+		elements[k] = a
+	}
+
+	for keep := len(elements); keep >= 0; keep-- {
+		candidate := elements[:keep:keep]
+		if keep < len(elements) {
+			candidate = append(candidate, attributeEllipsis)
+		}
+		jargs, _ = json.Marshal(candidate)
+		if len(jargs) <= maxBytes {
+			break
+		}
+	}
+
+	// If even `["..."]` does not fit, we have a silly limit.
+	// Just return it anyway.
+	return string(jargs), true
+}
+
+// Put an argv array attribute, truncating it if necessary.
+func putArgvAttribute(sm pcommon.Map, key string, argv []interface{}, maxBytes int) {
+	jargs, truncated := marshalArgv(argv, maxBytes)
+	sm.PutStr(key, jargs)
+	if truncated {
+		sm.PutBool(key+attributeTruncatedSuffix, true)
+	}
+}
+
+// Truncate any string attribute values on the span that are longer
+// than `maxBytes` and add the companion "truncated" attribute.
+func truncateSpanAttributes(span *ptrace.Span, maxBytes int) {
+	if maxBytes <= 0 {
+		return
+	}
+
+	sm := span.Attributes()
+
+	// Collect the keys first since we cannot add attributes
+	// while iterating over the map.
+	var long []string
+	sm.Range(func(k string, v pcommon.Value) bool {
+		if v.Type() == pcommon.ValueTypeStr && len(v.Str()) > maxBytes {
+			long = append(long, k)
+		}
+		return true
+	})
+
+	for _, k := range long {
+		v, _ := sm.Get(k)
+		s, _ := truncateAttributeValue(v.Str(), maxBytes)
+		v.SetStr(s)
+		sm.PutBool(k+attributeTruncatedSuffix, true)
+	}
+}
@@ -0,0 +1,82 @@
+package trace2receiver
+
+import (
+	"fmt"
+)
+
+// The names of the groups of process span attributes that a ruleset
+// can add to or remove from those selected by the detail level.
+const (
+	attributeGroupArgv     string = "argv"
+	attributeGroupParams   string = "params"
+	attributeGroupTimers   string = "timers"
+	attributeGroupCounters string = "counters"
+	attributeGroupData     string = "data"
+	attributeGroupAncestry string = "ancestry"
+	attributeGroupRepos    string = "repos"
+)
+
+var attributeGroupNames []string = []string{
+	attributeGroupArgv,
+	attributeGroupParams,
+	attributeGroupTimers,
+	attributeGroupCounters,
+	attributeGroupData,
+	attributeGroupAncestry,
+	attributeGroupRepos,
+}
+
+// RulesetAttributes describes which groups of process span attributes
+// should be emitted for datasets that use a ruleset, layered on top of
+// the groups selected by the detail level.  Groups in `Include` are
+// always emitted and groups in `Exclude` are never emitted.  This lets
+// a ruleset, for example, emit counters at `dl:summary`.
+type RulesetAttributes struct {
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+func (ra *RulesetAttributes) validate() error {
+	for _, g := range append(append([]string{}, ra.Include...), ra.Exclude...) {
+		if !containsString(attributeGroupNames, g) {
+			return fmt.Errorf("unknown attribute group '%s'", g)
+		}
+	}
+
+	for _, g := range ra.Include {
+		if containsString(ra.Exclude, g) {
+			return fmt.Errorf("attribute group '%s' is both included and excluded", g)
+		}
+	}
+
+	return nil
+}
+
+// Should we emit the attributes in this group?  `dflt` is the
+// answer for the detail level.
+func (ra *RulesetAttributes) want(group string, dflt bool) bool {
+	if ra == nil {
+		return dflt
+	}
+
+	if containsString(ra.Exclude, group) {
+		return false
+	}
+	if containsString(ra.Include, group) {
+		return true
+	}
+
+	return dflt
+}
+
+// Return the attribute group settings for a dataset.  These are only
+// defined in custom rulesets, so we return nil if the dataset did not
+// resolve to a custom ruleset (or the ruleset does not have an
+// `attributes` section).
+func (rsdef *RulesetDefinition) attributeGroups() *RulesetAttributes {
+	if rsdef == nil {
+		return nil
+	}
+
+	return rsdef.Attributes
+}
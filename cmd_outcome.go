@@ -0,0 +1,73 @@
+package trace2receiver
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The normalized outcomes of a Git command.
+const (
+	CmdOutcomeOk        string = "ok"
+	CmdOutcomeError     string = "error"
+	CmdOutcomeFatal     string = "fatal"
+	CmdOutcomeUsage     string = "usage"
+	CmdOutcomeSignalled string = "signalled"
+	CmdOutcomeCrash     string = "crash"
+)
+
+// The builtin mapping of exit codes to outcomes.  Git uses 128 for
+// `die()` and 129 for `usage()`.  This table can be extended or
+// overridden with the `outcome_exit_codes` config setting.
+var defaultOutcomeExitCodes map[int64]string = map[int64]string{
+	128: CmdOutcomeFatal,
+	129: CmdOutcomeUsage,
+}
+
+// Parse the `outcome_exit_codes` config setting.  The YML keys are
+// decimal exit codes.
+func parseOutcomeExitCodes(m map[string]string) (map[int64]string, error) {
+	res := make(map[int64]string)
+
+	for k, v := range m {
+		code, err := strconv.ParseInt(k, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code '%s'", k)
+		}
+		if len(v) == 0 {
+			return nil, fmt.Errorf("exit code '%s' has empty outcome", k)
+		}
+		res[code] = v
+	}
+
+	return res, nil
+}
+
+// Classify the outcome of the command.  The precedence is:
+//
+//	signalled > crash > (mapped exit code) > error > ok
+//
+// A command "crashed" if we never saw an "exit" or "atexit" event.
+// A command with a zero exit code is still an "error" if it reported
+// an error message.
+func (tr2 *trace2Dataset) computeCmdOutcome(custom map[int64]string) string {
+	if tr2.process.sawSignal {
+		return CmdOutcomeSignalled
+	}
+	if !tr2.process.cleanExit {
+		return CmdOutcomeCrash
+	}
+
+	code := tr2.process.exeExitCode
+	if v, ok := custom[code]; ok {
+		return v
+	}
+	if v, ok := defaultOutcomeExitCodes[code]; ok {
+		return v
+	}
+
+	if code != 0 || len(tr2.process.exeErrorFmt) > 0 {
+		return CmdOutcomeError
+	}
+
+	return CmdOutcomeOk
+}
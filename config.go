@@ -2,9 +2,14 @@ package trace2receiver
 
 import (
 	"fmt"
+	"net"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
 )
 
 // `Config` represents the complete configuration settings for
@@ -39,19 +44,221 @@ type Config struct {
 	// off the prefix.
 	//
 	// This config file field is ignored on Windows platforms.
-	UnixSocketPath string `mapstructure:"socket"`
+	UnixSocketPath    string `mapstructure:"socket"`
+	unixSocketNetwork string
+
+	// On Unix, refuse to start if another process is listening on
+	// the socket, rather than deleting it and orphaning the other
+	// process.
+	//
+	// This config file field is ignored on Windows platforms.
+	RefuseIfSocketLive bool `mapstructure:"refuse_if_socket_live"`
+
+	// On Unix, if our socket is deleted or replaced while we are
+	// running, create a new one rather than shutting down.
+	//
+	// This config file field is ignored on Windows platforms.
+	RecreateOnInodeChange bool `mapstructure:"recreate_on_inode_change"`
+
+	// Listen on this TCP `<host>:<port>` address (IPv4 or IPv6)
+	// rather than the platform Unix domain socket or named pipe.
+	// This is intended for use with a shim that forwards the Trace2
+	// data stream, such as when the collector runs in a container.
+	// Only one type of listener may be configured on this platform.
+	TCPListen string `mapstructure:"tcp_listen"`
 
 	// Allow command and control verbs to be embedded in the Trace2
 	// data stream.
 	AllowCommandControlVerbs bool `mapstructure:"enable_commands"`
 
-	// Pathname to YML file containing PII settings.
+	// Reject the client if any other Trace2 event arrives before
+	// the "version" event.  By default we are lenient and hold any
+	// early events until the "version" event arrives (since it is
+	// used to compute the TraceID and SpanIDs for the process).
+	RequireVersionFirst bool `mapstructure:"require_version_first"`
+
+	// Add the pathname of the socket or named pipe that we are
+	// listening on as a resource attribute.  This can help identify
+	// the receiver instance when there are several on a host, but
+	// the pathname may be considered sensitive, so it is optional.
+	EmitReceiverEndpoint bool `mapstructure:"emit_receiver_endpoint"`
+
+	// Use the simple `exec(<basename>)` display name for exec spans
+	// rather than classifying dashed Git commands, such as
+	// `exec(git:remote-https)`.
+	SimpleExecDisplayNames bool `mapstructure:"simple_exec_display_names"`
+
+	// Emit the values of well-known Trace2 "data" events as numeric
+	// attributes on the process span.  `DataValueRules` can be used
+	// to extend the builtin set.
+	EmitWellKnownData bool            `mapstructure:"emit_wellknown_data"`
+	DataValueRules    []DataValueRule `mapstructure:"data_value_rules"`
+
+	// Describes how to decide whether a command used the filesystem
+	// monitor.  If empty, we use `defaultFSMonitorIndicators`.
+	FSMonitorIndicators FSMonitorIndicators `mapstructure:"fsmonitor_indicators"`
+
+	// Truncate span display names longer than this and keep the full
+	// name in a separate attribute.  Zero means no truncation.
+	MaxDisplayNameLen int `mapstructure:"max_display_name_len"`
+
+	// Emit the sum of the process-level counters in each counter
+	// category at `dl:process` and above.
+	EmitCounterCategoryTotals bool `mapstructure:"emit_counter_category_totals"`
+
+	// Do not emit child spans shorter than this many milliseconds.
+	// Zero means emit all of them.
+	MinChildMs int64 `mapstructure:"min_child_ms"`
+
+	// Emit the receiver uptime as a resource attribute.
+	EmitReceiverUptime bool `mapstructure:"emit_receiver_uptime"`
+
+	// Attach region-level data events that we cannot match with their
+	// region to the process rather than dropping them.
+	LenientRegionData bool `mapstructure:"lenient_region_data"`
+
+	// Emit a normalized outcome for the command.  `OutcomeExitCodes`
+	// can be used to map additional exit codes to outcomes.
+	EmitCmdOutcome   bool              `mapstructure:"emit_cmd_outcome"`
+	OutcomeExitCodes map[string]string `mapstructure:"outcome_exit_codes"`
+	outcomeExitCodes map[int64]string
+
+	// Do not emit region spans shorter than this many milliseconds.
+	// Spans nested within them are reparented to the nearest emitted
+	// ancestor.  Zero means emit all of them.
+	MinRegionMs int64 `mapstructure:"min_region_ms"`
+
+	// If a command has more than this many region spans, emit one
+	// aggregate span for each (category, label) pair instead.  Zero
+	// means never summarize them.
+	RegionSummarizeThreshold int `mapstructure:"region_summarize_threshold"`
+
+	// Move the data values of suppressed regions onto the nearest
+	// emitted ancestor span rather than dropping them.
+	PromoteSuppressedRegionData bool `mapstructure:"promote_suppressed_region_data"`
+
+	// Round span start and end times to this resolution ("ns", "us",
+	// or "ms").  The default is "ns".
+	TimeResolution string `mapstructure:"time_resolution"`
+	timeResolution time.Duration
+
+	// The name of the `def_param` (usually an environment variable
+	// advertised via `trace2.envvars`) containing a CI build id.
+	CIIdParam string `mapstructure:"ci_id_param"`
+
+	// Fold per-thread timers and counters into the process-level
+	// timers and counters when Git did not report them there.
+	FoldThreadMetrics bool `mapstructure:"fold_thread_metrics"`
+
+	// Accept events from clients in Trace2 "brief" mode (which omit
+	// the "time" field on most events) and approximate the missing
+	// times.  Otherwise, such clients are rejected.
+	AllowBriefMode bool `mapstructure:"allow_brief_mode"`
+
+	// The maximum number of error messages to remember for a command.
+	// If zero, we use `DefaultMaxErrorMessages`.
+	MaxErrorMessages int `mapstructure:"max_error_messages"`
+
+	// Also emit the process-level timers and counters as OTEL metrics
+	// when the receiver is used in a metrics pipeline.
+	EmitMetrics bool `mapstructure:"emit_metrics"`
+
+	// Force close a client connection if we do not receive any data
+	// for this long.  Zero means no idle timeout.
+	ReadIdleTimeout time.Duration `mapstructure:"read_idle_timeout"`
+
+	// Force close a client connection that has been open this long.
+	// Zero means no limit.
+	MaxConnectionDuration time.Duration `mapstructure:"max_connection_duration"`
+
+	// Truncate string attribute values (including serialized JSON
+	// values) longer than this many bytes.  Zero means no limit.
+	// This is ignored at `dl:raw`.
+	MaxAttributeBytes int `mapstructure:"max_attribute_bytes"`
+
+	// Emit spans whose end time precedes their start time as is,
+	// rather than clamping them to zero duration.
+	AllowNegativeDurations bool `mapstructure:"allow_negative_durations"`
+
+	// Stop accumulating regions, child processes, or threads for a
+	// command after this many.  This protects us from a misbehaving
+	// client exhausting our memory.  Zero means no limit.
+	MaxRegions  int `mapstructure:"max_regions"`
+	MaxChildren int `mapstructure:"max_children"`
+	MaxThreads  int `mapstructure:"max_threads"`
+
+	// Synthesize a chain of zero-duration spans for the processes in
+	// the `cmd_ancestry` above the process span of a top-level Git
+	// command.
+	AncestryAsSpans bool `mapstructure:"ancestry_as_spans"`
+
+	// Use this for the `service.name` resource attribute rather than
+	// the qualified name of the Git command.
+	ServiceName string `mapstructure:"service_name"`
+
+	// Add these static key/value pairs to the resource attributes,
+	// such as `deployment.region`.  They cannot overwrite any of the
+	// resource attributes that we set.
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"`
+
+	// Emit the "error" and "printf" events as OTEL log records
+	// (correlated with the process span) to the logs pipeline.
+	EmitLogs bool `mapstructure:"emit_logs"`
+
+	// Turn off parts of the normalization of the region category and
+	// label in the region display names.
+	RegionNameNormalization RegionNameNormalization `mapstructure:"region_name_normalization"`
+
+	// Mark commands that ran longer than this many seconds with
+	// `trace2.cmd.slow`.  Zero means never mark them.
+	SlowThresholdSec float64 `mapstructure:"slow_threshold_sec"`
+
+	// How to reconcile the category, label, and message of a region
+	// when the "region_leave" event has different values than the
+	// "region_enter" event ("prefer-enter", "prefer-leave", or
+	// "prefer-non-empty").  The default is "prefer-enter".
+	RegionLeavePolicy string `mapstructure:"region_leave_policy"`
+	regionLeavePolicy regionLeavePolicy
+
+	// Remember this many of the most recent raw lines on each
+	// connection and log them at debug level if we get a parse or
+	// apply error.  Zero disables it.
+	DebugRingSize int `mapstructure:"debug_ring_size"`
+
+	// Limit the number of client connections that we process at the
+	// same time.  Zero means no limit.
+	MaxConcurrentConnections int `mapstructure:"max_concurrent_connections"`
+
+	// What to do with a new connection when we are already processing
+	// `MaxConcurrentConnections` ("queue" or "reject").  The default
+	// is "queue".
+	ConnectionLimitPolicy string `mapstructure:"connection_limit_policy"`
+	connectionLimitPolicy connectionLimitPolicy
+
+	// Pathname to YML file containing PII settings.  Environment
+	// variable references (such as `${TRACE2_CONFIG_DIR}`) are expanded.
 	PiiSettingsPath string `mapstructure:"pii"`
 	piiSettings     *PiiSettings
 
-	// Pathname to YML file containing our filter settings.
+	// Pathname to YML file containing our filter settings.  Environment
+	// variable references are expanded.
 	FilterSettingsPath string `mapstructure:"filter"`
 	filterSettings     *FilterSettings
+
+	// Reload the filter settings (and the ruleset files) when they
+	// change on disk, checking this often.  Zero means never reload.
+	SettingsReloadInterval time.Duration `mapstructure:"settings_reload_interval"`
+
+	// Protects `filterSettings` when it is replaced by a reload.
+	settingsMutex sync.RWMutex
+
+	// An optional hook to modify (or drop) the spans for a command just
+	// before we send them to the next component in the pipeline.  This
+	// cannot be set in `config.yaml`; a custom collector must set it in
+	// Go.  It is called on the worker goroutine for the connection, so
+	// it must be fast and must not block.  Return an empty `Traces` to
+	// drop the command.
+	PostProcessTraces func(ptrace.Traces) ptrace.Traces `mapstructure:"-"`
 }
 
 // `Validate()` checks if the receiver configuration is valid.
@@ -75,7 +282,23 @@ func (cfg *Config) Validate() error {
 	var path string
 	var err error
 
-	if runtime.GOOS == "windows" {
+	if len(cfg.TCPListen) > 0 {
+		if runtime.GOOS == "windows" && len(cfg.NamedPipePath) > 0 {
+			return fmt.Errorf("receivers.trace2receiver.tcp_listen cannot be combined with pipe")
+		}
+		if runtime.GOOS != "windows" && len(cfg.UnixSocketPath) > 0 {
+			return fmt.Errorf("receivers.trace2receiver.tcp_listen cannot be combined with socket")
+		}
+		host, _, err := net.SplitHostPort(cfg.TCPListen)
+		if err != nil {
+			return fmt.Errorf("receivers.trace2receiver.tcp_listen invalid: '%s'",
+				err.Error())
+		}
+		if len(host) == 0 {
+			return fmt.Errorf("receivers.trace2receiver.tcp_listen must include a host: '%s'",
+				cfg.TCPListen)
+		}
+	} else if runtime.GOOS == "windows" {
 		if len(cfg.NamedPipePath) == 0 {
 			return fmt.Errorf("receivers.trace2receiver.pipe not defined")
 		}
@@ -89,7 +312,7 @@ func (cfg *Config) Validate() error {
 		if len(cfg.UnixSocketPath) == 0 {
 			return fmt.Errorf("receivers.trace2receiver.socket not defined")
 		}
-		path, err = normalize_uds_path(cfg.UnixSocketPath)
+		path, cfg.unixSocketNetwork, err = normalize_uds_path(cfg.UnixSocketPath)
 		if err != nil {
 			return fmt.Errorf("receivers.trace2receiver.socket invalid: '%s'",
 				err.Error())
@@ -97,7 +320,119 @@ func (cfg *Config) Validate() error {
 		cfg.UnixSocketPath = path
 	}
 
+	if cfg.MaxDisplayNameLen < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_display_name_len invalid: '%d'",
+			cfg.MaxDisplayNameLen)
+	}
+
+	cfg.timeResolution, err = parseTimeResolution(cfg.TimeResolution)
+	if err != nil {
+		return fmt.Errorf("receivers.trace2receiver.time_resolution invalid: '%s'",
+			err.Error())
+	}
+
+	if cfg.MaxErrorMessages < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_error_messages invalid: '%d'",
+			cfg.MaxErrorMessages)
+	}
+
+	if cfg.MinRegionMs < 0 {
+		return fmt.Errorf("receivers.trace2receiver.min_region_ms invalid: '%d'",
+			cfg.MinRegionMs)
+	}
+
+	if cfg.RegionSummarizeThreshold < 0 {
+		return fmt.Errorf("receivers.trace2receiver.region_summarize_threshold invalid: '%d'",
+			cfg.RegionSummarizeThreshold)
+	}
+
+	if cfg.MinChildMs < 0 {
+		return fmt.Errorf("receivers.trace2receiver.min_child_ms invalid: '%d'",
+			cfg.MinChildMs)
+	}
+
+	if cfg.ReadIdleTimeout < 0 {
+		return fmt.Errorf("receivers.trace2receiver.read_idle_timeout invalid: '%s'",
+			cfg.ReadIdleTimeout)
+	}
+
+	if cfg.SettingsReloadInterval < 0 {
+		return fmt.Errorf("receivers.trace2receiver.settings_reload_interval invalid: '%s'",
+			cfg.SettingsReloadInterval)
+	}
+
+	if cfg.MaxConnectionDuration < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_connection_duration invalid: '%s'",
+			cfg.MaxConnectionDuration)
+	}
+
+	if cfg.MaxAttributeBytes < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_attribute_bytes invalid: '%d'",
+			cfg.MaxAttributeBytes)
+	}
+
+	if cfg.MaxRegions < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_regions invalid: '%d'",
+			cfg.MaxRegions)
+	}
+
+	if cfg.MaxChildren < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_children invalid: '%d'",
+			cfg.MaxChildren)
+	}
+
+	if cfg.MaxThreads < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_threads invalid: '%d'",
+			cfg.MaxThreads)
+	}
+
+	cfg.regionLeavePolicy, err = parseRegionLeavePolicy(cfg.RegionLeavePolicy)
+	if err != nil {
+		return fmt.Errorf("receivers.trace2receiver.region_leave_policy invalid: '%s'",
+			err.Error())
+	}
+
+	if cfg.DebugRingSize < 0 {
+		return fmt.Errorf("receivers.trace2receiver.debug_ring_size invalid: '%d'",
+			cfg.DebugRingSize)
+	}
+
+	if cfg.MaxConcurrentConnections < 0 {
+		return fmt.Errorf("receivers.trace2receiver.max_concurrent_connections invalid: '%d'",
+			cfg.MaxConcurrentConnections)
+	}
+
+	cfg.connectionLimitPolicy, err = parseConnectionLimitPolicy(cfg.ConnectionLimitPolicy)
+	if err != nil {
+		return fmt.Errorf("receivers.trace2receiver.connection_limit_policy invalid: '%s'",
+			err.Error())
+	}
+
+	if cfg.SlowThresholdSec < 0 {
+		return fmt.Errorf("receivers.trace2receiver.slow_threshold_sec invalid: '%v'",
+			cfg.SlowThresholdSec)
+	}
+
+	if err = validateDataValueRules(cfg.DataValueRules); err != nil {
+		return err
+	}
+
+	if err = validateResourceAttributes(cfg.ResourceAttributes); err != nil {
+		return err
+	}
+
+	cfg.outcomeExitCodes, err = parseOutcomeExitCodes(cfg.OutcomeExitCodes)
+	if err != nil {
+		return fmt.Errorf("receivers.trace2receiver.outcome_exit_codes invalid: '%s'",
+			err.Error())
+	}
+
 	if len(cfg.PiiSettingsPath) > 0 {
+		cfg.PiiSettingsPath, err = expandPathname(cfg.PiiSettingsPath)
+		if err != nil {
+			return fmt.Errorf("receivers.trace2receiver.pii invalid: '%s'",
+				err.Error())
+		}
 		cfg.piiSettings, err = parsePiiFile(cfg.PiiSettingsPath)
 		if err != nil {
 			return err
@@ -105,15 +440,48 @@ func (cfg *Config) Validate() error {
 	}
 
 	if len(cfg.FilterSettingsPath) > 0 {
-		cfg.filterSettings, err = parseFilterSettings(cfg.FilterSettingsPath)
+		cfg.FilterSettingsPath, err = expandPathname(cfg.FilterSettingsPath)
+		if err != nil {
+			return fmt.Errorf("receivers.trace2receiver.filter invalid: '%s'",
+				err.Error())
+		}
+		fs, err := parseFilterSettings(cfg.FilterSettingsPath)
 		if err != nil {
 			return err
 		}
+		cfg.setFilterSettings(fs)
 	}
 
 	return nil
 }
 
+// Get the current filter settings.  These may be replaced at any
+// time by a reload, so callers should fetch them once per dataset.
+func (cfg *Config) getFilterSettings() *FilterSettings {
+	cfg.settingsMutex.RLock()
+	defer cfg.settingsMutex.RUnlock()
+	return cfg.filterSettings
+}
+
+// Replace the current filter settings.
+func (cfg *Config) setFilterSettings(fs *FilterSettings) {
+	cfg.settingsMutex.Lock()
+	defer cfg.settingsMutex.Unlock()
+	cfg.filterSettings = fs
+}
+
+// Return the (already validated) pathname of the socket or named
+// pipe that this receiver instance will listen on.
+func (cfg *Config) receiverEndpoint() string {
+	if len(cfg.TCPListen) > 0 {
+		return cfg.TCPListen
+	}
+	if runtime.GOOS == "windows" {
+		return cfg.NamedPipePath
+	}
+	return cfg.UnixSocketPath
+}
+
 // Require (the backslash spelling of) `//./pipe/<pipename>` but allow
 // `<pipename>` as an alias for the full spelling.  Complain if given a
 // regular UNC or drive letter pathname.
@@ -147,27 +515,96 @@ func normalize_named_pipe_path(in string) (string, error) {
 	return out, nil
 }
 
+// The `net` package network names for the types of Unix domain
+// sockets that we can listen on.
+const (
+	unixNetworkStream    string = "unix"
+	unixNetworkSeqpacket string = "unixpacket"
+)
+
 // Pathnames for Unix domain sockets are just normal Unix
 // pathnames.  However, we do allow an optional `af_unix:`
 // or `af_unix:stream:` prefix.  (This helps if they set it
 // to the value of the GIT_TRACE2_EVENT string, which does
-// require the prefix.)
-func normalize_uds_path(in string) (string, error) {
+// require the prefix.)  An `af_unix:seqpacket:` prefix selects
+// a SOCK_SEQPACKET socket rather than a SOCK_STREAM socket.
+// Returns the pathname and the `net` package network name.
+func normalize_uds_path(in string) (string, string, error) {
 
 	p, found := strings.CutPrefix(in, "af_unix:stream:")
 	if found {
-		return p, nil
+		return p, unixNetworkStream, nil
+	}
+
+	p, found = strings.CutPrefix(in, "af_unix:seqpacket:")
+	if found {
+		return p, unixNetworkSeqpacket, nil
 	}
 
 	_, found = strings.CutPrefix(in, "af_unix:dgram:")
 	if found {
-		return "", fmt.Errorf("SOCK_DGRAM sockets are not supported")
+		return "", "", fmt.Errorf("SOCK_DGRAM sockets are not supported")
 	}
 
 	p, found = strings.CutPrefix(in, "af_unix:")
 	if found {
-		return p, nil
+		return p, unixNetworkStream, nil
+	}
+
+	return in, unixNetworkStream, nil
+}
+
+// Parse the `time_resolution` config setting.
+func parseTimeResolution(s string) (time.Duration, error) {
+	switch s {
+	case "", "ns":
+		return time.Nanosecond, nil
+	case "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	default:
+		return 0, fmt.Errorf("unknown resolution '%s'", s)
+	}
+}
+
+type regionLeavePolicy int
+
+const (
+	regionLeavePreferEnter regionLeavePolicy = iota
+	regionLeavePreferLeave
+	regionLeavePreferNonEmpty
+)
+
+// Parse the `region_leave_policy` config setting.
+func parseRegionLeavePolicy(s string) (regionLeavePolicy, error) {
+	switch s {
+	case "", "prefer-enter":
+		return regionLeavePreferEnter, nil
+	case "prefer-leave":
+		return regionLeavePreferLeave, nil
+	case "prefer-non-empty":
+		return regionLeavePreferNonEmpty, nil
+	default:
+		return 0, fmt.Errorf("unknown policy '%s'", s)
 	}
+}
 
-	return in, nil
+type connectionLimitPolicy int
+
+const (
+	connectionLimitQueue connectionLimitPolicy = iota
+	connectionLimitReject
+)
+
+// Parse the `connection_limit_policy` config setting.
+func parseConnectionLimitPolicy(s string) (connectionLimitPolicy, error) {
+	switch s {
+	case "", "queue":
+		return connectionLimitQueue, nil
+	case "reject":
+		return connectionLimitReject, nil
+	default:
+		return 0, fmt.Errorf("unknown policy '%s'", s)
+	}
 }
@@ -0,0 +1,83 @@
+package trace2receiver
+
+import (
+	"fmt"
+)
+
+// RulesetDataCategories describes which Trace2 "data" and "data_json"
+// event categories should be emitted for datasets that use a ruleset.
+// Some Git builds send very large data values for some categories,
+// so this can be used to reduce the size of the spans.
+//
+// If `Include` is not empty, only those categories are kept.  Any
+// categories in `Exclude` are then dropped.  Matching is exact on the
+// category name.
+type RulesetDataCategories struct {
+	Include []string `mapstructure:"include"`
+	Exclude []string `mapstructure:"exclude"`
+}
+
+// Should we keep the data values in this category?
+func (dc *RulesetDataCategories) wantCategory(category string) bool {
+	if dc == nil {
+		return true
+	}
+
+	if len(dc.Include) > 0 && !containsString(dc.Include, category) {
+		return false
+	}
+
+	return !containsString(dc.Exclude, category)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Return the data category settings for a dataset.  These are only
+// defined in custom rulesets, so we return nil if the dataset did
+// not resolve to a custom ruleset (or the ruleset does not have a
+// `data_categories` section).
+func (rsdef *RulesetDefinition) dataCategories() *RulesetDataCategories {
+	if rsdef == nil {
+		return nil
+	}
+
+	return rsdef.DataCategories
+}
+
+// Remove the unwanted data categories from the process and from
+// each of the regions.
+func (tr2 *trace2Dataset) filterDataCategories(dc *RulesetDataCategories) {
+	if dc == nil {
+		return
+	}
+
+	dropped := make(map[string]bool)
+
+	filter := func(dataValues map[string]map[string]interface{}) {
+		for category := range dataValues {
+			if !dc.wantCategory(category) {
+				delete(dataValues, category)
+				dropped[category] = true
+			}
+		}
+	}
+
+	filter(tr2.process.dataValues)
+	for _, r := range tr2.completedRegions {
+		filter(r.dataValues)
+	}
+
+	if tr2.rcvr_base != nil {
+		for category := range dropped {
+			tr2.rcvr_base.Logger.Debug(fmt.Sprintf("[dsid %06d] dropping data category '%s'",
+				tr2.datasetId, category))
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package trace2receiver
+
+import "fmt"
+
+// The caps on the number of regions, children, and threads that we
+// will accumulate for a single dataset.  Zero means no limit.
+type datasetLimits struct {
+	maxRegions  int
+	maxChildren int
+	maxThreads  int
+}
+
+// Get the dataset caps from the receiver config (if we have one).
+func (tr2 *trace2Dataset) limits() datasetLimits {
+	if tr2.rcvr_base == nil || tr2.rcvr_base.RcvrConfig == nil {
+		return datasetLimits{}
+	}
+
+	return datasetLimits{
+		maxRegions:  tr2.rcvr_base.RcvrConfig.MaxRegions,
+		maxChildren: tr2.rcvr_base.RcvrConfig.MaxChildren,
+		maxThreads:  tr2.rcvr_base.RcvrConfig.MaxThreads,
+	}
+}
+
+// Return true if we already have `n` entries and that is at (or over)
+// the cap.  Remember that the cap was hit and log it the first time.
+func (tr2 *trace2Dataset) atLimit(what string, n int, limit int, hit *bool) bool {
+	if limit <= 0 || n < limit {
+		return false
+	}
+
+	if !*hit {
+		*hit = true
+		if tr2.rcvr_base != nil {
+			tr2.rcvr_base.Logger.Debug(fmt.Sprintf("[dsid %06d] too many %s (max %d), ignoring the rest",
+				tr2.datasetId, what, limit))
+		}
+	}
+
+	return true
+}
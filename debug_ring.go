@@ -0,0 +1,55 @@
+package trace2receiver
+
+import (
+	"bytes"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// `rawLineRing` remembers the last few raw lines received on a
+// connection so that we can log the lines leading up to a parse or
+// apply error.  This is only used when `debug_ring_size` is set.
+type rawLineRing struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newRawLineRing(size int) *rawLineRing {
+	if size <= 0 {
+		return nil
+	}
+	return &rawLineRing{lines: make([]string, size)}
+}
+
+func (ring *rawLineRing) add(rawLine []byte) {
+	ring.lines[ring.next] = string(bytes.TrimRight(rawLine, "\r\n"))
+	ring.next++
+	if ring.next == len(ring.lines) {
+		ring.next = 0
+		ring.full = true
+	}
+}
+
+// Return the remembered lines, oldest first.
+func (ring *rawLineRing) snapshot() []string {
+	if !ring.full {
+		return append([]string(nil), ring.lines[:ring.next]...)
+	}
+	return append(append([]string(nil), ring.lines[ring.next:]...), ring.lines[:ring.next]...)
+}
+
+// Log the recent raw lines (including the offending one) at debug
+// level after an error.
+func (tr2 *trace2Dataset) dumpDebugRing(logger *zap.Logger) {
+	if tr2.debugRing == nil {
+		return
+	}
+
+	lines := tr2.debugRing.snapshot()
+	for k, line := range lines {
+		logger.Debug(fmt.Sprintf("[dsid %06d] recent line [%d/%d]: %s",
+			tr2.datasetId, k+1, len(lines), line))
+	}
+}
@@ -3,7 +3,10 @@ package trace2receiver
 import (
 	"fmt"
 	"path/filepath"
+	"sort"
 	"strings"
+
+	"go.uber.org/zap"
 )
 
 func evt_apply(tr2 *trace2Dataset, evt *TrEvent) error {
@@ -25,9 +28,75 @@ func evt_apply(tr2 *trace2Dataset, evt *TrEvent) error {
 		return nil
 	}
 
+	tr2.updateMaxTAbs(evt)
+
 	return afn(tr2, evt)
 }
 
+// The maximum number of events that we will hold while waiting for
+// the "version" event.  Git always sends it first, so a client that
+// sends this many events without it is not going to send it at all.
+const maxPreVersionEvents int = 100
+
+// Apply the event to the dataset, but guarantee that the "version"
+// event is applied before any other event.  Git always sends it first,
+// but a malformed or partial stream might not and we would generate
+// spans with zero TraceIDs and SpanIDs.
+//
+// If the receiver requires the "version" event to be first, reject the
+// client.  Otherwise, hold the early events until it arrives (and
+// reject the client if we are already holding too many).
+func evt_apply_version_first(tr2 *trace2Dataset, evt *TrEvent, logger *zap.Logger) error {
+	if tr2.sawVersion {
+		return evt_apply(tr2, evt)
+	}
+
+	if evt.mf_event != "version" {
+		tr2.stats().inc(rcvrStatPreVersionEvents)
+
+		if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.RequireVersionFirst {
+			return &RejectClientError{
+				Err: fmt.Errorf("rejecting client: '%s' event received before 'version' event",
+					evt.mf_event),
+			}
+		}
+
+		if len(tr2.preVersionEvents) >= maxPreVersionEvents {
+			return &RejectClientError{
+				Err: fmt.Errorf("rejecting client: more than %d events received before 'version' event",
+					maxPreVersionEvents),
+			}
+		}
+
+		tr2.preVersionEvents = append(tr2.preVersionEvents, evt)
+		return nil
+	}
+
+	tr2.sawVersion = true
+
+	if err := evt_apply(tr2, evt); err != nil {
+		return err
+	}
+
+	if len(tr2.preVersionEvents) == 0 {
+		return nil
+	}
+
+	logger.Warn(fmt.Sprintf("[dsid %06d] received %d events before 'version' event",
+		tr2.datasetId, len(tr2.preVersionEvents)))
+
+	early := tr2.preVersionEvents
+	tr2.preVersionEvents = nil
+
+	for _, e := range early {
+		if err := evt_apply(tr2, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type FnApply func(tr2 *trace2Dataset, evt *TrEvent) (err error)
 type ApplyMap map[string]FnApply
 
@@ -38,6 +107,7 @@ var applymap *ApplyMap = &ApplyMap{
 	"atexit":       apply__atexit,
 	"signal":       apply__signal,
 	"error":        apply__error,
+	"printf":       apply__printf,
 	"cmd_path":     apply__cmd_path,
 	"cmd_ancestry": apply__cmd_ancestry,
 	"cmd_name":     apply__cmd_name,
@@ -80,7 +150,8 @@ func apply__version(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 
 	tr2.otelTraceID,
 		tr2.process.mainThread.lifetime.selfSpanID,
-		tr2.process.mainThread.lifetime.parentSpanID =
+		tr2.process.mainThread.lifetime.parentSpanID,
+		tr2.process.sidDepth =
 		extractIDsfromSID(tr2.trace2SID)
 
 	return nil
@@ -93,16 +164,47 @@ func apply__start(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 }
 
 func apply__atexit(tr2 *trace2Dataset, evt *TrEvent) (err error) {
-	// Code shared by "exit" and "atexit". Let the last one win.
+	// Code shared by "exit" and "atexit". Let the last one win for
+	// the exit code, but use the later of the two for the end time
+	// (in case they arrive out of order).
 	//
 	// Defer popping the region stack until EOF.
 
-	tr2.process.mainThread.lifetime.endTime = evt.mf_time
+	if evt.mf_time.After(tr2.process.mainThread.lifetime.endTime) {
+		tr2.process.mainThread.lifetime.endTime = evt.mf_time
+	}
 	tr2.process.exeExitCode = evt.pm_atexit.mf_code
+	tr2.process.cleanExit = true
+
+	// Also remember each of them separately, since a difference
+	// between them indicates a problem in an atexit handler.
+	if evt.mf_event == "exit" {
+		tr2.process.exitCode = evt.pm_atexit.mf_code
+		tr2.process.exitTime = evt.mf_time
+	} else {
+		tr2.process.atexitCode = evt.pm_atexit.mf_code
+		tr2.process.atexitTime = evt.mf_time
+	}
+
+	// On Windows, `exec()` is emulated by spawning the replacement
+	// process and waiting for it, so the current process does exit
+	// normally.  If Git did not send an "exec_result" event, close
+	// any still-open exec spans at this time (and again use the later
+	// of "exit" and "atexit"), rather than leaving them unterminated.
+	for _, exec := range tr2.exec {
+		if exec.lifetime.isIncomplete() ||
+			(exec.endedByExit && evt.mf_time.After(exec.lifetime.endTime)) {
+			exec.lifetime.endTime = evt.mf_time
+			exec.endedByExit = true
+		}
+	}
 
 	return nil
 }
 
+// The name we use for signal numbers that we do not recognize.
+const unknownSignalName string = "SIG?"
+
 func apply__signal(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	// Signals are highly platform-specific, so we may or may not
 	// actually see them in practice.  And we might only get them
@@ -117,6 +219,9 @@ func apply__signal(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 
 	tr2.process.mainThread.lifetime.endTime = evt.mf_time
 	tr2.process.exeExitCode = 128 + signo // Match what the shell does
+	tr2.process.sawSignal = true
+	tr2.process.signo = signo
+	tr2.process.cleanExit = true
 
 	return nil
 }
@@ -130,15 +235,37 @@ func apply__error(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	// is also better for GDPR purposes, since it is less likely to
 	// have PII data.
 	//
-	// Technically, we could see more than one Trace2 error message
-	// from the process, but logging an array of strings is problematic,
-	// so just remember the first one.
+	// We could see more than one Trace2 error message from the
+	// process and the root cause is often not the first one, so
+	// remember the first few of them.  For backwards compatibility,
+	// we also remember the first one separately.
 
 	if len(tr2.process.exeErrorFmt) == 0 {
 		tr2.process.exeErrorFmt = evt.pm_error.mf_fmt
 		tr2.process.exeErrorMsg = evt.pm_error.mf_msg
 	}
 
+	maxErrors := DefaultMaxErrorMessages
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.MaxErrorMessages > 0 {
+		maxErrors = tr2.rcvr_base.RcvrConfig.MaxErrorMessages
+	}
+	if len(tr2.process.exeErrors) < maxErrors {
+		tr2.process.exeErrors = append(tr2.process.exeErrors, TrErrorMessage{
+			Fmt: evt.pm_error.mf_fmt,
+			Msg: evt.pm_error.mf_msg,
+		})
+	}
+
+	tr2.addLogRecord(evt, evt.pm_error.mf_msg, evt.pm_error.mf_fmt)
+
+	return nil
+}
+
+// The "printf" event contains a free-form debug message.  We only
+// use it when emitting log records.
+func apply__printf(tr2 *trace2Dataset, evt *TrEvent) (err error) {
+	tr2.addLogRecord(evt, evt.pm_printf.mf_msg, "")
+
 	return nil
 }
 
@@ -192,13 +319,16 @@ func apply__cmd_name(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	// We could fix that upstream or pick thru the argv, but it's not
 	// worth the effort right now.)
 	//
-	// TODO There are other long-running services that we should
-	// reject here, such as `git daemon` or a future bundle server,
-	// but these are not likely to be automatically started and be
-	// running on a client machine, so I'll leave that for the
-	// future.
-
-	if err := IsFSMonitorDaemon(evt.pm_cmd_name.mf_name); err != nil {
+	// There are other long-running services that we should reject
+	// here, such as `git daemon` or a bundle server.  These are not
+	// likely to be automatically started on a client machine, so we
+	// let the `reject_verbs` filter setting name them.
+
+	var fs *FilterSettings
+	if tr2.rcvr_base != nil {
+		fs = tr2.rcvr_base.RcvrConfig.getFilterSettings()
+	}
+	if err := IsRejectedVerb(evt.pm_cmd_name.mf_name, fs); err != nil {
 		return err
 	}
 
@@ -261,12 +391,17 @@ func apply__child_start(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 		return nil
 	}
 
+	if tr2.atLimit("children", len(tr2.children), tr2.limits().maxChildren, &tr2.truncatedChildren) {
+		// Ignore this child (and its "child_exit" or "child_ready").
+		return nil
+	}
+
 	child := &TrChild{
 		lifetime: TrSpanEssentials{
 			selfSpanID:   tr2.NewSpanID(), // children get a random SpanID
 			parentSpanID: tr2.process.mainThread.lifetime.selfSpanID,
 			startTime:    evt.mf_time,
-			displayName:  evt.pm_child_start.makeChildDisplayName(),
+			displayName:  tr2.makeUniqueChildDisplayName(evt.pm_child_start.makeChildDisplayName()),
 		},
 		argv:     evt.pm_child_start.mf_argv,
 		pid:      -1,
@@ -282,13 +417,102 @@ func apply__child_start(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 		}
 	}
 
-	// TODO Do we care about "use_shell" and "cd"?
+	if child.class == "cred" {
+		child.credHelper, child.credOp = parseCredHelperArgv(child.argv)
+	}
+
+	if evt.pm_child_start.pmf_cd != nil {
+		child.cd = *evt.pm_child_start.pmf_cd
+	}
+
+	// TODO Do we care about "use_shell"?
 
 	tr2.children[evt.pm_child_start.mf_child_id] = child
 
 	return nil
 }
 
+// The operations that Git asks a credential helper to do.
+var credHelperOps map[string]bool = map[string]bool{
+	"get":   true,
+	"store": true,
+	"erase": true,
+}
+
+// Extract the name of the credential helper (such as "manager" or
+// "osxkeychain") and the operation (such as "get") from the argv of
+// a credential helper child.
+//
+// Unfortunately, the child-start message for the credential helper
+// is usually a single string that Git passes to the shell (such as
+// "git credential-cache --timeout=300 get") rather than a true argv[],
+// so we split it into words and have to work for it a bit.  The
+// helper may also be an absolute pathname to a `git-credential-<name>`
+// executable or an arbitrary shell command.  We return "unknown" for
+// anything that we cannot find.
+func parseCredHelperArgv(argv []interface{}) (helper string, op string) {
+	var words []string
+	for _, a := range argv {
+		if s, ok := a.(string); ok {
+			words = append(words, strings.Fields(s)...)
+		}
+	}
+
+	helper = "unknown"
+	op = "unknown"
+
+	if len(words) == 0 {
+		return helper, op
+	}
+
+	if credHelperOps[words[len(words)-1]] {
+		op = words[len(words)-1]
+	}
+
+	basename := func(s string) string {
+		s = s[strings.LastIndexAny(s, `/\`)+1:]
+		return strings.TrimSuffix(s, ".exe")
+	}
+
+	for k, w := range words {
+		name := basename(w)
+		if h, found := strings.CutPrefix(name, "git-credential-"); found && len(h) > 0 {
+			return h, op
+		}
+		if name == "git" && k+1 < len(words) {
+			if h, found := strings.CutPrefix(words[k+1], "credential-"); found && len(h) > 0 {
+				return h, op
+			}
+		}
+	}
+
+	// An arbitrary shell command (such as "!aws codecommit ...").
+	// Use the name of the program that it runs.
+	if name := basename(strings.TrimPrefix(words[0], "!")); len(name) > 0 && name != op {
+		helper = name
+	}
+
+	return helper, op
+}
+
+// A command may run the same hook (or other child) many times and
+// the spans would be indistinguishable in a trace viewer.  So append
+// an occurrence index to repeated display names, such as
+// `child(hook:pre-commit)#2`.  The first occurrence keeps the bare
+// name.  Git assigns child-ids in increasing order and sends the
+// "child_start" events in that order, so the numbering follows the
+// child-id order.
+func (tr2 *trace2Dataset) makeUniqueChildDisplayName(name string) string {
+	tr2.childDisplayNameCounts[name]++
+
+	n := tr2.childDisplayNameCounts[name]
+	if n == 1 {
+		return name
+	}
+
+	return fmt.Sprintf("%s#%d", name, n)
+}
+
 // Construct a pretty name for a "child_start" event.
 //
 // There are several different types of child processes created by
@@ -334,25 +558,8 @@ func (evt_cs *TrEventChildStart) makeChildDisplayName() string {
 		return fmt.Sprintf("child(dashed:%s)", evt_cs.mf_argv[0].(string))
 	case "cred":
 		// The child is a credential manager.
-		//
-		// Unfortunately, the child-start message for the credential
-		// manager is a single string rather than a true argv[], so we
-		// can't safely extract the "get" or "store" and have to work
-		// for it a bit.
-		if len(evt_cs.mf_argv) > 1 {
-			return fmt.Sprintf("child(cred:%s)", evt_cs.mf_argv[1].(string))
-		}
-		child_argv0 := evt_cs.mf_argv[0].(string)
-		switch {
-		case strings.HasSuffix(child_argv0, "get"):
-			return "child(cred:get)"
-		case strings.HasSuffix(child_argv0, "store"):
-			return "child(cred:store)"
-		case strings.HasSuffix(child_argv0, "erase"):
-			return "child(cred:erase)"
-		default:
-			return "child(cred:unknown)"
-		}
+		_, op := parseCredHelperArgv(evt_cs.mf_argv)
+		return fmt.Sprintf("child(cred:%s)", op)
 	case "?":
 		// Some child processes have not yet been classified in the
 		// Git source.  These get a "?" classification.
@@ -379,6 +586,7 @@ func apply__child_exit(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	}
 
 	child.lifetime.endTime = evt.mf_time
+	child.sawExit = true
 
 	child.pid = evt.pm_child_exit.mf_pid
 	child.exitcode = evt.pm_child_exit.mf_code
@@ -405,6 +613,7 @@ func apply__child_ready(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	// for it).  Substitute -1 as a placeholder.
 	child.exitcode = -1
 	child.readystate = evt.pm_child_ready.mf_ready
+	child.readyTime = evt.mf_time
 
 	return nil
 }
@@ -432,6 +641,12 @@ func apply__thread_start(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 		return nil
 	}
 
+	if tr2.atLimit("threads", len(tr2.threads), tr2.limits().maxThreads, &tr2.truncatedThreads) {
+		// Ignore this thread (and the regions and metrics on it).
+		delete(tr2.pendingThreads, evt.mf_thread)
+		return nil
+	}
+
 	var th *TrThread = new(TrThread)
 
 	// Thread-start events contain the name of the new thread since
@@ -458,6 +673,13 @@ func apply__thread_start(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	th.lifetime.startTime = evt.mf_time
 	th.lifetime.displayName = evt.mf_thread
 
+	// Claim any timers or counters that arrived before this event.
+	if pending, ok := tr2.pendingThreads[evt.mf_thread]; ok {
+		th.timers = pending.timers
+		th.counters = pending.counters
+		delete(tr2.pendingThreads, evt.mf_thread)
+	}
+
 	tr2.threads[evt.mf_thread] = th
 
 	return nil
@@ -510,12 +732,14 @@ func apply__exec(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 		return nil
 	}
 
+	simple := tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.SimpleExecDisplayNames
+
 	exec := &TrExec{
 		lifetime: TrSpanEssentials{
 			selfSpanID:   tr2.NewSpanID(), // children get a random SpanID
 			parentSpanID: tr2.process.mainThread.lifetime.selfSpanID,
 			startTime:    evt.mf_time,
-			displayName:  evt.pm_exec.makeExecDisplayName(),
+			displayName:  evt.pm_exec.makeExecDisplayName(simple),
 		},
 		argv:     evt.pm_exec.mf_argv,
 		exitcode: -1,
@@ -531,20 +755,39 @@ func apply__exec(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 }
 
 // Construct a pretty name for an "exec" event.
-func (evt_ex *TrEventExec) makeExecDisplayName() string {
+//
+// Like child processes, we try to classify the replacement process.
+// An exec of a dashed Git command, such as `git-remote-https`, is
+// reported as `exec(git:remote-https)` to distinguish it from an exec
+// of an external tool, which is reported as `exec(<basename>)`.
+//
+// If `simple` is set, always use the `exec(<basename>)` form.
+func (evt_ex *TrEventExec) makeExecDisplayName(simple bool) string {
+	var basename string
+
 	if evt_ex.pmf_exe != nil {
-		basename := filepath.Base(*evt_ex.pmf_exe)
-		// TODO verify or fixup weird edge cases
-		return fmt.Sprintf("exec(%s)", basename)
+		basename = filepath.Base(*evt_ex.pmf_exe)
+	} else if len(evt_ex.mf_argv) > 0 {
+		basename = filepath.Base(evt_ex.mf_argv[0].(string))
+	} else {
+		return "exec(?)"
 	}
 
-	if len(evt_ex.mf_argv) > 0 {
-		basename := filepath.Base(evt_ex.mf_argv[0].(string))
-		// TODO verify or fixup weird edge cases
+	// TODO verify or fixup weird edge cases
+	if simple {
 		return fmt.Sprintf("exec(%s)", basename)
 	}
 
-	return "exec(?)"
+	name := basename
+	if strings.ToLower(filepath.Ext(name)) == ".exe" {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+
+	if verb, found := strings.CutPrefix(name, "git-"); found && len(verb) > 0 {
+		return fmt.Sprintf("exec(git:%s)", verb)
+	}
+
+	return fmt.Sprintf("exec(%s)", name)
 }
 
 // We only get an "exec_result" event if the `exec()` failed.
@@ -561,6 +804,7 @@ func apply__exec_result(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 
 	exec.lifetime.endTime = evt.mf_time
 	exec.exitcode = evt.pm_exec_result.mf_code
+	exec.endedByExit = false
 
 	return nil
 }
@@ -579,6 +823,18 @@ func apply__def_param(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	valNew := evt.pm_def_param.mf_value
 	priNew := get_scope_priority(evt.pm_def_param.pmf_scope)
 
+	// Don't even store denied params (unless we need them for filtering),
+	// so that they cannot accidentally leak into the logs.
+	var fs *FilterSettings
+	var ciIdParam string
+	if tr2.rcvr_base != nil {
+		fs = tr2.rcvr_base.RcvrConfig.getFilterSettings()
+		ciIdParam = tr2.rcvr_base.RcvrConfig.CIIdParam
+	}
+	if !shouldStoreParam(fs, ciIdParam, key) {
+		return nil
+	}
+
 	_, havePrevVal := tr2.process.paramSetValues[key]
 	priCur, havePrevPri := tr2.process.paramSetPriorities[key]
 
@@ -590,6 +846,11 @@ func apply__def_param(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 
 	tr2.process.paramSetValues[key] = valNew
 	tr2.process.paramSetPriorities[key] = priNew
+	if evt.pm_def_param.pmf_scope != nil {
+		tr2.process.paramSetScopes[key] = *evt.pm_def_param.pmf_scope
+	} else {
+		delete(tr2.process.paramSetScopes, key)
+	}
 
 	// We DO NOT try to lookup the filtering keys at this point
 	// because we don't know if this is final (highest priority)
@@ -628,7 +889,21 @@ func get_scope_priority(scope *string) int {
 
 func apply__def_repo(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	repoId := *evt.pmf_repo
-	tr2.process.repoSet[repoId] = evt.pm_def_repo.mf_worktree
+	worktree := evt.pm_def_repo.mf_worktree
+
+	// Git should only define each repo-id once.  If it re-defines one
+	// with a different worktree (perhaps in some submodule edge case),
+	// keep the first one, since the regions that we have already seen
+	// refer to it.
+	if prev, ok := tr2.process.repoSet[repoId]; ok {
+		if prev != worktree && tr2.rcvr_base != nil {
+			tr2.rcvr_base.Logger.Debug(fmt.Sprintf("[dsid %06d] ignoring redefinition of repo %d: '%s' (keeping '%s')",
+				tr2.datasetId, repoId, worktree, prev))
+		}
+		return nil
+	}
+
+	tr2.process.repoSet[repoId] = worktree
 
 	return nil
 }
@@ -666,16 +941,36 @@ func apply__region_enter(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 		return nil
 	}
 
+	if tr2.atLimit("regions", tr2.regionCount, tr2.limits().maxRegions, &tr2.truncatedRegions) {
+		// Ignore this region.  The nesting level checks will cause
+		// any nested regions and the corresponding "region_leave"
+		// to also be ignored.
+		return nil
+	}
+	tr2.regionCount++
+
 	r := &TrRegion{
 		lifetime: TrSpanEssentials{
 			selfSpanID:   tr2.NewSpanID(), // regions get a random SpanID
 			parentSpanID: th.lookupTopParentSpanID(),
 			startTime:    evt.mf_time,
-			displayName:  evt.pm_region_enter.makeRegionDisplayName(),
+			displayName:  evt.pm_region_enter.makeRegionDisplayName(tr2.regionNameNormalization()),
 		},
 	}
 
 	r.nestingLevel = evt.pm_region_enter.mf_nesting
+	if evt.pm_region_enter.pmf_category != nil {
+		r.category = *evt.pm_region_enter.pmf_category
+	}
+	if evt.pm_region_enter.pmf_label != nil {
+		r.label = *evt.pm_region_enter.pmf_label
+	}
+	if evt.pmf_file != nil {
+		r.file = *evt.pmf_file
+	}
+	if evt.pmf_line != nil {
+		r.line = *evt.pmf_line
+	}
 	if evt.pm_region_enter.pmf_msg != nil {
 		r.message = *evt.pm_region_enter.pmf_msg
 	}
@@ -696,22 +991,34 @@ func apply__region_enter(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	return nil
 }
 
+// Get the region display name normalization settings, if any.
+func (tr2 *trace2Dataset) regionNameNormalization() *RegionNameNormalization {
+	if tr2.rcvr_base == nil {
+		return nil
+	}
+	return &tr2.rcvr_base.RcvrConfig.RegionNameNormalization
+}
+
 // Create a display name for the region.
-func (evt_re *TrEventRegionEnter) makeRegionDisplayName() string {
+func (evt_re *TrEventRegionEnter) makeRegionDisplayName(rnn *RegionNameNormalization) string {
+	return makeRegionDisplayName(evt_re.pmf_category, evt_re.pmf_label, rnn)
+}
+
+func makeRegionDisplayName(category *string, label *string, rnn *RegionNameNormalization) string {
 	var c string
 	var l string
 
 	// Technically, the category and label fields are optional,
 	// but are rarely ever omitted.
 
-	if evt_re.pmf_category != nil {
-		c = normalizeForRegionDisplayName(*evt_re.pmf_category)
+	if category != nil {
+		c = normalizeForRegionDisplayName(*category, rnn)
 	} else {
 		c = "C"
 	}
 
-	if evt_re.pmf_label != nil {
-		l = normalizeForRegionDisplayName(*evt_re.pmf_label)
+	if label != nil {
+		l = normalizeForRegionDisplayName(*label, rnn)
 	} else {
 		l = "L"
 	}
@@ -719,19 +1026,99 @@ func (evt_re *TrEventRegionEnter) makeRegionDisplayName() string {
 	return fmt.Sprintf("region(%s,%s)", c, l)
 }
 
+// Get the `region_leave_policy` from the config, if any.
+func (tr2 *trace2Dataset) regionLeavePolicy() regionLeavePolicy {
+	if tr2.rcvr_base == nil {
+		return regionLeavePreferEnter
+	}
+	return tr2.rcvr_base.RcvrConfig.regionLeavePolicy
+}
+
+// Pick between the category, label, and message values from the
+// "region_enter" (already in the region) and the "region_leave"
+// events according to the policy.  If the category or label changes,
+// recompute the display name.
+func (tr2 *trace2Dataset) reconcileRegionLeave(r *TrRegion, evt_rl *TrEventRegionLeave) {
+	policy := tr2.regionLeavePolicy()
+	if policy == regionLeavePreferEnter {
+		return
+	}
+
+	pick := func(cur *string, leave *string) bool {
+		if leave == nil || len(*leave) == 0 || *leave == *cur {
+			return false
+		}
+		if policy == regionLeavePreferNonEmpty && len(*cur) > 0 {
+			return false
+		}
+		*cur = *leave
+		return true
+	}
+
+	changedCategory := pick(&r.category, evt_rl.pmf_category)
+	changedLabel := pick(&r.label, evt_rl.pmf_label)
+	pick(&r.message, evt_rl.pmf_msg)
+
+	if changedCategory || changedLabel {
+		// We don't remember whether the "region_enter" omitted the
+		// category or label, so treat empty values as omitted.
+		var c, l *string
+		if len(r.category) > 0 {
+			c = &r.category
+		}
+		if len(r.label) > 0 {
+			l = &r.label
+		}
+		r.lifetime.displayName = makeRegionDisplayName(c, l, tr2.regionNameNormalization())
+	}
+}
+
+// RegionNameNormalization lets the operator turn off parts of the
+// region display name normalization.  The zero value gives the
+// builtin behavior (replace all of the classes of characters with
+// underscores and lowercase the result).
+type RegionNameNormalization struct {
+	KeepCase   bool `mapstructure:"keep_case"`
+	KeepSpaces bool `mapstructure:"keep_spaces"`
+	KeepDashes bool `mapstructure:"keep_dashes"`
+	KeepDots   bool `mapstructure:"keep_dots"`
+	KeepCommas bool `mapstructure:"keep_commas"`
+	KeepColons bool `mapstructure:"keep_colons"`
+	KeepParens bool `mapstructure:"keep_parens"`
+}
+
 // Trace2 region-enter events contain a "category" and "label"
 // field.  Both are somewhat free form.  (That wasn't the intent,
 // that is how they have evolved.)  Scrub them a little to help
 // make a display name that is easy to search for in the database.
-func normalizeForRegionDisplayName(value string) string {
-	value = strings.Replace(value, " ", "_", -1)
-	value = strings.Replace(value, "-", "_", -1)
-	value = strings.Replace(value, ".", "_", -1)
-	value = strings.Replace(value, ",", "_", -1)
-	value = strings.Replace(value, ":", "_", -1)
-	value = strings.Replace(value, "(", "_", -1)
-	value = strings.Replace(value, ")", "_", -1)
-	value = strings.ToLower(value)
+// The settings are optional, so `rnn` may be nil.
+func normalizeForRegionDisplayName(value string, rnn *RegionNameNormalization) string {
+	if rnn == nil {
+		rnn = &RegionNameNormalization{}
+	}
+
+	if !rnn.KeepSpaces {
+		value = strings.Replace(value, " ", "_", -1)
+	}
+	if !rnn.KeepDashes {
+		value = strings.Replace(value, "-", "_", -1)
+	}
+	if !rnn.KeepDots {
+		value = strings.Replace(value, ".", "_", -1)
+	}
+	if !rnn.KeepCommas {
+		value = strings.Replace(value, ",", "_", -1)
+	}
+	if !rnn.KeepColons {
+		value = strings.Replace(value, ":", "_", -1)
+	}
+	if !rnn.KeepParens {
+		value = strings.Replace(value, "(", "_", -1)
+		value = strings.Replace(value, ")", "_", -1)
+	}
+	if !rnn.KeepCase {
+		value = strings.ToLower(value)
+	}
 
 	return value
 }
@@ -775,13 +1162,10 @@ func apply__region_leave(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 
 	r.lifetime.endTime = evt.mf_time
 
-	// TODO The region-leave event has optional category and label fields.
-	// These almost always match the values on the region-enter, but they
-	// don't have to.  Consider overriding them or somehow picking the
-	// "better" pair to keep in the region object.
-
-	// TODO Likewise, the region-leave has an optional message field.
-	// If set, should it override any message from the region-start?
+	// The region-leave event has optional category, label, and message
+	// fields.  These almost always match the values on the region-enter,
+	// but they don't have to.
+	tr2.reconcileRegionLeave(r, evt.pm_region_leave)
 
 	// I'm not going to set r.repoID here.  Let's assume that the repo-id
 	// on this "region_leave" event matches the value that we saw on the
@@ -789,6 +1173,7 @@ func apply__region_leave(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 
 	tr2.completedRegions = append(tr2.completedRegions, r)
 	th.regionStack = th.regionStack[:rCount-1]
+	th.lastCompletedRegion = r
 
 	return nil
 }
@@ -805,6 +1190,13 @@ func apply__data_generic(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	// nesting level n-1 is stored at regionStack[n-2] (assuming the
 	// Git process properly sets things up).
 
+	// Git may emit the "rusage" data events at any nesting level,
+	// but they always describe the process.
+	if evt.pm_generic_data.mf_category == rusageDataCategory {
+		tr2.process.rusage.setDataValue(evt.pm_generic_data.mf_key,
+			evt.pm_generic_data.mf_generic_value)
+	}
+
 	if evt.pm_generic_data.mf_nesting <= 1 {
 		tr2.process.setGenericDataValue(evt.pm_generic_data.mf_category,
 			evt.pm_generic_data.mf_key, evt.pm_generic_data.mf_generic_value)
@@ -812,25 +1204,63 @@ func apply__data_generic(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	}
 
 	// Find the associated thread and region for this data event.
-	// Ignore the event if we can't find where to attach it.
+	// Ignore the event if we can't find where to attach it (unless
+	// we are being lenient).
 	th, ok := tr2.lookupThread(evt.mf_thread)
 	if !ok || th == nil {
 		// TODO log debug warning.
-		return nil
+		return tr2.applyOrphanedData(evt)
 	}
-	rWant := evt.pm_generic_data.mf_nesting - 2
-	if int64(len(th.regionStack)) < rWant {
+	r := th.lookupDataRegion(evt.pm_generic_data.mf_nesting)
+	if r == nil {
 		// TODO log debug warning.
-		return nil
+		return tr2.applyOrphanedData(evt)
 	}
-	r := th.regionStack[rWant]
-	if r.nestingLevel != evt.pm_generic_data.mf_nesting-1 {
-		// TODO log debug warning.
+
+	r.setGenericDataValue(evt.pm_generic_data.mf_category,
+		evt.pm_generic_data.mf_key, evt.pm_generic_data.mf_generic_value)
+
+	return nil
+}
+
+// Find the region that contains a data event with this nesting level
+// on this thread.  Normally, this is an open region on the region
+// stack, but if the data event arrived just after the corresponding
+// "region_leave" event, it is the region we just closed.
+func (th *TrThread) lookupDataRegion(nesting int64) *TrRegion {
+	rWant := nesting - 2
+	if int64(len(th.regionStack)) > rWant {
+		r := th.regionStack[rWant]
+		if r.nestingLevel == nesting-1 {
+			return r
+		}
+	}
+
+	r := th.lastCompletedRegion
+	if r != nil && r.nestingLevel == nesting-1 {
+		return r
+	}
+
+	return nil
+}
+
+// The category prefix used for region data events that were attached
+// to the process because we could not find the region.
+const orphanedDataCategoryPrefix string = "orphaned:"
+
+// A region-level data event could not be matched with its region
+// (either an open one or the one most recently closed on the thread).
+// Normally we drop it, but in lenient mode we attach
+// it to the process so that the value isn't lost.
+func (tr2 *trace2Dataset) applyOrphanedData(evt *TrEvent) (err error) {
+	if tr2.rcvr_base == nil || !tr2.rcvr_base.RcvrConfig.LenientRegionData {
 		return nil
 	}
 
-	r.setGenericDataValue(evt.pm_generic_data.mf_category,
+	tr2.process.setGenericDataValue(
+		orphanedDataCategoryPrefix+evt.pm_generic_data.mf_category,
 		evt.pm_generic_data.mf_key, evt.pm_generic_data.mf_generic_value)
+	tr2.process.orphanedDataCount++
 
 	return nil
 }
@@ -880,10 +1310,67 @@ func apply__timer(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	return nil
 }
 
-func apply__th_timer(tr2 *trace2Dataset, evt *TrEvent) (err error) {
+// Lookup the thread for a "th_timer" or "th_counter" event.  Git
+// normally emits these just before the "thread_exit", but if one
+// arrives before the "thread_start" (such as when events from
+// different threads are interleaved), buffer it until the thread
+// starts.
+func (tr2 *trace2Dataset) lookupThreadForMetrics(evt *TrEvent) (*TrThread, bool) {
 	th, ok := tr2.lookupThread(evt.mf_thread)
-	if !ok || th == nil {
-		// TODO log debug warning.
+	if ok && th != nil {
+		return th, true
+	}
+
+	th, ok = tr2.pendingThreads[evt.mf_thread]
+	if ok {
+		th.lifetime.endTime = evt.mf_time
+		return th, true
+	}
+
+	// Don't let a misbehaving client create an unlimited number of
+	// pending threads, either.
+	if tr2.atLimit("threads", len(tr2.threads)+len(tr2.pendingThreads),
+		tr2.limits().maxThreads, &tr2.truncatedThreads) {
+		return nil, false
+	}
+
+	if tr2.pendingThreads == nil {
+		tr2.pendingThreads = make(map[string]*TrThread)
+	}
+	th = new(TrThread)
+	th.lifetime.startTime = evt.mf_time
+	th.lifetime.endTime = evt.mf_time
+	tr2.pendingThreads[evt.mf_thread] = th
+
+	return th, true
+}
+
+// If a thread sent timers or counters, but we never saw its
+// "thread_start" event, create a thread for it (spanning the time
+// of the buffered events) so that we don't lose them.
+func (tr2 *trace2Dataset) synthesizePendingThreads() {
+	// Sort the names so that the SpanIDs are repeatable.
+	var names []string
+	for name := range tr2.pendingThreads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		pending := tr2.pendingThreads[name]
+		pending.lifetime.selfSpanID = tr2.NewSpanID()
+		pending.lifetime.parentSpanID = tr2.process.mainThread.lifetime.selfSpanID
+		pending.lifetime.displayName = name
+
+		tr2.threads[name] = pending
+	}
+
+	tr2.pendingThreads = nil
+}
+
+func apply__th_timer(tr2 *trace2Dataset, evt *TrEvent) (err error) {
+	th, ok := tr2.lookupThreadForMetrics(evt)
+	if !ok {
 		return nil
 	}
 
@@ -920,9 +1407,8 @@ func apply__counter(tr2 *trace2Dataset, evt *TrEvent) (err error) {
 	return nil
 }
 func apply__th_counter(tr2 *trace2Dataset, evt *TrEvent) (err error) {
-	th, ok := tr2.lookupThread(evt.mf_thread)
-	if !ok || th == nil {
-		// TODO log debug warning.
+	th, ok := tr2.lookupThreadForMetrics(evt)
+	if !ok {
 		return nil
 	}
 
@@ -12,11 +12,24 @@ package trace2receiver
 // the trace/span set (tested at a higer level).
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // Well-known values for mostly constant fields in the data stream.
@@ -124,6 +137,22 @@ func x_make_atexit() string {
 		x_make_t_abs(),
 		x_exit_code)
 }
+func x_make_exit_code(event_name string, code int64) string {
+	return fmt.Sprintf(`{%s,"t_abs":%.6f,"code":%d}`,
+		x_make_common(
+			event_name,
+			x_main),
+		x_make_t_abs(),
+		code)
+}
+func x_make_signal(signo int64) string {
+	return fmt.Sprintf(`{%s,"t_abs":%.6f,"signo":%d}`,
+		x_make_common(
+			"signal",
+			x_main),
+		x_make_t_abs(),
+		signo)
+}
 func x_make_error(m string, f string) string {
 	return fmt.Sprintf(`{%s,"msg":"%s","fmt":"%s"}`,
 		x_make_common(
@@ -132,6 +161,13 @@ func x_make_error(m string, f string) string {
 		m,
 		f)
 }
+func x_make_printf(m string) string {
+	return fmt.Sprintf(`{%s,"msg":"%s"}`,
+		x_make_common(
+			"printf",
+			x_main),
+		m)
+}
 func x_make_cmd_path() string {
 	return fmt.Sprintf(`{%s,"path":"%s"}`,
 		x_make_common(
@@ -212,6 +248,17 @@ func x_make_hook_child_start(id int64, class string, hook string, a0 string, a1
 		"false", // we don't care about "use_shell", but it is required in the format
 		fmt.Sprintf(`["%s","%s"]`, a0, a1))
 }
+func x_make_child_start_cd(id int64, class string, cd string, a0 string, a1 string) string {
+	return fmt.Sprintf(`{%s,"child_id":%d,"child_class":"%s","cd":"%s","use_shell":%s,"argv":%s}`,
+		x_make_common(
+			"child_start",
+			x_main),
+		id,
+		class,
+		cd,
+		"false", // we don't care about "use_shell", but it is required in the format
+		fmt.Sprintf(`["%s","%s"]`, a0, a1))
+}
 func x_make_child_exit(id int64, pid int64, code int64) string {
 	return fmt.Sprintf(`{%s,"child_id":%d,"pid":%d,"code":%d,"t_rel":%.6f}`,
 		x_make_common(
@@ -222,6 +269,16 @@ func x_make_child_exit(id int64, pid int64, code int64) string {
 		code,
 		1.0)
 }
+func x_make_child_ready(id int64, pid int64, ready string) string {
+	return fmt.Sprintf(`{%s,"child_id":%d,"pid":%d,"ready":"%s","t_rel":%.6f}`,
+		x_make_common(
+			"child_ready",
+			x_main),
+		id,
+		pid,
+		ready,
+		1.0)
+}
 func x_make_exec(id int64, exe string, a0 string, a1 string) string {
 	return fmt.Sprintf(`{%s,"exec_id":%d,"exe":"%s","argv":%s}`,
 		x_make_common(
@@ -309,6 +366,27 @@ func x_make_counter(category string, name string, count int64) string {
 		name,
 		count)
 }
+func x_make_th_timer(thread_name string, category string, name string, intervals int64, t_total float64, t_min float64, t_max float64) string {
+	return fmt.Sprintf(`{%s,"category":"%s","name":"%s","intervals":%d,"t_total":%.6f,"t_min":%.6f,"t_max":%.6f}`,
+		x_make_common(
+			"th_timer",
+			thread_name),
+		category,
+		name,
+		intervals,
+		t_total,
+		t_min,
+		t_max)
+}
+func x_make_th_counter(thread_name string, category string, name string, count int64) string {
+	return fmt.Sprintf(`{%s,"category":"%s","name":"%s","count":%d}`,
+		x_make_common(
+			"th_counter",
+			thread_name),
+		category,
+		name,
+		count)
+}
 func x_make_thread_start(thread_name string) string {
 	return fmt.Sprintf(`{%s}`,
 		x_make_common(
@@ -379,6 +457,11 @@ func Test_Dataset_Basic(t *testing.T) {
 	assert.Equal(t, tr2.process.cmdAliasValue[0], "v0")
 	assert.Equal(t, tr2.process.cmdAliasValue[1], "v1")
 
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2CmdIsAlias))
+	assert.True(t, ok)
+	assert.True(t, v.Bool())
+
 	// repoSet is a Map/Set with integer keys, rather than an Array.
 	assert.Equal(t, len(tr2.process.repoSet), 2)
 	assert.Equal(t, tr2.process.repoSet[1], x_repo_1_worktree)
@@ -500,6 +583,40 @@ func Test_Dataset_DefParam_Scoped(t *testing.T) {
 	assert.Equal(t, tr2.process.paramSetValues["bar"], x_param_local_bar)
 }
 
+// Verify that we remember the scope of the winning value for each
+// parameter and only emit the scopes at verbose detail.
+func Test_Dataset_DefParam_Scopes(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_def_param("local", "foo", x_param_local_foo),
+		x_make_def_param("submodule", "foo", "sub"),
+		x_make_def_param("worktree", "bar", x_param_local_bar),
+		x_make_def_param("global", "bar", x_param_global_bar),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.Equal(t, "sub", tr2.process.paramSetValues["foo"])
+	assert.Equal(t, "submodule", tr2.process.paramSetScopes["foo"])
+	assert.Equal(t, x_param_local_bar, tr2.process.paramSetValues["bar"])
+	assert.Equal(t, "worktree", tr2.process.paramSetScopes["bar"])
+
+	sm := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2ParamScopes))
+	assert.True(t, ok)
+	assert.Equal(t, `{"bar":"worktree","foo":"submodule"}`, v.Str())
+
+	sm = tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	_, ok = sm.Get(string(Trace2ParamScopes))
+	assert.False(t, ok)
+}
+
 func Test_Dataset_ChildProcesses(t *testing.T) {
 
 	var events []string = []string{
@@ -555,6 +672,70 @@ func Test_Dataset_ChildProcesses(t *testing.T) {
 	// TODO Consider testing other child-classes and the display name construction.
 	// Especially "cred".
 }
+
+func Test_Dataset_ChildDisplayNameIndex(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_hook_child_start(0, "hook", "pre-commit", "hh0", "hh1"),
+		x_make_hook_child_start(1, "hook", "pre-commit", "hh0", "hh1"),
+		x_make_hook_child_start(2, "hook", "post-commit", "hh0", "hh1"),
+		x_make_hook_child_start(3, "hook", "pre-commit", "hh0", "hh1"),
+
+		x_make_child_exit(0, 100, 0),
+		x_make_child_exit(1, 101, 0),
+		x_make_child_exit(2, 102, 0),
+		x_make_child_exit(3, 103, 0),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.Equal(t, "child(hook:pre-commit)", tr2.children[0].lifetime.displayName)
+	assert.Equal(t, "child(hook:pre-commit)#2", tr2.children[1].lifetime.displayName)
+	assert.Equal(t, "child(hook:post-commit)", tr2.children[2].lifetime.displayName)
+	assert.Equal(t, "child(hook:pre-commit)#3", tr2.children[3].lifetime.displayName)
+}
+
+func Test_Dataset_ChildCd(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_child_start(0, "class-0", "aa0", "bb0"),
+		x_make_child_start_cd(1, "class-1", "/tmp/wt", "aa1", "bb1"),
+
+		x_make_child_exit(0, 123, 0),
+		x_make_child_exit(1, 456, 0),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.Equal(t, "", tr2.children[0].cd)
+	assert.Equal(t, "/tmp/wt", tr2.children[1].cd)
+
+	traces := tr2.ToTraces(DetailLevelProcess)
+	spans := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 3, spans.Len()) // process and two children
+	for k := 1; k < spans.Len(); k++ {
+		pid, _ := spans.At(k).Attributes().Get(string(Trace2ChildPid))
+		cd, ok := spans.At(k).Attributes().Get(string(Trace2ChildCd))
+		if pid.Str() == "456" {
+			assert.True(t, ok)
+			assert.Equal(t, "/tmp/wt", cd.Str())
+		} else {
+			assert.False(t, ok)
+		}
+	}
+}
 func Test_Dataset_Regions_Main(t *testing.T) {
 
 	var events []string = []string{
@@ -725,6 +906,90 @@ func Test_Dataset_Timers_Main(t *testing.T) {
 	assert.True(t, float_is_near(sw1.Max_sec, 2.0))
 }
 
+// Verify that the per-thread timers and counters of a non-main
+// thread are emitted on the thread span (only at dl:verbose).
+func Test_Dataset_Timers_Thread(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_thread_start("th01:preload"),
+		x_make_th_timer("th01:preload", "cat", "tmr-1", 5, 4.0, 1.0, 2.0),
+		x_make_th_counter("th01:preload", "cat", "ctr-1", 7),
+		x_make_thread_exit("th01:preload"),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	th, ok := tr2.threads["th01:preload"]
+	assert.True(t, ok)
+	assert.Nil(t, tr2.process.timers)
+	assert.Nil(t, tr2.process.counters)
+
+	sw1, ok := th.timers["cat"]["tmr-1"]
+	assert.True(t, ok)
+	assert.Equal(t, sw1.Intervals, int64(5))
+	assert.True(t, float_is_near(sw1.Total_sec, 4.0))
+	assert.Equal(t, th.counters["cat"]["ctr-1"], int64(7))
+
+	// The thread span is the one after the process span.
+	spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 2, spans.Len())
+	sm := spans.At(1).Attributes()
+
+	v, ok := sm.Get(string(Trace2ThreadTimers))
+	assert.True(t, ok)
+	assert.Equal(t, `{"cat":{"tmr-1":{"intervals":5,"total_sec":4,"min_sec":1,"max_sec":2}}}`, v.Str())
+
+	v, ok = sm.Get(string(Trace2ThreadCounters))
+	assert.True(t, ok)
+	assert.Equal(t, `{"cat":{"ctr-1":7}}`, v.Str())
+
+	// No thread spans below dl:verbose.
+	spans = tr2.ToTraces(DetailLevelProcess).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 1, spans.Len())
+}
+
+// Verify that per-thread timers and counters that arrive before the
+// "thread_start" are buffered and moved to the thread when it starts,
+// and that a thread is synthesized if it never starts.
+func Test_Dataset_Counters_Thread_Early(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_th_counter("th01:preload", "cat", "ctr-1", 7),
+		x_make_thread_start("th01:preload"),
+		x_make_thread_exit("th01:preload"),
+
+		x_make_th_timer("th02:orphan", "cat", "tmr-1", 5, 4.0, 1.0, 2.0),
+		x_make_th_counter("th02:orphan", "cat", "ctr-2", 3),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.Nil(t, tr2.pendingThreads)
+
+	th, ok := tr2.threads["th01:preload"]
+	assert.True(t, ok)
+	assert.Equal(t, int64(7), th.counters["cat"]["ctr-1"])
+
+	th, ok = tr2.threads["th02:orphan"]
+	assert.True(t, ok)
+	assert.Equal(t, "th02:orphan", th.lifetime.displayName)
+	assert.Equal(t, tr2.process.mainThread.lifetime.selfSpanID, th.lifetime.parentSpanID)
+	assert.False(t, th.lifetime.isIncomplete())
+	assert.Equal(t, int64(5), th.timers["cat"]["tmr-1"].Intervals)
+	assert.Equal(t, int64(3), th.counters["cat"]["ctr-2"])
+}
+
 func Test_Dataset_Counters_Main(t *testing.T) {
 
 	var events []string = []string{
@@ -756,6 +1021,46 @@ func Test_Dataset_Counters_Main(t *testing.T) {
 	assert.Equal(t, ctr1, int64(5))
 }
 
+func Test_Dataset_ToMetrics(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_timer("cat", "tmr-1", 5, 4.0, 1.0, 2.0),
+		x_make_counter("cat", "ctr-1", 5),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	pm := tr2.ToMetrics()
+	assert.Equal(t, pm.ResourceMetrics().Len(), 1)
+
+	metrics := pm.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	assert.Equal(t, metrics.Len(), 2)
+
+	mt := metrics.At(0)
+	assert.Equal(t, mt.Name(), metricNameProcessTimer)
+	assert.Equal(t, mt.Type(), pmetric.MetricTypeHistogram)
+	hdp := mt.Histogram().DataPoints().At(0)
+	assert.Equal(t, hdp.Count(), uint64(5))
+	assert.True(t, float_is_near(hdp.Sum(), 4.0))
+	v, _ := hdp.Attributes().Get(string(Trace2MetricName))
+	assert.Equal(t, v.Str(), "tmr-1")
+
+	mc := metrics.At(1)
+	assert.Equal(t, mc.Name(), metricNameProcessCounter)
+	assert.Equal(t, mc.Type(), pmetric.MetricTypeSum)
+	assert.True(t, mc.Sum().IsMonotonic())
+	sdp := mc.Sum().DataPoints().At(0)
+	assert.Equal(t, sdp.IntValue(), int64(5))
+	v, _ = sdp.Attributes().Get(string(Trace2MetricCategory))
+	assert.Equal(t, v.Str(), "cat")
+}
+
 func Test_Dataset_Threads(t *testing.T) {
 	var events []string = []string{
 		x_make_version(),
@@ -833,6 +1138,71 @@ func Test_Dataset_ThreadRegions(t *testing.T) {
 	assert.Equal(t, r_2.lifetime.parentSpanID, th01.lifetime.selfSpanID)
 }
 
+// Verify that we keep the first worktree when a repo-id is redefined.
+func Test_Dataset_DefRepo_Redefined(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_def_repo(1, x_repo_1_worktree),
+		x_make_def_repo(1, x_repo_3_worktree),
+		x_make_def_repo(1, x_repo_1_worktree),
+
+		x_make_atexit(), // Should be last
+	}
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	tr2 := NewTrace2Dataset(&Rcvr_Base{
+		Logger:     zap.New(core),
+		RcvrConfig: &Config{},
+	})
+
+	for _, s := range events {
+		evt, err := parse_json([]byte(s))
+		assert.Nil(t, err)
+		assert.Nil(t, evt_apply(tr2, evt))
+	}
+
+	assert.Equal(t, 1, len(tr2.process.repoSet))
+	assert.Equal(t, x_repo_1_worktree, tr2.process.repoSet[1])
+
+	// Only the different worktree is reported.
+	assert.Equal(t, 1, logs.FilterMessageSnippet("ignoring redefinition of repo 1").Len())
+}
+
+// Verify that we infer whether the command ran in a worktree or
+// outside of any repo (and say nothing if we can't tell).
+func Test_Dataset_RepoContext(t *testing.T) {
+	var tests = []struct {
+		events   []string
+		expected string
+	}{
+		{[]string{x_make_cmd_name(), x_make_def_repo(1, x_repo_1_worktree)}, "worktree"},
+		{[]string{x_make_cmd_name()}, ""},
+		{[]string{x_make_cmd_name_nh("upload-pack", "upload-pack")}, ""},
+		{[]string{x_make_cmd_name_nh("version", "version")}, "none"},
+		{[]string{x_make_cmd_name_nh("help", "help")}, "none"},
+		{[]string{}, ""},
+	}
+
+	for _, test := range tests {
+		events := append([]string{x_make_version(), x_make_start()}, test.events...)
+		events = append(events, x_make_atexit())
+
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient)
+		assert.Equal(t, test.expected, tr2.process.repoContext)
+
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, ok := sm.Get(string(Trace2CmdRepoContext))
+		assert.Equal(t, len(test.expected) > 0, ok)
+		if ok {
+			assert.Equal(t, test.expected, v.Str())
+		}
+	}
+}
+
 // Verify that we saw sufficient event data to generate telemetry.
 func Test_Dataset_HaveStart(t *testing.T) {
 
@@ -966,34 +1336,2296 @@ func Test_Dataset_Exec(t *testing.T) {
 	assert.Equal(t, tr2.exec[0].argv[1], "a1")
 }
 
-// Given an array of raw Trace2 messages, parse and appy them
-// to a newly created dataset.
-func load_test_dataset(t *testing.T, events []string) (tr2 *trace2Dataset, sufficient bool, err error) {
-	tr2 = NewTrace2Dataset(nil)
+// Verify that an exec of a dashed Git command is classified as such
+// and that an exec of an external tool just uses the basename.
+func Test_Dataset_Exec_DisplayNames(t *testing.T) {
 
-	for _, s := range events {
-		// Use `parse_json()` rather than `evt_parse()` to avoid
-		// all of the `rcvr_Base` setup.  This also bypasses the
-		// helper tool.
-		evt, err := parse_json([]byte(s))
-		if err != nil {
-			t.Fatalf("parse of '%s' failed: %s", s, err.Error())
-		}
-		if evt == nil {
-			// An ignored event
-			continue
-		}
+	var events []string = []string{
+		x_make_version(),
+		x_make_start_argv1("xx"),
+		x_make_cmd_name_nh("foo", "qq"),
 
-		err = evt_apply(tr2, evt)
-		if err != nil {
-			if rce, ok := err.(*RejectClientError); ok {
-				return nil, false, rce
-			}
-			t.Fatalf("apply of '%s' failed: %s", s, err.Error())
-		}
+		x_make_exec(0, "/usr/libexec/git-core/git-remote-https", "a0", "a1"),
+		x_make_exec(1, "/usr/bin/vim", "b0", "b1"),
+
+		x_make_atexit(), // should be last
 	}
 
-	sufficient = tr2.prepareDataset()
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
 
-	return tr2, sufficient, nil
+	assert.Equal(t, len(tr2.exec), 2)
+	assert.Equal(t, tr2.exec[0].lifetime.displayName, "exec(git:remote-https)")
+	assert.Equal(t, tr2.exec[1].lifetime.displayName, "exec(vim)")
+}
+
+// Verify that an exec without an "exec_result" (such as on Windows,
+// where the current process waits for the replacement process and
+// then exits normally) is closed at the "atexit" time.
+func Test_Dataset_Exec_Atexit(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_exec(0, "git", "a0", "a1"),
+		x_make_atexit(),
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	exec := tr2.exec[0]
+	assert.NotNil(t, exec)
+	assert.True(t, exec.endedByExit)
+	assert.Equal(t, tr2.process.atexitTime, exec.lifetime.endTime)
+	assert.True(t, exec.lifetime.endTime.After(exec.lifetime.startTime))
+	assert.False(t, tr2.process.incomplete)
+
+	spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 2, spans.Len())
+	execSpan := spans.At(1)
+	assert.True(t, execSpan.EndTimestamp() > execSpan.StartTimestamp())
+	_, ok := execSpan.Attributes().Get(string(Trace2SpanClockSkew))
+	assert.False(t, ok)
+
+	// If the "exit" and "atexit" events arrive out of order, the exec
+	// span ends at the later one (like the process does).
+	events = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_exec(0, "git", "a0", "a1"),
+		x_make_exit_code("exit", 0),
+	}
+	x_time_now = x_time_now.Add(-10 * time.Second)
+	events = append(events, x_make_exit_code("atexit", 0))
+
+	tr2, sufficient, _ = load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.True(t, tr2.process.atexitTime.Before(tr2.process.exitTime))
+	assert.Equal(t, tr2.process.exitTime, tr2.process.mainThread.lifetime.endTime)
+	assert.Equal(t, tr2.process.exitTime, tr2.exec[0].lifetime.endTime)
+}
+
+// Verify that the simple form is used when requested.
+func Test_Dataset_Exec_SimpleDisplayNames(t *testing.T) {
+	exe := "/usr/libexec/git-core/git-remote-https"
+	evt := &TrEvent{
+		pm_exec: &TrEventExec{
+			pmf_exe: &exe,
+		},
+	}
+
+	assert.Equal(t, evt.pm_exec.makeExecDisplayName(true), "exec(git-remote-https)")
+	assert.Equal(t, evt.pm_exec.makeExecDisplayName(false), "exec(git:remote-https)")
+}
+
+// Verify that well-known data values are extracted from both
+// process-level and region-level data events.
+func Test_Dataset_WellKnownData(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_data_intmax(x_main, 1, "fsync", "fsync/hardware-flush", 7),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_data_intmax(x_main, 2, "index", "read/cache_nr", 1234),
+		x_make_data_string(x_main, 2, "my-cat", "my-key", "42"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	tr2.extractWellKnownData([]DataValueRule{
+		{Category: "my-cat", Key: "my-key", Attribute: "my.attr"},
+	})
+
+	assert.Equal(t, 3, len(tr2.process.wellKnownData))
+	assert.Equal(t, int64(7), tr2.process.wellKnownData["trace2.data.fsync.hardware_flush"])
+	assert.Equal(t, int64(1234), tr2.process.wellKnownData["trace2.data.index.entries"])
+	assert.Equal(t, int64(42), tr2.process.wellKnownData["my.attr"])
+}
+
+// Verify that the derived rates are computed from the process duration
+// and are omitted when the duration is zero.
+func Test_Dataset_WellKnownDataRates(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+
+		x_make_region_enter(x_main, 1, "progress", "Receiving objects", ""),
+		x_make_data_intmax(x_main, 2, "progress", "total_bytes", 4096),
+		x_make_region_leave(x_main, 1, "progress", "Receiving objects", ""),
+		x_make_data_intmax(x_main, 1, "my-cat", "my-key", 10),
+
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	lt := &tr2.process.mainThread.lifetime
+	lt.endTime = lt.startTime.Add(2 * time.Second)
+
+	tr2.extractWellKnownData([]DataValueRule{
+		{Category: "my-cat", Key: "my-key", Attribute: "my.attr", Rate: true},
+	})
+
+	assert.Equal(t, int64(4096), tr2.process.wellKnownData["trace2.data.transfer.bytes"])
+	assert.Equal(t, 2, len(tr2.process.wellKnownRates))
+	assert.Equal(t, 2048.0, tr2.process.wellKnownRates["trace2.data.transfer.bytes_per_sec"])
+	assert.Equal(t, 5.0, tr2.process.wellKnownRates["my.attr_per_sec"])
+
+	process := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+	v, ok := process.Attributes().Get("trace2.data.transfer.bytes_per_sec")
+	assert.True(t, ok)
+	assert.Equal(t, 2048.0, v.Double())
+
+	tr2.process.wellKnownData = nil
+	tr2.process.wellKnownRates = nil
+	lt.endTime = lt.startTime
+
+	tr2.extractWellKnownData(nil)
+
+	assert.Equal(t, int64(4096), tr2.process.wellKnownData["trace2.data.transfer.bytes"])
+	assert.Equal(t, 0, len(tr2.process.wellKnownRates))
+}
+
+func Test_Dataset_UsedFSMonitor(t *testing.T) {
+
+	// A region in the builtin "fsm_client" category.
+	var events_region []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "fsm_client", "query", "m1"),
+		x_make_region_leave(x_main, 1, "fsm_client", "query", "m1"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events_region)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.True(t, tr2.process.usedFSMonitor)
+
+	// A "false" valued param is not an indicator.
+	var events_param_false []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_def_param("global", "core.fsmonitor", "false"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ = load_test_dataset(t, events_param_false)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.False(t, tr2.process.usedFSMonitor)
+
+	// A hook pathname is.
+	var events_param_hook []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_def_param("global", "core.fsmonitor", "/path/to/hook"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ = load_test_dataset(t, events_param_hook)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.True(t, tr2.process.usedFSMonitor)
+
+	// Custom indicators replace the builtin ones.
+	tr2, sufficient, _ = load_test_dataset(t, events_region)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.False(t, tr2.computeUsedFSMonitor(&FSMonitorIndicators{
+		Params: []string{"my.fsmonitor"},
+	}))
+	assert.True(t, tr2.computeUsedFSMonitor(&FSMonitorIndicators{
+		RegionCategories: []string{"fsm_client"},
+	}))
+}
+
+func Test_TruncateDisplayName(t *testing.T) {
+	var name string
+	var truncated bool
+
+	name, truncated = truncateDisplayName("region:index:do_read_index", 0)
+	assert.False(t, truncated)
+	assert.Equal(t, "region:index:do_read_index", name)
+
+	name, truncated = truncateDisplayName("region:index:do_read_index", 26)
+	assert.False(t, truncated)
+	assert.Equal(t, "region:index:do_read_index", name)
+
+	name, truncated = truncateDisplayName("region:index:do_read_index", 15)
+	assert.True(t, truncated)
+	assert.Equal(t, "region:index...", name)
+
+	name, truncated = truncateDisplayName("région:label", 5)
+	assert.True(t, truncated)
+	assert.Equal(t, "ré...", name)
+}
+
+func Test_TruncateAttributeValue(t *testing.T) {
+	var v string
+	var truncated bool
+
+	v, truncated = truncateAttributeValue("0123456789", 0)
+	assert.False(t, truncated)
+	assert.Equal(t, "0123456789", v)
+
+	v, truncated = truncateAttributeValue("0123456789", 8)
+	assert.True(t, truncated)
+	assert.Equal(t, "01234...", v)
+
+	// Do not split the 2 byte "é".
+	v, truncated = truncateAttributeValue("abé012345", 6)
+	assert.True(t, truncated)
+	assert.Equal(t, "ab...", v)
+
+	v, truncated = marshalArgv([]interface{}{"git", "status"}, 100)
+	assert.False(t, truncated)
+	assert.Equal(t, `["git","status"]`, v)
+
+	v, truncated = marshalArgv([]interface{}{"git", "commit", "-m", strings.Repeat("x", 50)}, 30)
+	assert.True(t, truncated)
+	assert.Equal(t, `["git","commit","-m","..."]`, v)
+}
+
+// Verify that long attribute values are truncated (except at `dl:raw`).
+func Test_Dataset_MaxAttributeBytes(t *testing.T) {
+	long := strings.Repeat("x", 100)
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start_av(fmt.Sprintf(`["git","commit","-m","%s"]`, long)),
+		x_make_atexit(),
+	}
+
+	for _, dl := range []FilterDetailLevel{DetailLevelSummary, DetailLevelRaw} {
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient, "have sufficient data")
+		tr2.rcvr_base = &Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{MaxAttributeBytes: 40},
+		}
+
+		sm := tr2.ToTraces(dl).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		argv, _ := sm.Get(string(Trace2CmdArgv))
+		flag, ok := sm.Get(string(Trace2CmdArgv) + attributeTruncatedSuffix)
+		if dl == DetailLevelRaw {
+			assert.False(t, ok)
+			assert.Contains(t, argv.Str(), long)
+		} else {
+			assert.True(t, ok)
+			assert.True(t, flag.Bool())
+			assert.Equal(t, `["git","commit","-m","..."]`, argv.Str())
+		}
+	}
+}
+
+func Test_Dataset_CounterCategoryTotals(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_counter("pack", "ctr-1", 5),
+		x_make_counter("pack", "ctr-2", 8),
+		x_make_counter("index", "ctr-3", 2),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	tr2.computeCounterCategoryTotals()
+
+	assert.Equal(t, 2, len(tr2.process.counterTotals))
+	assert.Equal(t, int64(13), tr2.process.counterTotals["pack"])
+	assert.Equal(t, int64(2), tr2.process.counterTotals["index"])
+}
+
+// Verify that we capture the "exit" and "atexit" codes separately
+// and that the last one wins.
+func Test_Dataset_ExitAndAtexit(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_exit_code("exit", 0),
+		x_make_exit_code("atexit", 3), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.Equal(t, int64(3), tr2.process.exeExitCode)
+
+	assert.False(t, tr2.process.exitTime.IsZero())
+	assert.Equal(t, int64(0), tr2.process.exitCode)
+
+	assert.False(t, tr2.process.atexitTime.IsZero())
+	assert.Equal(t, int64(3), tr2.process.atexitCode)
+
+	assert.True(t, tr2.process.atexitTime.After(tr2.process.exitTime))
+	assert.Equal(t, tr2.process.atexitTime, tr2.process.mainThread.lifetime.endTime)
+
+	process := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+	v, ok := process.Attributes().Get(string(Trace2CmdAtexitOverheadSec))
+	assert.True(t, ok)
+	assert.Equal(t, tr2.process.atexitTime.Sub(tr2.process.exitTime).Seconds(), v.Double())
+	assert.True(t, v.Double() > 0)
+}
+
+// Verify that the process end time is the later of the "exit" and
+// "atexit" events even if they arrive out of order.
+func Test_Dataset_ExitAndAtexit_OutOfOrder(t *testing.T) {
+
+	// The test clock advances as we create each event, so the
+	// "exit" event is earlier than the "atexit" event.  Apply them
+	// in the opposite order.
+	version := x_make_version()
+	start := x_make_start()
+	exit := x_make_exit_code("exit", 3)
+	atexit := x_make_exit_code("atexit", 0)
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{version, start, atexit, exit})
+	assert.True(t, sufficient, "have sufficient data")
+
+	// The code is still "last one wins".
+	assert.Equal(t, int64(3), tr2.process.exeExitCode)
+
+	assert.True(t, tr2.process.atexitTime.After(tr2.process.exitTime))
+	assert.Equal(t, tr2.process.atexitTime, tr2.process.mainThread.lifetime.endTime)
+}
+
+// Given an array of raw Trace2 messages, parse and appy them
+// to a newly created dataset.
+func load_test_dataset(t *testing.T, events []string) (tr2 *trace2Dataset, sufficient bool, err error) {
+	tr2 = NewTrace2Dataset(nil)
+
+	for _, s := range events {
+		// Use `parse_json()` rather than `evt_parse()` to avoid
+		// all of the `rcvr_Base` setup.  This also bypasses the
+		// helper tool.
+		evt, err := parse_json([]byte(s))
+		if err != nil {
+			t.Fatalf("parse of '%s' failed: %s", s, err.Error())
+		}
+		if evt == nil {
+			// An ignored event
+			continue
+		}
+
+		err = evt_apply(tr2, evt)
+		if err != nil {
+			if rce, ok := err.(*RejectClientError); ok {
+				return nil, false, rce
+			}
+			t.Fatalf("apply of '%s' failed: %s", s, err.Error())
+		}
+	}
+
+	sufficient = tr2.prepareDataset()
+
+	return tr2, sufficient, nil
+}
+
+// Verify that events received before the "version" event are held
+// and applied after it, so that the TraceID is properly set.
+func Test_Dataset_VersionNotFirst_Lenient(t *testing.T) {
+	start := x_make_start()
+	version := x_make_version()
+
+	rcvr_base := &Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{},
+	}
+	tr2 := NewTrace2Dataset(rcvr_base)
+	logger := rcvr_base.Logger
+
+	err := processRawLine([]byte(start), tr2, logger, false)
+	assert.Nil(t, err)
+	assert.Equal(t, len(tr2.process.cmdArgv), 0)
+	assert.Equal(t, int64(1), rcvr_base.stats.get(rcvrStatPreVersionEvents))
+
+	err = processRawLine([]byte(version), tr2, logger, false)
+	assert.Nil(t, err)
+	assert.True(t, tr2.sawVersion)
+	assert.Equal(t, len(tr2.preVersionEvents), 0)
+	assert.Equal(t, len(tr2.process.cmdArgv), 3)
+	assert.NotEqual(t, tr2.otelTraceID, [16]byte{})
+	assert.Equal(t, int64(1), rcvr_base.stats.get(rcvrStatPreVersionEvents))
+}
+
+// Verify that we only hold a limited number of events while waiting
+// for the "version" event and reject the client after that.
+func Test_Dataset_VersionNotFirst_Overflow(t *testing.T) {
+	rcvr_base := &Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{},
+	}
+	tr2 := NewTrace2Dataset(rcvr_base)
+
+	var err error
+	for k := 0; k < maxPreVersionEvents; k++ {
+		err = processRawLine([]byte(x_make_cmd_name()), tr2, rcvr_base.Logger, false)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, maxPreVersionEvents, len(tr2.preVersionEvents))
+
+	err = processRawLine([]byte(x_make_cmd_name()), tr2, rcvr_base.Logger, false)
+	_, ok := err.(*RejectClientError)
+	assert.True(t, ok)
+	assert.Equal(t, maxPreVersionEvents, len(tr2.preVersionEvents))
+
+	assert.Equal(t, int64(maxPreVersionEvents+1), rcvr_base.stats.get(rcvrStatPreVersionEvents))
+	assert.Equal(t, int64(1), rcvr_base.stats.get(rcvrStatClientsRejected))
+}
+
+// Verify that we reject the client when configured to require
+// the "version" event first.
+func Test_Dataset_VersionNotFirst_Strict(t *testing.T) {
+	start := x_make_start()
+
+	rcvr_base := &Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{RequireVersionFirst: true},
+	}
+	tr2 := NewTrace2Dataset(rcvr_base)
+
+	err := processRawLine([]byte(start), tr2, rcvr_base.Logger, false)
+	assert.NotNil(t, err)
+
+	_, ok := err.(*RejectClientError)
+	assert.True(t, ok)
+}
+
+// Verify that region data events that cannot be matched with a
+// region (open or just closed) are dropped by default and attached
+// to the process when configured to be lenient.
+func Test_Dataset_LenientRegionData(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_data_intmax(x_main, 3, "index", "read/cache_nr", 1234),
+		x_make_atexit(), // Should be last
+	}
+
+	for _, lenient := range []bool{false, true} {
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{LenientRegionData: lenient},
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+
+		if lenient {
+			assert.Equal(t, int64(1), tr2.process.orphanedDataCount)
+			assert.Equal(t, int64(1234),
+				tr2.process.dataValues["orphaned:index"]["read/cache_nr"])
+		} else {
+			assert.Equal(t, int64(0), tr2.process.orphanedDataCount)
+			assert.Nil(t, tr2.process.dataValues)
+		}
+	}
+}
+
+// Verify that a region data event that arrives just after its
+// region was closed is attached to that region.
+func Test_Dataset_LateRegionData(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_enter(x_main, 2, "index", "read_one", "m2"),
+		x_make_region_leave(x_main, 2, "index", "read_one", "m2"),
+		x_make_data_intmax(x_main, 3, "index", "read/cache_nr", 1234),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_data_intmax(x_main, 2, "index", "read/version", 4),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2 := NewTrace2Dataset(&Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{LenientRegionData: true},
+	})
+
+	for _, s := range events {
+		evt, err := parse_json([]byte(s))
+		assert.Nil(t, err)
+		assert.Nil(t, evt_apply(tr2, evt))
+	}
+
+	assert.Equal(t, 2, len(tr2.completedRegions))
+
+	inner := tr2.completedRegions[0]
+	assert.Equal(t, "read_one", inner.label)
+	assert.Equal(t, int64(1234), inner.dataValues["index"]["read/cache_nr"])
+
+	outer := tr2.completedRegions[1]
+	assert.Equal(t, "do_read_index", outer.label)
+	assert.Equal(t, int64(4), outer.dataValues["index"]["read/version"])
+
+	assert.Equal(t, int64(0), tr2.process.orphanedDataCount)
+}
+
+// Verify that the region display name normalization can be relaxed.
+func Test_Dataset_RegionNameNormalization(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "Index", "Read.Cache-Tree", "m1"),
+		x_make_region_leave(x_main, 1, "Index", "Read.Cache-Tree", "m1"),
+		x_make_atexit(), // Should be last
+	}
+
+	var tests = []struct {
+		rnn      RegionNameNormalization
+		expected string
+	}{
+		{RegionNameNormalization{}, "region(index,read_cache_tree)"},
+		{RegionNameNormalization{KeepCase: true}, "region(Index,Read_Cache_Tree)"},
+		{RegionNameNormalization{KeepDots: true, KeepDashes: true}, "region(index,read.cache-tree)"},
+	}
+
+	for _, test := range tests {
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{RegionNameNormalization: test.rnn},
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+
+		assert.Equal(t, 1, len(tr2.completedRegions))
+		assert.Equal(t, test.expected, tr2.completedRegions[0].lifetime.displayName)
+	}
+}
+
+// Verify the `region_leave_policy` choices.
+func Test_Dataset_RegionLeavePolicy(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "", "m1"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m2"),
+		x_make_atexit(), // Should be last
+	}
+
+	var tests = []struct {
+		policy      string
+		label       string
+		message     string
+		displayName string
+	}{
+		{"prefer-enter", "", "m1", "region(index,)"},
+		{"prefer-leave", "do_read_index", "m2", "region(index,do_read_index)"},
+		{"prefer-non-empty", "do_read_index", "m1", "region(index,do_read_index)"},
+	}
+
+	for _, test := range tests {
+		cfg := &Config{RegionLeavePolicy: test.policy}
+		var err error
+		cfg.regionLeavePolicy, err = parseRegionLeavePolicy(test.policy)
+		assert.Nil(t, err)
+
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: cfg,
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+
+		assert.Equal(t, 1, len(tr2.completedRegions), test.policy)
+		r := tr2.completedRegions[0]
+		assert.Equal(t, "index", r.category, test.policy)
+		assert.Equal(t, test.label, r.label, test.policy)
+		assert.Equal(t, test.message, r.message, test.policy)
+		assert.Equal(t, test.displayName, r.lifetime.displayName, test.policy)
+	}
+
+	_, err := parseRegionLeavePolicy("prefer-middle")
+	assert.NotNil(t, err)
+}
+
+// Verify the outcome classification and its precedence.
+func Test_Dataset_CmdOutcome(t *testing.T) {
+
+	var tests = []struct {
+		events  []string
+		custom  map[int64]string
+		outcome string
+	}{
+		{[]string{x_make_exit_code("atexit", 0)}, nil, CmdOutcomeOk},
+		{[]string{x_make_exit_code("atexit", 1)}, nil, CmdOutcomeError},
+		{[]string{x_make_error("oops", "oops"), x_make_exit_code("atexit", 0)}, nil, CmdOutcomeError},
+		{[]string{x_make_exit_code("atexit", 128)}, nil, CmdOutcomeFatal},
+		{[]string{x_make_exit_code("atexit", 129)}, nil, CmdOutcomeUsage},
+		{[]string{x_make_signal(13)}, nil, CmdOutcomeSignalled},
+		{[]string{}, nil, CmdOutcomeCrash},
+		{[]string{x_make_exit_code("atexit", 1)}, map[int64]string{1: CmdOutcomeUsage}, CmdOutcomeUsage},
+		{[]string{x_make_exit_code("atexit", 128)}, map[int64]string{128: "died"}, "died"},
+	}
+
+	for _, test := range tests {
+		events := append([]string{x_make_version(), x_make_start()}, test.events...)
+
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient, "have sufficient data")
+		assert.Equal(t, test.outcome, tr2.computeCmdOutcome(test.custom))
+	}
+
+	_, err := parseOutcomeExitCodes(map[string]string{"abc": CmdOutcomeFatal})
+	assert.NotNil(t, err)
+}
+
+// Verify that we track the earliest and latest event times, even
+// when events arrive out of order.
+func Test_Dataset_EventTimeRange(t *testing.T) {
+	rcvr_base := &Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{},
+	}
+	tr2 := NewTrace2Dataset(rcvr_base)
+
+	for _, s := range []string{x_make_version(), x_make_start(), x_make_atexit()} {
+		err := processRawLine([]byte(s), tr2, rcvr_base.Logger, false)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, 2*time.Second, tr2.lastEventTime.Sub(tr2.firstEventTime))
+
+	early := tr2.firstEventTime.Add(-time.Second)
+	tr2.updateEventTimeRange(early)
+	tr2.updateEventTimeRange(time.Time{})
+	assert.Equal(t, early, tr2.firstEventTime)
+	assert.Equal(t, 3*time.Second, tr2.lastEventTime.Sub(tr2.firstEventTime))
+}
+
+// Verify that child spans shorter than `min_child_ms` are omitted,
+// but that they are still counted in the child process rollups.
+func Test_Dataset_MinChildMs(t *testing.T) {
+
+	// The test clock advances 1 second for each event, so child 0
+	// runs for 1 second and child 1 runs for 3 seconds.
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_child_start(1, "class-1", "aa1", "bb1"),
+		x_make_child_start(0, "class-0", "aa0", "bb0"),
+		x_make_child_exit(0, 100, 0),
+		x_make_child_exit(1, 101, 0),
+		x_make_atexit(), // Should be last
+	}
+
+	for _, test := range []struct {
+		minChildMs int64
+		dl         FilterDetailLevel
+		nrSpans    int
+	}{
+		{0, DetailLevelVerbose, 3},
+		{2000, DetailLevelVerbose, 2},
+		{5000, DetailLevelVerbose, 1},
+		{5000, DetailLevelRaw, 3},
+	} {
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{MinChildMs: test.minChildMs},
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+		assert.True(t, tr2.prepareDataset())
+
+		spans := tr2.ToTraces(test.dl).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		assert.Equal(t, test.nrSpans, spans.Len(), test.minChildMs)
+
+		sm := spans.At(0).Attributes()
+		v, _ := sm.Get(string(Trace2ProcessChildCount))
+		assert.Equal(t, int64(2), v.Int(), test.minChildMs)
+		v, _ = sm.Get(string(Trace2ProcessChildTotalSec))
+		assert.Equal(t, 4.0, v.Double(), test.minChildMs)
+
+		if test.nrSpans == 2 {
+			// Only the longer child is emitted.
+			assert.Equal(t, tr2.children[1].lifetime.selfSpanID, [8]byte(spans.At(1).SpanID()))
+		}
+	}
+}
+
+// Verify that regions are collapsed into one aggregate span for each
+// (category, label) pair only when there are more than the threshold.
+func Test_Dataset_RegionSummary(t *testing.T) {
+
+	// The test clock advances 1 second for each event, so each of the
+	// "read" regions lasts 1 second.
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "read", "m1"),
+		x_make_region_leave(x_main, 1, "index", "read", "m1"),
+		x_make_region_enter(x_main, 1, "index", "write", "m2"),
+		x_make_region_enter(x_main, 2, "index", "read", "m3"),
+		x_make_region_leave(x_main, 2, "index", "read", "m3"),
+		x_make_region_leave(x_main, 1, "index", "write", "m2"),
+		x_make_region_enter(x_main, 1, "index", "read", "m4"),
+		x_make_region_leave(x_main, 1, "index", "read", "m4"),
+		x_make_atexit(), // Should be last
+	}
+
+	for _, test := range []struct {
+		threshold  int
+		nrSpans    int
+		summarized bool
+	}{
+		{0, 5, false},
+		{4, 5, false},
+		{3, 3, true},
+	} {
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger: zap.NewNop(),
+			RcvrConfig: &Config{
+				RegionSummarizeThreshold: test.threshold,
+			},
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+		assert.True(t, tr2.prepareDataset())
+		assert.Equal(t, 4, len(tr2.completedRegions))
+
+		spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		assert.Equal(t, test.nrSpans, spans.Len(), test.threshold)
+
+		_, ok := spans.At(0).Attributes().Get(string(Trace2ProcessRegionsSummarized))
+		assert.Equal(t, test.summarized, ok, test.threshold)
+
+		if !test.summarized {
+			continue
+		}
+
+		mainSpanID := tr2.process.mainThread.lifetime.selfSpanID
+		for k := 1; k < spans.Len(); k++ {
+			span := spans.At(k)
+			assert.Equal(t, mainSpanID, [8]byte(span.ParentSpanID()))
+
+			count, _ := span.Attributes().Get(string(Trace2RegionCount))
+			total, _ := span.Attributes().Get(string(Trace2RegionTotalSec))
+			switch span.Name() {
+			case "region(index,read)":
+				assert.Equal(t, int64(3), count.Int())
+				assert.Equal(t, 3.0, total.Double())
+			case "region(index,write)":
+				assert.Equal(t, int64(1), count.Int())
+				assert.Equal(t, 3.0, total.Double())
+			default:
+				assert.Fail(t, "unexpected span", span.Name())
+			}
+		}
+	}
+}
+
+// Verify that short regions are suppressed, that spans nested within
+// them are reparented, and that their data is promoted when requested.
+func Test_Dataset_RegionSuppression(t *testing.T) {
+
+	// The test clock advances 1 second for each event, so the inner
+	// region lasts 2 seconds and the outer region lasts 4 seconds.
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "outer", "outer", "m1"),
+		x_make_region_enter(x_main, 2, "inner", "inner", "m2"),
+		x_make_data_intmax(x_main, 3, "inner", "key", 42),
+		x_make_region_leave(x_main, 2, "inner", "inner", "m2"),
+		x_make_region_leave(x_main, 1, "outer", "outer", "m1"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2 := NewTrace2Dataset(&Rcvr_Base{
+		Logger: zap.NewNop(),
+		RcvrConfig: &Config{
+			MinRegionMs:                 3000,
+			PromoteSuppressedRegionData: true,
+		},
+	})
+
+	for _, s := range events {
+		evt, err := parse_json([]byte(s))
+		assert.Nil(t, err)
+		assert.Nil(t, evt_apply(tr2, evt))
+	}
+	assert.True(t, tr2.prepareDataset())
+	assert.Equal(t, 2, len(tr2.completedRegions))
+
+	var outer, inner *TrRegion
+	for _, r := range tr2.completedRegions {
+		if r.category == "outer" {
+			outer = r
+		} else {
+			inner = r
+		}
+	}
+
+	rs := tr2.computeRegionSuppression()
+	assert.NotNil(t, rs)
+	assert.False(t, rs.isSuppressed(outer))
+	assert.True(t, rs.isSuppressed(inner))
+	assert.Equal(t, outer.lifetime.selfSpanID, rs.resolveParent(inner.lifetime.selfSpanID))
+	assert.Equal(t, int64(42), rs.inheritedData[outer.lifetime.selfSpanID]["inner"]["key"])
+
+	traces := tr2.ToTraces(DetailLevelVerbose)
+	spans := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 2, spans.Len()) // process and outer region
+	v, ok := spans.At(1).Attributes().Get(string(Trace2RegionInheritedData))
+	assert.True(t, ok)
+	assert.Equal(t, `{"inner":{"key":42}}`, v.Str())
+	v, ok = spans.At(0).Attributes().Get(string(Trace2ProcessElidedRegionCount))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), v.Int())
+
+	// At `dl:raw` we ignore `min_region_ms` and emit both regions.
+	traces = tr2.ToTraces(DetailLevelRaw)
+	spans = traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 3, spans.Len()) // process, outer, and inner regions
+
+	dl, err := getDetailLevel(DetailLevelRawName)
+	assert.Nil(t, err)
+	assert.Equal(t, DetailLevelRaw, dl)
+}
+
+func Test_RoundSpanTime(t *testing.T) {
+	t0 := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+
+	assert.Equal(t, t0, roundSpanTime(t0, 0))
+	assert.Equal(t, t0, roundSpanTime(t0, time.Nanosecond))
+	assert.Equal(t, 123457000, roundSpanTime(t0, time.Microsecond).Nanosecond())
+	assert.Equal(t, 123000000, roundSpanTime(t0, time.Millisecond).Nanosecond())
+
+	for _, s := range []string{"", "ns", "us", "ms"} {
+		_, err := parseTimeResolution(s)
+		assert.Nil(t, err)
+	}
+	_, err := parseTimeResolution("s")
+	assert.NotNil(t, err)
+}
+
+// Verify that the CI build id is emitted only when the param is present.
+func Test_Dataset_CIBuildId(t *testing.T) {
+
+	for _, id := range []string{"", "build-1234"} {
+		events := []string{x_make_version(), x_make_start()}
+		if len(id) > 0 {
+			events = append(events, x_make_def_param("env", "GIT_CI_BUILD_ID", id))
+		}
+		events = append(events, x_make_atexit())
+
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient, "have sufficient data")
+
+		tr2.rcvr_base = &Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{CIIdParam: "GIT_CI_BUILD_ID"},
+		}
+
+		traces := tr2.ToTraces(DetailLevelSummary)
+		sm := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, ok := sm.Get(string(Trace2CIBuildId))
+		assert.Equal(t, len(id) > 0, ok)
+		if ok {
+			assert.Equal(t, id, v.Str())
+		}
+	}
+}
+
+// Verify that per-thread timers and counters are folded into the
+// process-level ones, except where Git already reported them.
+func Test_Dataset_FoldThreadMetrics(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_thread_start("th01:worker"),
+		x_make_thread_start("th02:worker"),
+		x_make_th_timer("th01:worker", "pack", "t1", 2, 0.5, 0.1, 0.4),
+		x_make_th_timer("th02:worker", "pack", "t1", 3, 0.9, 0.05, 0.6),
+		x_make_th_counter("th01:worker", "pack", "c1", 5),
+		x_make_th_counter("th02:worker", "pack", "c1", 7),
+		x_make_th_counter("th02:worker", "index", "c2", 4),
+		x_make_thread_exit("th01:worker"),
+		x_make_thread_exit("th02:worker"),
+		x_make_counter("index", "c2", 10),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	tr2.foldThreadMetrics()
+
+	t1 := tr2.process.timers["pack"]["t1"]
+	assert.Equal(t, int64(5), t1.Intervals)
+	assert.InDelta(t, 1.4, t1.Total_sec, 0.000001)
+	assert.InDelta(t, 0.05, t1.Min_sec, 0.000001)
+	assert.InDelta(t, 0.6, t1.Max_sec, 0.000001)
+
+	assert.Equal(t, int64(12), tr2.process.counters["pack"]["c1"])
+
+	// The process-level value from Git wins.
+	assert.Equal(t, int64(10), tr2.process.counters["index"]["c2"])
+}
+
+// Verify that we can reconstruct event times for clients in brief mode.
+func Test_Dataset_BriefMode(t *testing.T) {
+	sid := `"sid":"20230110T145742.956295Z-H0f5a2227-P00002b44"`
+	events := []string{
+		`{"event":"version",` + sid + `,"thread":"main","time":"2023-01-10T14:57:42.000000Z","evt":"3","exe":"2.38.1"}`,
+		`{"event":"start",` + sid + `,"thread":"main","t_abs":0.5,"argv":["git","status"]}`,
+		`{"event":"cmd_name",` + sid + `,"thread":"main","name":"status","hierarchy":"status"}`,
+		`{"event":"atexit",` + sid + `,"thread":"main","time":"2023-01-10T14:57:45.000000Z","t_abs":3.0,"code":0}`,
+	}
+
+	for _, allow := range []bool{false, true} {
+		rcvr_base := &Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{AllowBriefMode: allow},
+		}
+		tr2 := NewTrace2Dataset(rcvr_base)
+
+		var err error
+		for _, s := range events {
+			if err = processRawLine([]byte(s), tr2, rcvr_base.Logger, false); err != nil {
+				break
+			}
+		}
+
+		if !allow {
+			assert.NotNil(t, err)
+			continue
+		}
+
+		assert.Nil(t, err)
+		assert.True(t, tr2.prepareDataset())
+
+		t0 := time.Date(2023, 1, 10, 14, 57, 42, 0, time.UTC)
+		assert.Equal(t, t0, tr2.process.mainThread.lifetime.startTime)
+		assert.Equal(t, t0.Add(3*time.Second), tr2.process.mainThread.lifetime.endTime)
+		assert.Equal(t, t0, tr2.firstEventTime)
+
+		assert.Equal(t, t0.Add(3*time.Second), tr2.lastEventTime)
+	}
+
+	// An event with neither "t_abs" nor "t_rel" is synthesized just
+	// after the latest event.
+	tr2 := NewTrace2Dataset(nil)
+	tr2.lastEventTime = time.Date(2023, 1, 10, 14, 57, 42, 0, time.UTC)
+	evt := &TrEvent{mf_thread: "main", mf_time_missing: true}
+	tr2.reconstructBriefTime(evt)
+	assert.Equal(t, tr2.lastEventTime.Add(time.Microsecond), evt.mf_time)
+}
+
+// Verify that we reconstruct brief mode times for nested regions and
+// child processes using the start of the matching region or child
+// rather than the start of the thread.
+func Test_Dataset_BriefModeRegions(t *testing.T) {
+	sid := `"sid":"20230110T145742.956295Z-H0f5a2227-P00002b44"`
+	events := []string{
+		`{"event":"version",` + sid + `,"thread":"main","time":"2023-01-10T14:57:42.000000Z","evt":"3","exe":"2.38.1"}`,
+		`{"event":"start",` + sid + `,"thread":"main","t_abs":0.5,"argv":["git","status"]}`,
+		`{"event":"cmd_name",` + sid + `,"thread":"main","name":"status","hierarchy":"status"}`,
+		`{"event":"region_enter",` + sid + `,"thread":"main","t_abs":1.0,"nesting":1,"category":"index","label":"outer"}`,
+		`{"event":"region_enter",` + sid + `,"thread":"main","t_abs":2.0,"nesting":2,"category":"index","label":"inner"}`,
+		`{"event":"child_start",` + sid + `,"thread":"main","t_abs":2.5,"child_id":0,"child_class":"?","use_shell":false,"argv":["git","gc"]}`,
+		`{"event":"child_exit",` + sid + `,"thread":"main","t_rel":1.0,"child_id":0,"pid":42,"code":0}`,
+		`{"event":"region_leave",` + sid + `,"thread":"main","t_rel":2.0,"nesting":2,"category":"index","label":"inner"}`,
+		`{"event":"region_leave",` + sid + `,"thread":"main","t_rel":4.0,"nesting":1,"category":"index","label":"outer"}`,
+		`{"event":"atexit",` + sid + `,"thread":"main","time":"2023-01-10T14:57:48.000000Z","t_abs":6.0,"code":0}`,
+	}
+
+	rcvr_base := &Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{AllowBriefMode: true},
+	}
+	tr2 := NewTrace2Dataset(rcvr_base)
+
+	t0 := time.Date(2023, 1, 10, 14, 57, 42, 0, time.UTC)
+	sec := func(s float64) time.Time { return t0.Add(secondsToDuration(s)) }
+
+	for k, s := range events {
+		assert.Nil(t, processRawLine([]byte(s), tr2, rcvr_base.Logger, false))
+
+		if k == 4 {
+			// A data event inside the inner region is relative to
+			// the start of that region.  A process-level data event
+			// is relative to the start of the thread.
+			evt := &TrEvent{mf_thread: "main", mf_time_missing: true,
+				pmf_t_rel:       new(float64),
+				pm_generic_data: &TrEventGenericData{mf_nesting: 3}}
+			*evt.pmf_t_rel = 0.25
+			tr2.reconstructBriefTime(evt)
+			assert.Equal(t, sec(2.25), evt.mf_time)
+
+			evt.pm_generic_data.mf_nesting = 1
+			tr2.reconstructBriefTime(evt)
+			assert.Equal(t, sec(0.25), evt.mf_time)
+		}
+	}
+	assert.True(t, tr2.prepareDataset())
+
+	assert.Equal(t, 2, len(tr2.completedRegions))
+	inner := tr2.completedRegions[0]
+	outer := tr2.completedRegions[1]
+	assert.Equal(t, "inner", inner.label)
+	assert.Equal(t, sec(2.0), inner.lifetime.startTime)
+	assert.Equal(t, sec(4.0), inner.lifetime.endTime)
+	assert.Equal(t, "outer", outer.label)
+	assert.Equal(t, sec(1.0), outer.lifetime.startTime)
+	assert.Equal(t, sec(5.0), outer.lifetime.endTime)
+
+	child := tr2.children[0]
+	assert.Equal(t, sec(2.5), child.lifetime.startTime)
+	assert.Equal(t, sec(3.5), child.lifetime.endTime)
+
+	assert.Equal(t, sec(6.0), tr2.process.mainThread.lifetime.endTime)
+}
+
+// Verify that we remember the first few error messages.
+func Test_Dataset_ErrorMessages(t *testing.T) {
+
+	events := []string{x_make_version(), x_make_start()}
+	for k := 0; k < DefaultMaxErrorMessages+5; k++ {
+		events = append(events, x_make_error(fmt.Sprintf("msg %d", k), "msg %d"))
+	}
+	events = append(events, x_make_atexit())
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.Equal(t, "msg 0", tr2.process.exeErrorMsg)
+	assert.Equal(t, "msg %d", tr2.process.exeErrorFmt)
+
+	assert.Equal(t, DefaultMaxErrorMessages, len(tr2.process.exeErrors))
+	assert.Equal(t, "msg 1", tr2.process.exeErrors[1].Msg)
+	assert.Equal(t, "msg %d", tr2.process.exeErrors[1].Fmt)
+}
+
+// Verify that we can tell a real exit from a synthesized one.
+func Test_Dataset_CleanExit(t *testing.T) {
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_exit_code("atexit", -1),
+	})
+	assert.True(t, sufficient, "have sufficient data")
+	assert.True(t, tr2.process.cleanExit)
+	assert.Equal(t, int64(-1), tr2.process.exeExitCode)
+
+	tr2, sufficient, _ = load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+	})
+	assert.True(t, sufficient, "have sufficient data")
+	assert.False(t, tr2.process.cleanExit)
+	assert.Equal(t, int64(-1), tr2.process.exeExitCode)
+}
+
+// Verify that we mark the dataset as incomplete when we had to
+// force-close anything or the process was signalled.
+func Test_Dataset_Incomplete(t *testing.T) {
+	tests := []struct {
+		events     []string
+		incomplete bool
+	}{
+		{[]string{x_make_atexit()}, false},
+		{[]string{}, true},
+		{[]string{x_make_signal(13)}, true},
+		{[]string{x_make_region_enter("main", 1, "cat", "lbl", ""), x_make_atexit()}, true},
+		{[]string{x_make_thread_start("th01:preload"), x_make_atexit()}, true},
+	}
+
+	for _, test := range tests {
+		events := append([]string{x_make_version(), x_make_start()}, test.events...)
+
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient, "have sufficient data")
+		assert.Equal(t, test.incomplete, tr2.process.incomplete)
+
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		_, ok := sm.Get(string(Trace2CmdIncomplete))
+		assert.Equal(t, test.incomplete, ok)
+	}
+}
+
+// Verify that the source location of a region is captured and only
+// emitted at dl:verbose.
+func Test_Dataset_RegionSourceLocation(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.Equal(t, 1, len(tr2.completedRegions))
+	assert.Equal(t, x_file, tr2.completedRegions[0].file)
+	assert.Equal(t, int64(x_ln), tr2.completedRegions[0].line)
+
+	span := ptrace.NewSpan()
+	emitRegionSpan(&span, tr2.completedRegions[0], tr2, DetailLevelVerbose)
+	v, ok := span.Attributes().Get(string(Trace2RegionFile))
+	assert.True(t, ok)
+	assert.Equal(t, x_file, v.Str())
+	v, ok = span.Attributes().Get(string(Trace2RegionLine))
+	assert.True(t, ok)
+	assert.Equal(t, int64(x_ln), v.Int())
+
+	// Regions without a source location don't get the attributes.
+	tr2.completedRegions[0].file = ""
+	span = ptrace.NewSpan()
+	emitRegionSpan(&span, tr2.completedRegions[0], tr2, DetailLevelVerbose)
+	_, ok = span.Attributes().Get(string(Trace2RegionFile))
+	assert.False(t, ok)
+}
+
+func Test_Dataset_DistinctRegions(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_enter(x_main, 1, "index", "refresh", "m2"),
+		x_make_region_leave(x_main, 1, "index", "refresh", "m2"),
+		x_make_region_enter(x_main, 1, "status", "refresh", "m3"),
+		x_make_region_leave(x_main, 1, "status", "refresh", "m3"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.Equal(t, int64(2), tr2.process.regionCategoryCount)
+	assert.Equal(t, int64(3), tr2.process.regionLabelCount)
+}
+
+// Verify that a captured stream can be replayed through the pipeline.
+func Test_ReplayStream(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	}
+
+	var got []ptrace.Traces
+	tc, _ := consumer.NewTraces(func(_ context.Context, td ptrace.Traces) error {
+		got = append(got, td)
+		return nil
+	})
+
+	base := &Rcvr_Base{
+		Logger:         zap.NewNop(),
+		TracesConsumer: tc,
+		RcvrConfig:     &Config{},
+	}
+
+	// Omit the final newline.
+	err := ReplayStream(strings.NewReader(strings.Join(events, "\n")), base)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(got))
+	assert.Equal(t, 1, got[0].SpanCount())
+
+	err = ReplayStream(strings.NewReader("{not json\n"), base)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(got))
+}
+
+func Test_Dataset_Rusage(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "exit", "rusage", "m1"),
+		x_make_data_intmax(x_main, 2, "rusage", "max_rss", 12345),
+		x_make_data_string(x_main, 2, "rusage", "user_time_sec", "1.5"),
+		x_make_region_leave(x_main, 1, "exit", "rusage", "m1"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.True(t, tr2.process.rusage.haveMaxRss)
+	assert.Equal(t, int64(12345), tr2.process.rusage.maxRss)
+	assert.True(t, tr2.process.rusage.haveUserTimeSec)
+	assert.True(t, float_is_near(tr2.process.rusage.userTimeSec, 1.5))
+	assert.False(t, tr2.process.rusage.haveSystemTimeSec)
+
+	// These are emitted even at `dl:summary`.
+	traces := tr2.ToTraces(DetailLevelSummary)
+	attrs := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := attrs.Get(string(Trace2ProcessMaxRss))
+	assert.True(t, ok)
+	assert.Equal(t, int64(12345), v.Int())
+	_, ok = attrs.Get(string(Trace2ProcessSystemTimeSec))
+	assert.False(t, ok)
+}
+
+func Test_Dataset_StartupDelay(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "cat", "lbl", "m1"),
+		x_make_region_leave(x_main, 1, "cat", "lbl", "m1"),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	// The test clock advances 1 second for each event and the process
+	// starts with the "version" event.
+	assert.True(t, tr2.process.haveStartupDelay)
+	assert.Equal(t, 2*time.Second, tr2.process.startupDelay)
+
+	// Without regions, there is no startup delay.
+	tr2, sufficient, _ = load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(),
+	})
+	assert.True(t, sufficient, "have sufficient data")
+	assert.False(t, tr2.process.haveStartupDelay)
+
+	traces := tr2.ToTraces(DetailLevelSummary)
+	attrs := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	_, ok := attrs.Get(string(Trace2CmdStartupDelaySec))
+	assert.False(t, ok)
+}
+
+// Verify that the receiver health counters are updated.
+func Test_ReceiverStats(t *testing.T) {
+	tc, _ := consumer.NewTraces(func(_ context.Context, _ ptrace.Traces) error {
+		return nil
+	})
+
+	base := &Rcvr_Base{
+		Logger:         zap.NewNop(),
+		TracesConsumer: tc,
+		RcvrConfig:     &Config{},
+	}
+
+	stream := strings.Join([]string{
+		"cc: hello",
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(),
+	}, "\n")
+	assert.Nil(t, ReplayStream(strings.NewReader(stream), base))
+
+	rejected := strings.Join([]string{
+		x_make_version(),
+		x_make_start_argv1("xx"),
+		x_make_cmd_name_nh("fsmonitor--daemon", "qq"),
+	}, "\n")
+	assert.NotNil(t, ReplayStream(strings.NewReader(rejected), base))
+
+	assert.NotNil(t, ReplayStream(strings.NewReader("{bogus"), base))
+
+	assert.Equal(t, int64(1), base.stats.get(rcvrStatDatasetsExported))
+	assert.Equal(t, int64(1), base.stats.get(rcvrStatCommandVerbs))
+	assert.Equal(t, int64(1), base.stats.get(rcvrStatClientsRejected))
+	assert.Equal(t, int64(1), base.stats.get(rcvrStatParseErrors))
+	assert.Equal(t, int64(0), base.stats.get(rcvrStatDatasetsDropped))
+}
+
+func Test_Dataset_SignalName(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_signal(13),
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+	assert.Equal(t, int64(13), tr2.process.signo)
+
+	traces := tr2.ToTraces(DetailLevelSummary)
+	attrs := traces.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := attrs.Get(string(Trace2CmdSignal))
+	assert.True(t, ok)
+	assert.Equal(t, int64(13), v.Int())
+	v, ok = attrs.Get(string(Trace2CmdSignalName))
+	assert.True(t, ok)
+	assert.Equal(t, "SIGPIPE", v.Str())
+
+	assert.Equal(t, unknownSignalName, signalName(1000))
+}
+
+// Verify that we stop accumulating regions, children, and threads
+// when the caps are hit and that nested regions are ignored too.
+func Test_Dataset_Limits(t *testing.T) {
+	rcvr_base := &Rcvr_Base{
+		Logger: zap.NewNop(),
+		RcvrConfig: &Config{
+			MaxRegions:  2,
+			MaxChildren: 1,
+			MaxThreads:  1,
+		},
+	}
+	tr2 := NewTrace2Dataset(rcvr_base)
+
+	for _, s := range []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter("main", 1, "c", "r1", ""),
+		x_make_region_leave("main", 1, "c", "r1", ""),
+		x_make_region_enter("main", 1, "c", "r2", ""),
+		x_make_region_enter("main", 2, "c", "r3", ""),
+		x_make_region_enter("main", 3, "c", "r4", ""),
+		x_make_region_leave("main", 3, "c", "r4", ""),
+		x_make_region_leave("main", 2, "c", "r3", ""),
+		x_make_region_leave("main", 1, "c", "r2", ""),
+		x_make_child_start(0, "?", "a", "b"),
+		x_make_child_start(1, "?", "c", "d"),
+		x_make_thread_start("th01:a"),
+		x_make_thread_start("th02:b"),
+		x_make_atexit(),
+	} {
+		err := processRawLine([]byte(s), tr2, rcvr_base.Logger, false)
+		assert.Nil(t, err)
+	}
+
+	assert.True(t, tr2.prepareDataset())
+	assert.Equal(t, 2, len(tr2.completedRegions))
+	assert.Equal(t, 1, len(tr2.children))
+	assert.Equal(t, 1, len(tr2.threads))
+
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	for _, k := range []string{string(Trace2CmdTruncatedRegions), string(Trace2CmdTruncatedChildren), string(Trace2CmdTruncatedThreads)} {
+		v, ok := sm.Get(k)
+		assert.True(t, ok, k)
+		assert.True(t, v.Bool(), k)
+	}
+}
+
+// Verify that spans that end before they start are clamped to zero
+// duration unless `allow_negative_durations` is set.
+func Test_Dataset_ClockSkew(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter("main", 1, "c", "r1", ""),
+		x_make_region_leave("main", 1, "c", "r1", ""),
+		x_make_atexit(),
+	}
+
+	for _, allow := range []bool{false, true} {
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient, "have sufficient data")
+		tr2.rcvr_base = &Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{AllowNegativeDurations: allow},
+		}
+
+		r := tr2.completedRegions[0]
+		r.lifetime.endTime = r.lifetime.startTime.Add(-time.Second)
+
+		spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		assert.Equal(t, 2, spans.Len())
+
+		process := spans.At(0)
+		_, ok := process.Attributes().Get(string(Trace2SpanClockSkew))
+		assert.False(t, ok)
+
+		region := spans.At(1)
+		_, ok = region.Attributes().Get(string(Trace2SpanClockSkew))
+		assert.Equal(t, !allow, ok)
+		assert.Equal(t, !allow, region.EndTimestamp() == region.StartTimestamp())
+	}
+}
+
+// Verify that the `cmd_ancestry` is optionally emitted as a chain
+// of zero-duration spans above the process span.
+func Test_Dataset_AncestryAsSpans(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_cmd_ancestry(),
+		x_make_atexit(),
+	}
+
+	for _, enable := range []bool{false, true} {
+		tr2, sufficient, _ := load_test_dataset(t, events)
+		assert.True(t, sufficient, "have sufficient data")
+		tr2.rcvr_base = &Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{AncestryAsSpans: enable},
+		}
+
+		spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		process := spans.At(0)
+
+		_, ok := process.Attributes().Get(string(Trace2CmdAncestry))
+		assert.True(t, ok)
+
+		if !enable {
+			assert.Equal(t, 1, spans.Len())
+			assert.True(t, process.ParentSpanID().IsEmpty())
+			continue
+		}
+
+		assert.Equal(t, 4, spans.Len())
+
+		parent := process.ParentSpanID()
+		for k, name := range []string{"a0", "a1", "a2"} {
+			a := spans.At(k + 1)
+			assert.Equal(t, name, a.Name())
+			assert.Equal(t, parent, a.SpanID())
+			assert.Equal(t, a.StartTimestamp(), a.EndTimestamp())
+			assert.Equal(t, process.TraceID(), a.TraceID())
+
+			v, _ := a.Attributes().Get(string(Trace2SpanType))
+			assert.Equal(t, "ancestor", v.Str())
+
+			parent = a.ParentSpanID()
+		}
+		assert.True(t, parent.IsEmpty())
+
+		// The synthetic SpanIDs must be stable.
+		again := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		assert.Equal(t, process.ParentSpanID(), again.At(0).ParentSpanID())
+	}
+}
+
+// Verify that the sampling decision is repeatable and keeps roughly
+// 1 in N commands and that kept commands record the sample rate.
+func Test_Dataset_SampleRate(t *testing.T) {
+
+	tr2 := NewTrace2Dataset(nil)
+
+	kept := 0
+	for k := 0; k < 1000; k++ {
+		tr2.otelTraceID, _, _, _ = extractIDsfromSID(fmt.Sprintf("sid-%d", k))
+		if tr2.keepSample(10) {
+			kept++
+		}
+		assert.Equal(t, tr2.keepSample(10), tr2.keepSample(10))
+		assert.True(t, tr2.keepSample(1))
+	}
+	assert.Greater(t, kept, 50)
+	assert.Less(t, kept, 150)
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(),
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	process := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+	_, ok := process.Attributes().Get(string(Trace2CmdSampleRate))
+	assert.False(t, ok)
+
+	tr2.sampleRate = 10
+	process = tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+	v, ok := process.Attributes().Get(string(Trace2CmdSampleRate))
+	assert.True(t, ok)
+	assert.Equal(t, int64(10), v.Int())
+}
+
+// Verify that we can parse the numeric components of the Git version
+// string (ignoring vendor suffixes) and emit them.
+func Test_Dataset_ExeVersion(t *testing.T) {
+
+	var tests = []struct {
+		version string
+		ok      bool
+		major   int64
+		minor   int64
+		patch   int64
+	}{
+		{"2.42.0", true, 2, 42, 0},
+		{"2.38.1.windows.1", true, 2, 38, 1},
+		{"2.39.0.vfs.0.0", true, 2, 39, 0},
+		{"2.43.0-rc1", true, 2, 43, 0},
+		{"2.43.0.rc1.7.gabcdef", true, 2, 43, 0},
+		{"2.39.2 (Apple Git-143)", true, 2, 39, 2},
+		{"2.42", false, 0, 0, 0},
+		{"v2.42.0", false, 0, 0, 0},
+		{"", false, 0, 0, 0},
+	}
+
+	for _, test := range tests {
+		v := parseExeVersion(test.version)
+		assert.Equal(t, test.ok, v.ok, test.version)
+		assert.Equal(t, test.major, v.major, test.version)
+		assert.Equal(t, test.minor, v.minor, test.version)
+		assert.Equal(t, test.patch, v.patch, test.version)
+	}
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(),
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	ra := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).Resource().Attributes()
+	for key, want := range map[string]int64{
+		string(Trace2CmdVersionMajor): 1,
+		string(Trace2CmdVersionMinor): 2,
+		string(Trace2CmdVersionPatch): 3,
+	} {
+		v, ok := ra.Get(key)
+		assert.True(t, ok, key)
+		assert.Equal(t, want, v.Int(), key)
+	}
+
+	v, ok := ra.Get(string(Trace2ReceiverVersionKey))
+	assert.True(t, ok)
+	assert.Equal(t, Trace2ReceiverVersion, v.Str())
+	assert.NotEmpty(t, v.Str())
+
+	tr2.process.exeVersionParts = parseExeVersion("unknown")
+	ra = tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).Resource().Attributes()
+	_, ok = ra.Get(string(Trace2CmdVersionMajor))
+	assert.False(t, ok)
+}
+
+// Verify the child process summary on the process span.
+func Test_Dataset_ChildSummary(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_child_start(0, "git", "git", "gc"),
+		x_make_hook_child_start(1, "hook", "pre-commit", "pre-commit", "x"),
+		x_make_child_start(2, "cred", "git", "credential-manager"),
+		x_make_child_start(3, "cred", "git", "credential-manager"),
+		x_make_child_exit(0, 100, 0),
+		x_make_child_exit(1, 101, 0),
+		x_make_child_exit(2, 102, 0),
+		x_make_child_exit(3, 103, 0),
+		x_make_atexit(), // Should be last
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	assert.Equal(t, int64(4), tr2.process.childCount)
+	assert.Equal(t, int64(1), tr2.process.hookCount)
+	assert.Equal(t, int64(2), tr2.process.credCount)
+
+	var total time.Duration
+	for _, child := range tr2.children {
+		total += child.lifetime.endTime.Sub(child.lifetime.startTime)
+	}
+	assert.True(t, total > 0)
+	assert.Equal(t, total, tr2.process.childTotalTime)
+
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2ProcessChildCount))
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), v.Int())
+	v, ok = sm.Get(string(Trace2ProcessChildTotalSec))
+	assert.True(t, ok)
+	assert.Equal(t, total.Seconds(), v.Double())
+	v, ok = sm.Get(string(Trace2ProcessHookCount))
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), v.Int())
+	v, ok = sm.Get(string(Trace2ProcessCredCount))
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), v.Int())
+}
+
+// Verify that `slow_threshold_sec` marks long running commands.
+func Test_Dataset_SlowThreshold(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	}
+
+	var tests = []struct {
+		threshold float64 // relative to the actual duration
+		expected  bool
+	}{
+		{0, false},
+		{-0.5, true},
+		{+0.5, false},
+	}
+
+	for _, test := range tests {
+		cfg := &Config{}
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: cfg,
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+
+		sec, ok := tr2.processDurationSec()
+		assert.True(t, ok)
+		if test.threshold != 0 {
+			cfg.SlowThresholdSec = sec + test.threshold
+		}
+
+		assert.True(t, tr2.prepareDataset())
+		assert.Equal(t, test.expected, tr2.process.slow)
+
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		_, ok = sm.Get(string(Trace2CmdSlow))
+		assert.Equal(t, test.expected, ok)
+	}
+}
+
+// Verify that the `service_name` config setting overrides the
+// qualified command name in the `service.name` resource attribute.
+func Test_Dataset_ServiceName(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(),
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	ra := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).Resource().Attributes()
+	v, _ := ra.Get("service.name")
+	assert.Equal(t, tr2.process.qualifiedNames.exeVerbMode, v.Str())
+
+	tr2.rcvr_base = &Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{ServiceName: "git-ci"},
+	}
+
+	ra = tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).Resource().Attributes()
+	v, _ = ra.Get("service.name")
+	assert.Equal(t, "git-ci", v.Str())
+}
+
+// Verify that we only emit the receiver uptime when requested.
+func Test_Dataset_ReceiverUptime(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	}
+
+	for _, emit := range []bool{false, true} {
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{EmitReceiverUptime: emit},
+			startTime:  time.Now().Add(-90 * time.Second),
+		})
+
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+		assert.True(t, tr2.prepareDataset())
+
+		ra := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).Resource().Attributes()
+		v, ok := ra.Get(string(Trace2ReceiverUptimeSec))
+		assert.Equal(t, emit, ok)
+		if ok {
+			assert.GreaterOrEqual(t, v.Int(), int64(90))
+			assert.Less(t, v.Int(), int64(120))
+		}
+	}
+}
+
+// Verify that the `resource_attributes` config setting adds static
+// resource attributes and cannot overwrite the ones that we set.
+func Test_Dataset_ResourceAttributes(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(),
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, events)
+	assert.True(t, sufficient, "have sufficient data")
+
+	tr2.rcvr_base = &Rcvr_Base{
+		Logger: zap.NewNop(),
+		RcvrConfig: &Config{ResourceAttributes: map[string]string{
+			"deployment.region": "us-east",
+			"fleet.id":          "f1",
+		}},
+	}
+
+	ra := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).Resource().Attributes()
+	v, _ := ra.Get("deployment.region")
+	assert.Equal(t, "us-east", v.Str())
+	v, _ = ra.Get("fleet.id")
+	assert.Equal(t, "f1", v.Str())
+	v, _ = ra.Get("service.namespace")
+	assert.Equal(t, Trace2ServiceNamespace, v.Str())
+
+	assert.Nil(t, validateResourceAttributes(tr2.rcvr_base.RcvrConfig.ResourceAttributes))
+	for _, k := range []string{"", "service.namespace", "trace2.cmd.sid"} {
+		assert.NotNil(t, validateResourceAttributes(map[string]string{k: "x"}), k)
+	}
+}
+
+// Verify that the `PostProcessTraces` hook can modify or drop the
+// spans before they are sent to the next component.
+func Test_Dataset_PostProcessTraces(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	}
+
+	for _, drop := range []bool{false, true} {
+		var consumed []ptrace.Traces
+		next, _ := consumer.NewTraces(func(ctx context.Context, td ptrace.Traces) error {
+			consumed = append(consumed, td)
+			return nil
+		})
+
+		cfg := createDefaultConfig().(*Config)
+		cfg.PostProcessTraces = func(td ptrace.Traces) ptrace.Traces {
+			if drop {
+				return ptrace.NewTraces()
+			}
+			span := td.ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0)
+			span.Attributes().PutStr("example.enriched", "yes")
+			return td
+		}
+
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:         zap.NewNop(),
+			RcvrConfig:     cfg,
+			TracesConsumer: next,
+		})
+		for _, s := range events {
+			assert.Nil(t, processRawLine([]byte(s), tr2, zap.NewNop(), false))
+		}
+		tr2.exportTraces()
+
+		if drop {
+			assert.Equal(t, 0, len(consumed))
+			assert.Equal(t, int64(1), tr2.rcvr_base.stats.get(rcvrStatDatasetsDropped))
+			continue
+		}
+
+		assert.Equal(t, 1, len(consumed))
+		sm := consumed[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, ok := sm.Get("example.enriched")
+		assert.True(t, ok)
+		assert.Equal(t, "yes", v.Str())
+	}
+}
+
+// Verify that "error" and "printf" events are emitted as log records
+// correlated with the process span when `emit_logs` is set.
+func Test_Dataset_EmitLogs(t *testing.T) {
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_printf("hello"),
+		x_make_error("bad thing", "bad %s"),
+		x_make_atexit(), // Should be last
+	}
+
+	for _, enable := range []bool{false, true} {
+		var got []plog.Logs
+		lc, _ := consumer.NewLogs(func(_ context.Context, ld plog.Logs) error {
+			got = append(got, ld)
+			return nil
+		})
+
+		base := &Rcvr_Base{
+			Logger:       zap.NewNop(),
+			LogsConsumer: lc,
+			RcvrConfig:   &Config{EmitLogs: enable},
+		}
+
+		err := ReplayStream(strings.NewReader(strings.Join(events, "\n")), base)
+		assert.Nil(t, err)
+
+		if !enable {
+			assert.Equal(t, 0, len(got))
+			continue
+		}
+
+		assert.Equal(t, 1, len(got))
+		assert.Equal(t, 2, got[0].LogRecordCount())
+
+		records := got[0].ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+
+		lr := records.At(0)
+		assert.Equal(t, "hello", lr.Body().Str())
+		assert.Equal(t, plog.SeverityNumberInfo, lr.SeverityNumber())
+
+		lr = records.At(1)
+		assert.Equal(t, "bad thing", lr.Body().Str())
+		assert.Equal(t, plog.SeverityNumberError, lr.SeverityNumber())
+		v, _ := lr.Attributes().Get(string(Trace2CmdErrFmt))
+		assert.Equal(t, "bad %s", v.Str())
+
+		tid, spid, _, _ := extractIDsfromSID(x_sid)
+		assert.Equal(t, tid, [16]byte(lr.TraceID()))
+		assert.Equal(t, spid, [8]byte(lr.SpanID()))
+	}
+}
+
+// Verify that `t_abs` fills in the process end time when the command
+// does not exit cleanly and when the wall-clock times are inconsistent.
+func Test_Dataset_TAbsEndTime(t *testing.T) {
+
+	// No "atexit" event, so the end time comes from the "data" event.
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_data_intmax(x_main, 1, "cat", "key", 42),
+	})
+	assert.True(t, sufficient)
+	assert.True(t, tr2.process.incomplete)
+	assert.True(t, tr2.haveTAbs)
+	assert.Equal(t, secondsToDuration(tr2.maxTAbs),
+		tr2.process.mainThread.lifetime.endTime.Sub(tr2.process.mainThread.lifetime.startTime))
+
+	// The system clock went backwards before the "atexit" event.
+	events := []string{x_make_version(), x_make_start()}
+	x_time_now = x_time_zero.Add(-time.Hour)
+	events = append(events, fmt.Sprintf(`{%s,"t_abs":%.6f,"code":%d}`,
+		x_make_common("atexit", x_main), 2.5, 0))
+
+	tr2, sufficient, _ = load_test_dataset(t, events)
+	assert.True(t, sufficient)
+	assert.False(t, tr2.process.incomplete)
+	assert.Equal(t, 2500*time.Millisecond,
+		tr2.process.mainThread.lifetime.endTime.Sub(tr2.process.mainThread.lifetime.startTime))
+
+	// Otherwise, the wall-clock time wins.
+	tr2, sufficient, _ = load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+	assert.Equal(t, 2*time.Second,
+		tr2.process.mainThread.lifetime.endTime.Sub(tr2.process.mainThread.lifetime.startTime))
+
+	// Open children, threads, and regions end with the process rather
+	// than when the connection was closed.
+	tr2, sufficient, _ = load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "cat", "label", "msg"),
+		x_make_child_start(0, "?", "git", "gc"),
+		x_make_thread_start("th01:preload"),
+		x_make_region_enter("th01:preload", 1, "cat", "label", "msg"),
+		x_make_data_intmax(x_main, 1, "cat", "key", 42),
+	})
+	assert.True(t, sufficient)
+	assert.True(t, tr2.process.incomplete)
+
+	end := tr2.process.mainThread.lifetime.endTime
+	assert.Equal(t, secondsToDuration(tr2.maxTAbs),
+		end.Sub(tr2.process.mainThread.lifetime.startTime))
+	assert.Equal(t, end, tr2.children[0].lifetime.endTime)
+	assert.Equal(t, end, tr2.threads["th01:preload"].lifetime.endTime)
+	assert.Equal(t, 2, len(tr2.completedRegions))
+	for _, r := range tr2.completedRegions {
+		assert.Equal(t, end, r.lifetime.endTime)
+	}
+}
+
+// Verify that we compute the depth of the command from the SID
+// and emit it on the process span.
+func Test_Dataset_SidDepth(t *testing.T) {
+	for sid, expected := range map[string]int{
+		"a":     0,
+		"a/b":   1,
+		"a/b/c": 2,
+	} {
+		_, _, _, depth := extractIDsfromSID(sid)
+		assert.Equal(t, expected, depth, sid)
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2CmdSidDepth))
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), v.Int())
+}
+
+// Verify that we find the credential helper name and operation in
+// both the single-string and multi-arg forms of the argv.
+func Test_ParseCredHelperArgv(t *testing.T) {
+	for _, test := range []struct {
+		argv   []interface{}
+		helper string
+		op     string
+	}{
+		{[]interface{}{"git credential-manager get"}, "manager", "get"},
+		{[]interface{}{"git credential-cache --timeout=300 store"}, "cache", "store"},
+		{[]interface{}{"git credential-osxkeychain erase"}, "osxkeychain", "erase"},
+		{[]interface{}{"git credential-manager-core get"}, "manager-core", "get"},
+		{[]interface{}{"/usr/local/bin/git-credential-manager get"}, "manager", "get"},
+		{[]interface{}{`C:\Program Files\Git\git-credential-manager.exe get`}, "manager", "get"},
+		{[]interface{}{"!aws codecommit credential-helper $@ get"}, "aws", "get"},
+		{[]interface{}{"git-credential-store", "--file=/tmp/x", "store"}, "store", "store"},
+		{[]interface{}{"git", "credential-manager"}, "manager", "unknown"},
+		{[]interface{}{"git credential-manager capability"}, "manager", "unknown"},
+		{[]interface{}{"get"}, "unknown", "get"},
+		{[]interface{}{}, "unknown", "unknown"},
+	} {
+		helper, op := parseCredHelperArgv(test.argv)
+		assert.Equal(t, test.helper, helper, test.argv)
+		assert.Equal(t, test.op, op, test.argv)
+	}
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_child_start(0, "cred", "git credential-osxkeychain", "get"),
+		x_make_child_exit(0, 100, 0),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+
+	child := tr2.children[0]
+	assert.Equal(t, "osxkeychain", child.credHelper)
+	assert.Equal(t, "get", child.credOp)
+	assert.Equal(t, "child(cred:get)", child.lifetime.displayName)
+}
+
+// Verify that we compute how long a credential helper blocked the
+// parent and only emit it for credential helpers that we waited for.
+func Test_Dataset_CredBlocking(t *testing.T) {
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_child_start(0, "cred", "helper", "get"),
+		x_make_child_exit(0, 100, 0),
+		x_make_child_start(1, "cred", "helper", "get"),
+		x_make_child_ready(1, 101, "ready"),
+		x_make_child_exit(1, 101, 0),
+		x_make_child_start(2, "cred", "helper", "store"),
+		x_make_child_ready(2, 102, "ready"),
+		x_make_child_start(3, "subprocess", "aa3", "bb3"),
+		x_make_child_exit(3, 103, 0),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+
+	// Each event advances the clock by 1 second.  The second helper
+	// ran for 2 seconds, but only blocked until it was ready.
+	for _, id := range []int64{0, 1} {
+		d, ok := tr2.children[id].credBlockingTime()
+		assert.True(t, ok, id)
+		assert.Equal(t, 1.0, d.Seconds(), id)
+	}
+	child := tr2.children[1]
+	assert.Equal(t, 2.0, child.lifetime.endTime.Sub(child.lifetime.startTime).Seconds())
+	for _, id := range []int64{2, 3} {
+		_, ok := tr2.children[id].credBlockingTime()
+		assert.False(t, ok, id)
+	}
+
+	nrBlocking := 0
+	spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	for k := 0; k < spans.Len(); k++ {
+		if _, ok := spans.At(k).Attributes().Get(string(Trace2ChildBlockingSec)); ok {
+			nrBlocking++
+		}
+	}
+	assert.Equal(t, 2, nrBlocking)
+}
+
+// Verify that we redact the operand values in common command lines
+// but keep the flag names.
+func Test_RedactArgv(t *testing.T) {
+	for _, test := range []struct {
+		argv     []interface{}
+		verb     string
+		expected []string
+	}{
+		{[]interface{}{"/usr/bin/git", "status"}, "status",
+			[]string{"git", "status"}},
+		{[]interface{}{"git", "-C", "/src/repo", "checkout", "-b", "topic"}, "checkout",
+			[]string{"git", "-C", "<val>", "checkout", "-b", "<val>"}},
+		{[]interface{}{"git", "commit", "-m", "msg", "--author=A U Thor"}, "commit",
+			[]string{"git", "commit", "-m", "<val>", "--author=<val>"}},
+		{[]interface{}{"git", "log", "--oneline", "--", "-a", "file"}, "log",
+			[]string{"git", "log", "--oneline", "--", "<val>", "<val>"}},
+		{[]interface{}{"git", "add", "-"}, "add",
+			[]string{"git", "add", "<val>"}},
+		{[]interface{}{"git", "show", "show"}, "show",
+			[]string{"git", "show", "<val>"}},
+		{[]interface{}{"git-remote-https", "origin", "https://example.com/r.git"}, "",
+			[]string{"git-remote-https", "<val>", "<val>"}},
+	} {
+		exe := test.argv[0].(string)
+		exe = exe[strings.LastIndex(exe, "/")+1:]
+		assert.Equal(t, test.expected, redactArgv(test.argv, exe, test.verb), test.argv)
+	}
+
+	load := func(a2 string) (int64, string) {
+		tr2, sufficient, _ := load_test_dataset(t, []string{
+			x_make_version(),
+			x_make_start_argv3("/usr/bin/git", "-C", a2),
+			x_make_atexit(), // Should be last
+		})
+		assert.True(t, sufficient)
+
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		argc, ok := sm.Get(string(Trace2CmdArgc))
+		assert.True(t, ok)
+		hash, ok := sm.Get(string(Trace2CmdArgvShapeHash))
+		assert.True(t, ok)
+		return argc.Int(), hash.Str()
+	}
+
+	argc, hash1 := load("/src/a")
+	assert.Equal(t, int64(3), argc)
+	assert.Equal(t, 16, len(hash1))
+
+	_, hash2 := load("/src/b")
+	assert.Equal(t, hash1, hash2)
+}
+
+// Verify that the command fingerprint ignores operand values and the
+// order of the flags, but not the verb, argc, or the set of flags.
+func Test_Dataset_Fingerprint(t *testing.T) {
+	fingerprint := func(av string, verb string) string {
+		tr2, sufficient, _ := load_test_dataset(t, []string{
+			x_make_version(),
+			x_make_start_av(av),
+			x_make_cmd_name_nh(verb, verb),
+			x_make_atexit(), // Should be last
+		})
+		assert.True(t, sufficient)
+
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, ok := sm.Get(string(Trace2CmdFingerprint))
+		assert.True(t, ok)
+		return v.Str()
+	}
+
+	fp := fingerprint(`["/usr/bin/git","log","--oneline","-n","5"]`, "log")
+	assert.Equal(t, 16, len(fp))
+
+	assert.Equal(t, fp, fingerprint(`["git.exe","log","-n","10","--oneline"]`, "log"))
+	assert.Equal(t, fp, fingerprint(`["git","log","--oneline","-n","5"]`, "log"))
+
+	assert.NotEqual(t, fp, fingerprint(`["git","log","--stat","-n","5"]`, "log"))
+	assert.NotEqual(t, fp, fingerprint(`["git","log","--oneline","-n","5","x"]`, "log"))
+	assert.NotEqual(t, fp, fingerprint(`["git","show","--oneline","-n","5"]`, "show"))
+}
+
+// Verify that a seeded dataset generates the same SpanIDs when the
+// same stream is replayed.
+func Test_Dataset_SpanIDSeed(t *testing.T) {
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_leave(x_main, 1, "index", "do_read_index", "m1"),
+		x_make_region_enter(x_main, 1, "index", "do_write_index", "m2"),
+		x_make_region_leave(x_main, 1, "index", "do_write_index", "m2"),
+		x_make_atexit(), // Should be last
+	}
+
+	replay := func(opts ...DatasetOption) [][8]byte {
+		tr2 := NewTrace2Dataset(nil, opts...)
+		for _, s := range events {
+			evt, err := parse_json([]byte(s))
+			assert.Nil(t, err)
+			assert.Nil(t, evt_apply(tr2, evt))
+		}
+
+		var got [][8]byte
+		for _, r := range tr2.completedRegions {
+			got = append(got, r.lifetime.selfSpanID)
+		}
+		return got
+	}
+
+	a := replay(WithSpanIDSeed(42))
+	b := replay(WithSpanIDSeed(42))
+	assert.Equal(t, 2, len(a))
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a[0], a[1])
+
+	c := replay(WithSpanIDSeed(43))
+	assert.NotEqual(t, a, c)
+}
+
+// Verify that the recent raw lines are logged after a parse error
+// when `debug_ring_size` is set.
+func Test_Dataset_DebugRing(t *testing.T) {
+	ring := newRawLineRing(3)
+	for _, line := range []string{"a\n", "b\n", "c\n", "d\n"} {
+		ring.add([]byte(line))
+	}
+	assert.Equal(t, []string{"b", "c", "d"}, ring.snapshot())
+	assert.Nil(t, newRawLineRing(0))
+
+	var events []string = []string{
+		x_make_version(),
+		x_make_start(),
+		`{"event":"bogus"`,
+	}
+
+	for _, size := range []int{0, 2} {
+		core, logs := observer.New(zapcore.DebugLevel)
+		logger := zap.New(core)
+
+		rcvr_base := &Rcvr_Base{
+			Logger:     logger,
+			RcvrConfig: &Config{DebugRingSize: size},
+		}
+		tr2 := NewTrace2Dataset(rcvr_base)
+
+		var err error
+		for _, s := range events {
+			if err = processRawLine([]byte(s+"\n"), tr2, logger, false); err != nil {
+				break
+			}
+		}
+		assert.NotNil(t, err)
+
+		recent := logs.FilterMessageSnippet("recent line").All()
+		if size == 0 {
+			assert.Equal(t, 0, len(recent))
+			continue
+		}
+		assert.Equal(t, 2, len(recent))
+		assert.Contains(t, recent[0].Message, `"event":"start"`)
+		assert.Contains(t, recent[1].Message, `{"event":"bogus"`)
+	}
+}
+
+// Verify that gzip compressed streams are decompressed and that
+// plain streams are unchanged.
+func Test_maybeDecompressStream(t *testing.T) {
+	stream := x_make_version() + "\n" + x_make_start() + "\n"
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(stream))
+	gz.Close()
+
+	for _, input := range [][]byte{[]byte(stream), buf.Bytes()} {
+		r, err := maybeDecompressStream(bufio.NewReader(bytes.NewReader(input)))
+		assert.Nil(t, err)
+
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err == io.EOF {
+				break
+			}
+			assert.Nil(t, err)
+			lines = append(lines, line)
+		}
+		assert.Equal(t, []string{x_make_version() + "\n", x_make_start() + "\n"}, lines)
+	}
+
+	// A truncated gzip header is an error.
+	_, err := maybeDecompressStream(bufio.NewReader(bytes.NewReader(buf.Bytes()[:4])))
+	assert.NotNil(t, err)
+
+	// An empty stream is passed through.
+	r, err := maybeDecompressStream(bufio.NewReader(bytes.NewReader(nil)))
+	assert.Nil(t, err)
+	_, err = r.ReadBytes('\n')
+	assert.Equal(t, io.EOF, err)
+}
+
+// Verify that `max_concurrent_connections` limits the connections
+// that we process at the same time using either policy.
+func Test_ConnectionLimit(t *testing.T) {
+	for _, policy := range []connectionLimitPolicy{connectionLimitQueue, connectionLimitReject} {
+		rcvr_base := &Rcvr_Base{
+			Logger: zap.NewNop(),
+			RcvrConfig: &Config{
+				MaxConcurrentConnections: 1,
+				connectionLimitPolicy:    policy,
+			},
+		}
+		rcvr_base.ctx, rcvr_base.cancel = context.WithCancel(context.Background())
+		rcvr_base.connSlots = make(chan struct{}, rcvr_base.RcvrConfig.MaxConcurrentConnections)
+
+		assert.True(t, rcvr_base.acquireConnection())
+		assert.Equal(t, int64(1), rcvr_base.stats.inFlight.Load())
+
+		if policy == connectionLimitReject {
+			assert.False(t, rcvr_base.acquireConnection())
+			assert.Equal(t, int64(1), rcvr_base.stats.get(rcvrStatConnectionsRejected))
+		} else {
+			// A queued connection gets the slot when it is released.
+			done := make(chan bool)
+			go func() { done <- rcvr_base.acquireConnection() }()
+			rcvr_base.releaseConnection()
+			assert.True(t, <-done)
+
+			// And gives up when the receiver is shutdown.
+			go func() { done <- rcvr_base.acquireConnection() }()
+			rcvr_base.cancel()
+			assert.False(t, <-done)
+			assert.Equal(t, int64(0), rcvr_base.stats.get(rcvrStatConnectionsRejected))
+		}
+
+		rcvr_base.releaseConnection()
+		assert.Equal(t, int64(0), rcvr_base.stats.inFlight.Load())
+		rcvr_base.cancel()
+	}
 }
@@ -16,9 +16,17 @@ type TrEvent struct {
 	mf_sid    string
 	mf_thread string
 	mf_time   time.Time
-	pmf_repo  *int64 // (aka repo_id) is optional
-	//mf_file -- optional
-	//mf_line -- optional
+	pmf_repo  *int64  // (aka repo_id) is optional
+	pmf_file  *string // optional (omitted in "brief" mode)
+	pmf_line  *int64  // optional (omitted in "brief" mode)
+
+	// In "brief" mode, Git omits "time" from most events.  When
+	// allowed, we use the relative times (if present) to reconstruct
+	// it.  We also use `t_abs` to repair the process end time if the
+	// wall-clock times are inconsistent.
+	mf_time_missing bool
+	pmf_t_abs       *float64 // seconds since the start of the process
+	pmf_t_rel       *float64 // seconds since the start of the thread, region, or child
 
 	// Variable portion depends on the type of the event.
 
@@ -27,6 +35,7 @@ type TrEvent struct {
 	pm_atexit       *TrEventAtExit // "exit" or "atexit"
 	pm_signal       *TrEventSignal
 	pm_error        *TrEventError
+	pm_printf       *TrEventPrintf
 	pm_cmd_path     *TrEventCmdPath
 	pm_cmd_ancestry *TrEventCmdAncestry
 	pm_cmd_name     *TrEventCmdName
@@ -58,6 +67,7 @@ var ekm *ExtractKeysMap = &ExtractKeysMap{
 	"atexit":         extract_keys__atexit,
 	"signal":         extract_keys__signal,
 	"error":          extract_keys__error,
+	"printf":         extract_keys__printf,
 	"cmd_path":       extract_keys__cmd_path,
 	"cmd_ancestry":   extract_keys__cmd_ancestry,
 	"cmd_name":       extract_keys__cmd_name,
@@ -94,7 +104,7 @@ var CommandControlVerbPrefix []byte = []byte("cc: ")
 // Returns (nil, err) if we had an error.
 // Returns (nil, nil) if we had command/control data.
 // Returns (evt, nil) if we had event data.
-func evt_parse(rawLine []byte, logger *zap.Logger, allowCommands bool) (*TrEvent, error) {
+func evt_parse(rawLine []byte, logger *zap.Logger, allowCommands bool, allowBrief bool) (*TrEvent, error) {
 	trimmed := bytes.TrimSpace(rawLine)
 
 	if len(trimmed) == 0 || trimmed[0] == '#' {
@@ -102,7 +112,7 @@ func evt_parse(rawLine []byte, logger *zap.Logger, allowCommands bool) (*TrEvent
 	}
 
 	if trimmed[0] == '{' {
-		return parse_json(trimmed)
+		return parse_json_opt(trimmed, allowBrief)
 	}
 
 	if bytes.HasPrefix(trimmed, CommandControlVerbPrefix) {
@@ -134,23 +144,41 @@ func processRawLine(rawLine []byte, tr2 *trace2Dataset, logger *zap.Logger, allo
 
 	logger.Debug(fmt.Sprintf("[dsid %06d] saw: %s", tr2.datasetId, rawLine))
 
-	evt, err := evt_parse(rawLine, logger, allowCommands)
+	allowBrief := tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.AllowBriefMode
+
+	if tr2.debugRing != nil {
+		tr2.debugRing.add(rawLine)
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(rawLine), CommandControlVerbPrefix) {
+		tr2.stats().inc(rcvrStatCommandVerbs)
+	}
+
+	evt, err := evt_parse(rawLine, logger, allowCommands, allowBrief)
 	if err != nil {
+		tr2.stats().inc(rcvrStatParseErrors)
 		logger.Error(err.Error())
+		tr2.dumpDebugRing(logger)
 		return err
 	}
 
 	if evt != nil {
 		tr2.sawData = true
+		if evt.mf_time_missing {
+			tr2.reconstructBriefTime(evt)
+		}
+		tr2.updateEventTimeRange(evt.mf_time)
 
-		err = evt_apply(tr2, evt)
+		err = evt_apply_version_first(tr2, evt, logger)
 		if err != nil {
 			if rce, ok := err.(*RejectClientError); ok {
 				// Silently reject the client without logging an error.
+				tr2.stats().inc(rcvrStatClientsRejected)
 				logger.Debug(rce.Error())
 				return rce
 			}
 			logger.Error(err.Error())
+			tr2.dumpDebugRing(logger)
 			return err
 		}
 	}
@@ -159,6 +187,10 @@ func processRawLine(rawLine []byte, tr2 *trace2Dataset, logger *zap.Logger, allo
 }
 
 func parse_json(line []byte) (*TrEvent, error) {
+	return parse_json_opt(line, false)
+}
+
+func parse_json_opt(line []byte, allowBrief bool) (*TrEvent, error) {
 	var err error
 	var jm *jmap = new(jmap)
 
@@ -168,7 +200,7 @@ func parse_json(line []byte) (*TrEvent, error) {
 
 	evt := &TrEvent{}
 
-	if err = extract_keys__common(evt, jm); err != nil {
+	if err = extract_keys__common(evt, jm, allowBrief); err != nil {
 		return evt, err
 	}
 
@@ -189,7 +221,7 @@ func parse_json(line []byte) (*TrEvent, error) {
 }
 
 // Parse common key/value pairs found in almost all Trace2 events.
-func extract_keys__common(evt *TrEvent, jm *jmap) (err error) {
+func extract_keys__common(evt *TrEvent, jm *jmap, allowBrief bool) (err error) {
 	if evt.mf_event, err = jm.getRequiredString("event"); err != nil {
 		return err
 	}
@@ -199,13 +231,33 @@ func extract_keys__common(evt *TrEvent, jm *jmap) (err error) {
 	if evt.mf_thread, err = jm.getRequiredString("thread"); err != nil {
 		return err
 	}
-	if evt.mf_time, err = jm.getRequiredTime("time"); err != nil {
-		// Force a failure if "time" is omitted.
-		//
-		// We require "time" on all events so that we can set the span
-		// duration on bracketed units of work.  However, "time" is not
-		// emitted by Git on most events when in "brief" mode.  For now,
-		// don't bother trying to support clients in brief mode.
+
+	if !allowBrief {
+		if evt.mf_time, err = jm.getRequiredTime("time"); err != nil {
+			// Force a failure if "time" is omitted.
+			//
+			// We require "time" on all events so that we can set the span
+			// duration on bracketed units of work.  However, "time" is not
+			// emitted by Git on most events when in "brief" mode.  Unless
+			// the receiver is configured to allow brief mode, reject them.
+			return err
+		}
+	} else {
+		var pt *time.Time
+		if pt, err = jm.getOptionalTime("time"); err != nil {
+			return err
+		}
+		if pt != nil {
+			evt.mf_time = *pt
+		} else {
+			evt.mf_time_missing = true
+		}
+	}
+
+	if evt.pmf_t_abs, err = jm.getOptionalFloat64("t_abs"); err != nil {
+		return err
+	}
+	if evt.pmf_t_rel, err = jm.getOptionalFloat64("t_rel"); err != nil {
 		return err
 	}
 
@@ -213,7 +265,13 @@ func extract_keys__common(evt *TrEvent, jm *jmap) (err error) {
 		return err
 	}
 
-	// TODO Do we care about "file" and "line"?
+	// The source location in Git that emitted the event.
+	if evt.pmf_file, err = jm.getOptionalString("file"); err != nil {
+		return err
+	}
+	if evt.pmf_line, err = jm.getOptionalInt64("line"); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -240,7 +298,6 @@ func extract_keys__version(evt *TrEvent, jm *jmap) (err error) {
 // Event fields only present in an "event":"start" event
 type TrEventStart struct {
 	mf_argv []interface{}
-	// TODO? t_abs
 }
 
 func extract_keys__start(evt *TrEvent, jm *jmap) (err error) {
@@ -256,7 +313,6 @@ func extract_keys__start(evt *TrEvent, jm *jmap) (err error) {
 // Event fields only present in an "event":"exit" or "event":"atexit" event
 type TrEventAtExit struct {
 	mf_code int64
-	// TODO? t_abs
 }
 
 func extract_keys__atexit(evt *TrEvent, jm *jmap) (err error) {
@@ -274,7 +330,6 @@ func extract_keys__atexit(evt *TrEvent, jm *jmap) (err error) {
 // Event fields only present in an "event":"signal" event
 type TrEventSignal struct {
 	mf_signo int64
-	// TODO? t_abs
 }
 
 func extract_keys__signal(evt *TrEvent, jm *jmap) (err error) {
@@ -306,6 +361,21 @@ func extract_keys__error(evt *TrEvent, jm *jmap) (err error) {
 	return nil
 }
 
+// Event fields only present in an "event":"printf" event
+type TrEventPrintf struct {
+	mf_msg string
+}
+
+func extract_keys__printf(evt *TrEvent, jm *jmap) (err error) {
+	evt.pm_printf = &TrEventPrintf{}
+
+	if evt.pm_printf.mf_msg, err = jm.getRequiredString("msg"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // Event fields only present in an "event":"cmd_path" event
 type TrEventCmdPath struct {
 	mf_path string
@@ -434,7 +504,6 @@ type TrEventChildExit struct {
 	mf_child_id int64
 	mf_pid      int64
 	mf_code     int64
-	// TODO? t_rel
 }
 
 func extract_keys__child_exit(evt *TrEvent, jm *jmap) (err error) {
@@ -459,7 +528,6 @@ type TrEventChildReady struct {
 	mf_child_id int64
 	mf_pid      int64
 	mf_ready    string
-	// TODO? t_rel
 }
 
 func extract_keys__child_ready(evt *TrEvent, jm *jmap) (err error) {
@@ -490,7 +558,6 @@ func extract_keys__thread_start(evt *TrEvent, jm *jmap) (err error) {
 
 // Event fields only present in an "event":"thread_exit" event
 type TrEventThreadExit struct {
-	// TODO? t_rel
 }
 
 func extract_keys__thread_exit(evt *TrEvent, jm *jmap) (err error) {
@@ -624,7 +691,6 @@ type TrEventRegionLeave struct {
 	pmf_category *string // optional category
 	pmf_label    *string // optional label
 	pmf_msg      *string // optional message
-	// TODO? t_rel
 }
 
 func extract_keys__region_leave(evt *TrEvent, jm *jmap) (err error) {
@@ -656,8 +722,6 @@ type TrEventGenericData struct {
 	mf_category      string
 	mf_key           string
 	mf_generic_value interface{}
-	// TODO? t_rel
-	// TODO? t_abs
 }
 
 func extract_keys__data(evt *TrEvent, jm *jmap) (err error) {
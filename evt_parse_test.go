@@ -61,6 +61,15 @@ func Test_parseJsonEvent_Common_NoTime(t *testing.T) {
 	verify_missing_required(err, "time", t)
 }
 
+func Test_parseJsonEvent_Common_NoTime_Brief(t *testing.T) {
+	s := `{"event":"UNUSED","sid":"20230110T145742.956295Z-H0f5a2227-P00002b44","thread":"main","t_abs":1.5}`
+
+	evt, err := parse_json_opt([]byte(s), true)
+	if err != nil || !evt.mf_time_missing || evt.pmf_t_abs == nil || *evt.pmf_t_abs != 1.5 {
+		t.Fatalf("parse: failed to allow missing 'time' in brief mode")
+	}
+}
+
 func verify_common_field_values(s_json string, event_name string, t *testing.T) (evt *TrEvent) {
 	evt, err := parse_json([]byte(s_json))
 	if err != nil {
@@ -0,0 +1,61 @@
+package trace2receiver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// TrExeVersion captures the numeric components of the Git version
+// string, so that dashboards can group by major and minor version
+// without parsing the full string in the query layer.
+type TrExeVersion struct {
+	major int64
+	minor int64
+	patch int64
+
+	// Did we successfully parse the version string?
+	ok bool
+}
+
+// Parse the leading `<major>.<minor>.<patch>` components of a Git
+// version string.  Vendor suffixes, such as `2.38.1.windows.1` or
+// `2.39.0.vfs.0.0`, and release candidate suffixes, such as
+// `2.43.0-rc1` or `2.43.0.rc1`, are ignored.
+func parseExeVersion(version string) (v TrExeVersion) {
+	fields := strings.SplitN(version, ".", 4)
+	if len(fields) < 3 {
+		return TrExeVersion{}
+	}
+
+	var ok bool
+	if v.major, ok = leadingInt64(fields[0]); !ok {
+		return TrExeVersion{}
+	}
+	if v.minor, ok = leadingInt64(fields[1]); !ok {
+		return TrExeVersion{}
+	}
+	if v.patch, ok = leadingInt64(fields[2]); !ok {
+		return TrExeVersion{}
+	}
+
+	v.ok = true
+	return v
+}
+
+// Parse the leading decimal digits of the string.  The field must
+// start with a digit.
+func leadingInt64(s string) (int64, bool) {
+	k := 0
+	for k < len(s) && s[k] >= '0' && s[k] <= '9' {
+		k++
+	}
+	if k == 0 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(s[:k], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
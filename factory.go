@@ -1,6 +1,8 @@
 package trace2receiver
 
 import (
+	"time"
+
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/receiver"
 )
@@ -11,35 +13,88 @@ var (
 
 const (
 	stability = component.StabilityLevelStable
+
+	// The default number of error messages to remember for a command.
+	DefaultMaxErrorMessages = 10
+
+	// The default limit on the size of a string attribute value.
+	DefaultMaxAttributeBytes = 8192
+
+	// The default caps on the number of regions, child processes,
+	// and threads that we accumulate for a command.  These are far
+	// more than any legitimate Git command should generate.
+	DefaultMaxRegions  = 1000000
+	DefaultMaxChildren = 100000
+	DefaultMaxThreads  = 100000
 )
 
 func createDefaultConfig() component.Config {
 	return &Config{
-		NamedPipePath:            "",
-		UnixSocketPath:           "",
-		AllowCommandControlVerbs: false,
-		PiiSettingsPath:          "",
-		piiSettings:              nil,
-		FilterSettingsPath:       "",
-		filterSettings:           nil,
+		NamedPipePath:               "",
+		UnixSocketPath:              "",
+		RefuseIfSocketLive:          false,
+		RecreateOnInodeChange:       false,
+		TCPListen:                   "",
+		AllowCommandControlVerbs:    false,
+		RequireVersionFirst:         false,
+		EmitReceiverEndpoint:        false,
+		SimpleExecDisplayNames:      false,
+		EmitWellKnownData:           false,
+		DataValueRules:              nil,
+		FSMonitorIndicators:         FSMonitorIndicators{},
+		MaxDisplayNameLen:           0,
+		EmitCounterCategoryTotals:   false,
+		MinChildMs:                  0,
+		EmitReceiverUptime:          false,
+		LenientRegionData:           false,
+		EmitCmdOutcome:              false,
+		OutcomeExitCodes:            nil,
+		outcomeExitCodes:            nil,
+		MinRegionMs:                 0,
+		PromoteSuppressedRegionData: false,
+		RegionSummarizeThreshold:    0,
+		TimeResolution:              "ns",
+		timeResolution:              time.Nanosecond,
+		CIIdParam:                   "",
+		FoldThreadMetrics:           false,
+		AllowBriefMode:              false,
+		MaxErrorMessages:            DefaultMaxErrorMessages,
+		EmitMetrics:                 false,
+		ReadIdleTimeout:             0,
+		MaxConnectionDuration:       0,
+		MaxAttributeBytes:           DefaultMaxAttributeBytes,
+		AllowNegativeDurations:      false,
+		MaxRegions:                  DefaultMaxRegions,
+		MaxChildren:                 DefaultMaxChildren,
+		MaxThreads:                  DefaultMaxThreads,
+		AncestryAsSpans:             false,
+		ServiceName:                 "",
+		ResourceAttributes:          nil,
+		EmitLogs:                    false,
+		RegionNameNormalization:     RegionNameNormalization{},
+		SlowThresholdSec:            0,
+		RegionLeavePolicy:           "prefer-enter",
+		regionLeavePolicy:           regionLeavePreferEnter,
+		DebugRingSize:               0,
+		MaxConcurrentConnections:    0,
+		ConnectionLimitPolicy:       "queue",
+		connectionLimitPolicy:       connectionLimitQueue,
+		PiiSettingsPath:             "",
+		piiSettings:                 nil,
+		FilterSettingsPath:          "",
+		filterSettings:              nil,
+		SettingsReloadInterval:      0,
+		PostProcessTraces:           nil,
 	}
 }
 
-//func createMetrics(_ context.Context, params receiver.CreateSettings, baseCfg component.Config, consumer consumer.Metrics) (receiver.Metrics, error) {
-//	return nil, nil
-//}
-
-//func createLogs(_ context.Context, params receiver.CreateSettings, baseCfg component.Config, consumer consumer.Logs) (receiver.Logs, error) {
-//	return nil, nil
-//}
-
 // NewFactory creates a factory for trace2 receiver.
 func NewFactory() receiver.Factory {
 	return receiver.NewFactory(
 		typeStr,
 		createDefaultConfig,
 		receiver.WithTraces(createTraces, stability),
-	//receiver.WithMetrics(createMetrics, stability),
-	//receiver.WithLogs(createLogs, stability),
+		receiver.WithMetrics(createMetrics, stability),
+		receiver.WithLogs(createLogs, stability),
 	)
 }
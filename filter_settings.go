@@ -2,6 +2,10 @@ package trace2receiver
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -15,9 +19,186 @@ type FilterSettings struct {
 	Rulesets  FilterRulesets  `mapstructure:"rulesets"`
 	Defaults  FilterDefaults  `mapstructure:"defaults"`
 
+	// ParamDenylist is a list of glob patterns of Git config keys
+	// (from `def_param` events) that must never be emitted in the
+	// `trace2.param.set` attribute.  These are appended to the
+	// builtin `defaultParamDenylist`.  Denied keys are not stored,
+	// except for the `Keynames` (which we need for the lookups).
+	ParamDenylist []string `mapstructure:"param_denylist"`
+
+	// ParamAllowlist is an optional list of glob patterns of Git config
+	// keys.  If set, only matching keys are emitted in the
+	// `trace2.param.set` attribute.  The denylist still applies to them.
+	ParamAllowlist []string `mapstructure:"param_allowlist"`
+
+	// RejectIfParam is a list of `<key>[=<value>]` glob patterns.
+	// If a command sends a `def_param` that matches any of them, we
+	// drop the telemetry for the command.
+	RejectIfParam []string `mapstructure:"reject_if_param"`
+
+	// RejectVerbs is a list of Git command verbs (such as `daemon`)
+	// for long-running commands whose telemetry we never want.  We
+	// reject the client as soon as we see the `cmd_name` event.
+	// These are in addition to the builtin `fsmonitor--daemon`.
+	RejectVerbs []string `mapstructure:"reject_verbs"`
+
+	// ShadowRuleset is an optional ruleset (or detail level) name.
+	// If set, we also compute the detail level that it would have
+	// selected for each command and report it (along with the one
+	// actually used) on the process span.  It does not change the
+	// filtering.
+	ShadowRuleset string `mapstructure:"shadow_ruleset"`
+
+	// OperationNames maps Git command verbs (or qualified `<exe>:<verb>`
+	// names) to friendly operation names for `trace2.cmd.operation`.
+	OperationNames map[string]string `mapstructure:"operation_names"`
+
+	// StrictCommandKeys turns the warnings about ruleset command keys
+	// that do not look like `<exe>[:<verb>[#<mode>]]` into errors.
+	StrictCommandKeys bool `mapstructure:"strict_command_keys"`
+
 	// The set of custom rulesets defined in YML are each parsed
 	// and loaded into definitions so that we can use them.
 	rulesetDefs map[string]*RulesetDefinition
+
+	// The directory searched by the `rs:*` ruleset entry, if any, so
+	// that the settings reloader can notice when files are added to
+	// or removed from it.
+	rulesetDir string
+
+	// The compiled `ParamDenylist` patterns.
+	paramDenylist []*regexp.Regexp
+
+	// The compiled `ParamAllowlist` patterns.
+	paramAllowlist []*regexp.Regexp
+
+	// The compiled `RejectIfParam` patterns.
+	rejectIfParam []*paramMatcher
+
+	// The compiled glob patterns in the `Nicknames` table (sorted
+	// so that the longest (most specific) patterns are tried first).
+	nicknameGlobs []*nicknameGlob
+}
+
+// The builtin set of glob patterns for Git config keys that probably
+// contain secrets.  These are always stripped from the emitted param
+// set.  Git reports config keys with lowercase section and key names,
+// so we match them case-insensitively.
+var defaultParamDenylist []string = []string{
+	"*password",
+	"*token",
+	"*secret",
+	"*.extraheader",
+}
+
+var defaultParamDenylistRe []*regexp.Regexp = mustCompileParamGlobs(defaultParamDenylist)
+
+func compileParamGlobs(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+
+	for _, p := range patterns {
+		re, err := compileGlob(strings.ToLower(p))
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+
+	return res, nil
+}
+
+func mustCompileParamGlobs(patterns []string) []*regexp.Regexp {
+	res, err := compileParamGlobs(patterns)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// A paramMatcher matches a `def_param` key and optionally its value
+// using glob patterns.  Git reports config keys with lowercase section
+// and key names, so we match keys case-insensitively.  Values are
+// matched case-sensitively.
+type paramMatcher struct {
+	key   *regexp.Regexp
+	value *regexp.Regexp // nil matches any value
+}
+
+// Compile a `<key>[=<value>]` glob pattern.
+func compileParamMatcher(pattern string) (*paramMatcher, error) {
+	var err error
+	pm := &paramMatcher{}
+
+	k, v, hasValue := strings.Cut(pattern, "=")
+	if len(k) == 0 {
+		return nil, fmt.Errorf("empty key in '%s'", pattern)
+	}
+
+	if pm.key, err = compileGlob(strings.ToLower(k)); err != nil {
+		return nil, err
+	}
+	if hasValue {
+		if pm.value, err = compileGlob(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return pm, nil
+}
+
+func compileParamMatchers(patterns []string) ([]*paramMatcher, error) {
+	var res []*paramMatcher
+
+	for _, p := range patterns {
+		pm, err := compileParamMatcher(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, pm)
+	}
+
+	return res, nil
+}
+
+// Return the key of the first param that matches any of the matchers.
+func findMatchingParam(matchers []*paramMatcher, params map[string]string) (string, bool) {
+	for k, v := range params {
+		lk := strings.ToLower(k)
+		for _, pm := range matchers {
+			if pm.key.MatchString(lk) && (pm.value == nil || pm.value.MatchString(v)) {
+				return k, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Should we drop the telemetry for a command because it sent one of
+// the `RejectIfParam` params?  The filter settings are optional, so
+// `fs` may be nil.
+func (fs *FilterSettings) shouldRejectByParams(params map[string]string) (string, bool) {
+	if fs == nil || len(fs.rejectIfParam) == 0 {
+		return "", false
+	}
+
+	return findMatchingParam(fs.rejectIfParam, params)
+}
+
+// Is this Git command verb in the `RejectVerbs` list?  The filter
+// settings are optional, so `fs` may be nil.
+func (fs *FilterSettings) isRejectedVerb(verb string) bool {
+	if fs == nil {
+		return false
+	}
+
+	for _, v := range fs.RejectVerbs {
+		if v == verb {
+			return true
+		}
+	}
+
+	return false
 }
 
 // FilterKeynames defines the names of the Git config settings that
@@ -38,13 +219,31 @@ type FilterKeynames struct {
 	// This can eliminate the need to rely on `remote.origin.url`
 	// or the worktree root directory to identify (or guess at
 	// the identity of) the repo.
-	NicknameKey string `mapstructure:"nickname_key"`
+	NicknameKey FilterKeynameList `mapstructure:"nickname_key"`
 
 	// RuleSetKey defines the Git config setting that can be used
 	// to optionally send the name of the desired filter ruleset.
 	// This value overrides any implied ruleset associated with
 	// the RepoIdKey.
-	RulesetKey string `mapstructure:"ruleset_key"`
+	RulesetKey FilterKeynameList `mapstructure:"ruleset_key"`
+}
+
+// FilterKeynameList is a list of Git config settings that are tried
+// in order.  The first one present in the `def_param` events wins.
+// This allows a site to migrate to a new config namespace.  In the
+// YML this may be written as a single string or a list of strings.
+type FilterKeynameList []string
+
+// Find the first key in the list that has a non-empty value in the
+// params.
+func (kl FilterKeynameList) lookup(params map[string]string) (key string, value string, ok bool) {
+	for _, k := range kl {
+		if v, ok := params[k]; ok && len(v) > 0 {
+			return k, v, true
+		}
+	}
+
+	return "", "", false
 }
 
 // FilterDefaults defines default filtering values.
@@ -59,17 +258,125 @@ type FilterDefaults struct {
 }
 
 // FilterNicknames is used to map a repo nickname to the name of the
-// ruleset or detail-level that should be used.
+// ruleset or detail-level that should be used.  The keys may be glob
+// patterns, such as `monorepo-*`, but an exact match is preferred.
 //
 // This table is optional.
 type FilterNicknames map[string]string
 
+// A nicknameGlob is a compiled glob pattern from the `Nicknames` table.
+type nicknameGlob struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+// Compile the nickname keys that contain glob characters.  We cannot
+// preserve the order of the keys in the YML map, so we try the longest
+// patterns first (and sort ties alphabetically) so that the lookups
+// are deterministic.
+func compileNicknameGlobs(nicknames FilterNicknames) ([]*nicknameGlob, error) {
+	var res []*nicknameGlob
+
+	for k := range nicknames {
+		if !strings.ContainsAny(k, "*?") {
+			continue
+		}
+		re, err := compileGlob(k)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, &nicknameGlob{pattern: k, re: re})
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if len(res[i].pattern) != len(res[j].pattern) {
+			return len(res[i].pattern) > len(res[j].pattern)
+		}
+		return res[i].pattern < res[j].pattern
+	})
+
+	return res, nil
+}
+
+// Return the first nickname glob pattern that matches this nickname.
+func (fs *FilterSettings) findNicknameGlob(nickname string) (string, bool) {
+	for _, g := range fs.nicknameGlobs {
+		if g.re.MatchString(nickname) {
+			return g.pattern, true
+		}
+	}
+
+	return "", false
+}
+
 // FilterRulesets is used to map a custom ruleset name to the pathname
 // of the associated YML file.  This form is used when parsing the
 // filter settings YML file.  We use this to create the real ruleset
 // table (possibly with lazy loading).
+//
+// The special `rs:*` entry may name a directory or a glob pattern
+// rather than a single file.  See `expandRulesetGlob()`.
 type FilterRulesets map[string]string
 
+// The name of the `rulesets` entry whose value is a directory (or
+// a glob pattern) of ruleset files.
+const rulesetGlobName = "rs:*"
+
+// Replace the `rs:*` entry in the rulesets table (if present) with an
+// entry for each matching file.  If the value is a directory, we look
+// for `rs-*.yml` files in it.  Each file is registered under a name
+// derived from its filename, so `rs-status.yml` becomes `rs:status`.
+// It is an error if a derived name collides with an explicit entry
+// (or another matching file).  Returns the new table and the
+// directory that was searched.
+func expandRulesetGlob(rulesets FilterRulesets) (FilterRulesets, string, error) {
+	pattern, ok := rulesets[rulesetGlobName]
+	if !ok {
+		return rulesets, "", nil
+	}
+
+	pattern, err := expandPathname(pattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid pathname for ruleset '%s': '%s'",
+			rulesetGlobName, err.Error())
+	}
+	if len(pattern) == 0 {
+		return nil, "", fmt.Errorf("empty pathname for ruleset '%s'", rulesetGlobName)
+	}
+
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		pattern = filepath.Join(pattern, "rs-*.yml")
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid pattern for ruleset '%s': '%s'",
+			rulesetGlobName, err.Error())
+	}
+
+	result := make(FilterRulesets)
+	for k_rs_name, v_rs_path := range rulesets {
+		if k_rs_name != rulesetGlobName {
+			result[k_rs_name] = v_rs_path
+		}
+	}
+
+	for _, m := range matches {
+		base := strings.TrimSuffix(filepath.Base(m), filepath.Ext(m))
+		k_rs_name := "rs:" + strings.TrimPrefix(base, "rs-")
+		if len(k_rs_name) < 4 {
+			continue
+		}
+		if other, dup := result[k_rs_name]; dup {
+			return nil, "", fmt.Errorf("ruleset '%s' from '%s' collides with '%s'",
+				k_rs_name, m, other)
+		}
+		result[k_rs_name] = m
+	}
+
+	return result, filepath.Dir(pattern), nil
+}
+
 // Parse `filter.yml` in decode.
 func parseFilterSettings(path string) (*FilterSettings, error) {
 	return parseYmlFile[FilterSettings](path, parseFilterSettingsFromBuffer)
@@ -85,6 +392,53 @@ func parseFilterSettingsFromBuffer(data []byte, path string) (*FilterSettings, e
 	// After parsing the YML and populating the `mapstructure` fields, we need
 	// to validate them and/or build internal structures from them.
 
+	fs.paramDenylist, err = compileParamGlobs(fs.ParamDenylist)
+	if err != nil {
+		return nil, fmt.Errorf("filter settings '%s' has invalid param_denylist: '%s'",
+			path, err.Error())
+	}
+
+	fs.paramAllowlist, err = compileParamGlobs(fs.ParamAllowlist)
+	if err != nil {
+		return nil, fmt.Errorf("filter settings '%s' has invalid param_allowlist: '%s'",
+			path, err.Error())
+	}
+
+	fs.rejectIfParam, err = compileParamMatchers(fs.RejectIfParam)
+	if err != nil {
+		return nil, fmt.Errorf("filter settings '%s' has invalid reject_if_param: '%s'",
+			path, err.Error())
+	}
+
+	fs.nicknameGlobs, err = compileNicknameGlobs(fs.Nicknames)
+	if err != nil {
+		return nil, fmt.Errorf("filter settings '%s' has invalid nickname pattern: '%s'",
+			path, err.Error())
+	}
+
+	// The nickname and default ruleset values are not otherwise validated
+	// (since an unknown name just falls back to the builtin default), but
+	// a malformed sampling directive is probably a typo in the rate.
+	for k_nn, v_rs_dl := range fs.Nicknames {
+		if isSampleDetailLevelName(v_rs_dl) {
+			if _, _, err = parseDetailLevel(v_rs_dl); err != nil {
+				return nil, fmt.Errorf("filter settings '%s' has invalid sample rate for nickname '%s': '%s'",
+					path, k_nn, v_rs_dl)
+			}
+		}
+	}
+	if isSampleDetailLevelName(fs.Defaults.RulesetName) {
+		if _, _, err = parseDetailLevel(fs.Defaults.RulesetName); err != nil {
+			return nil, fmt.Errorf("filter settings '%s' has invalid default sample rate: '%s'",
+				path, fs.Defaults.RulesetName)
+		}
+	}
+
+	fs.Rulesets, fs.rulesetDir, err = expandRulesetGlob(fs.Rulesets)
+	if err != nil {
+		return nil, fmt.Errorf("filter settings '%s' has %s", path, err.Error())
+	}
+
 	// For each custom ruleset [<name> -> <path>] in the table (the map[string]string),
 	// create a peer entry in the internal [<name> -> <rsdef>] table and preload
 	// the various `ruleset.yml` files.
@@ -94,15 +448,59 @@ func parseFilterSettingsFromBuffer(data []byte, path string) (*FilterSettings, e
 			return nil, fmt.Errorf("ruleset has invalid name or pathname'%s':'%s'", k_rs_name, v_rs_path)
 		}
 
+		// Remember the expanded pathname so that the settings reloader
+		// watches the actual file.
+		v_rs_path, err = expandPathname(v_rs_path)
+		if err != nil {
+			return nil, fmt.Errorf("filter settings '%s' has invalid pathname for ruleset '%s': '%s'",
+				path, k_rs_name, err.Error())
+		}
+		fs.Rulesets[k_rs_name] = v_rs_path
+
 		fs.rulesetDefs[k_rs_name], err = parseRulesetFile(v_rs_path)
 		if err != nil {
 			return nil, err
 		}
+
+		if fs.StrictCommandKeys && len(fs.rulesetDefs[k_rs_name].keyWarnings) > 0 {
+			return nil, fmt.Errorf("%s", fs.rulesetDefs[k_rs_name].keyWarnings[0])
+		}
+	}
+
+	// Unlike the nicknames, the shadow ruleset is not requested by the
+	// Git command, so an unknown name is a configuration error.
+	if len(fs.ShadowRuleset) > 0 {
+		_, _, err = parseDetailLevel(fs.ShadowRuleset)
+		_, ok := fs.rulesetDefs[fs.ShadowRuleset]
+		if err != nil && !ok {
+			return nil, fmt.Errorf("filter settings '%s' has invalid shadow_ruleset: '%s'",
+				path, fs.ShadowRuleset)
+		}
 	}
 
 	return fs, nil
 }
 
+// Get the warnings about suspicious command keys in all of the
+// rulesets.  The filter settings are optional, so `fs` may be nil.
+func (fs *FilterSettings) commandKeyWarnings() []string {
+	if fs == nil {
+		return nil
+	}
+
+	var names []string
+	for k_rs_name := range fs.rulesetDefs {
+		names = append(names, k_rs_name)
+	}
+	sort.Strings(names)
+
+	var warnings []string
+	for _, k_rs_name := range names {
+		warnings = append(warnings, fs.rulesetDefs[k_rs_name].keyWarnings...)
+	}
+	return warnings
+}
+
 // Add a ruleset to the filter settings.  This is primarily for writing test code.
 func (fs *FilterSettings) addRuleset(rs_name string, path string, rsdef *RulesetDefinition) {
 	if fs.Rulesets == nil {
@@ -116,6 +514,109 @@ func (fs *FilterSettings) addRuleset(rs_name string, path string, rsdef *Ruleset
 	fs.rulesetDefs[rs_name] = rsdef
 }
 
+// Is this Git config key in either the builtin or the custom
+// param denylist?  The filter settings are optional, so `fs` may
+// be nil.
+func isParamDenied(fs *FilterSettings, key string) bool {
+	lk := strings.ToLower(key)
+
+	for _, re := range defaultParamDenylistRe {
+		if re.MatchString(lk) {
+			return true
+		}
+	}
+
+	if fs != nil {
+		for _, re := range fs.paramDenylist {
+			if re.MatchString(lk) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Is this Git config key in the custom param allowlist?  If there
+// is no allowlist, all keys are allowed.
+func isParamAllowed(fs *FilterSettings, key string) bool {
+	if fs == nil || len(fs.paramAllowlist) == 0 {
+		return true
+	}
+
+	lk := strings.ToLower(key)
+	for _, re := range fs.paramAllowlist {
+		if re.MatchString(lk) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Is this Git config key one of the `nickname_key` or `ruleset_key`
+// keynames?  We need those for filtering even if they are denied.
+func (fs *FilterSettings) isKeyname(key string) bool {
+	if fs == nil {
+		return false
+	}
+
+	for _, kl := range []FilterKeynameList{fs.Keynames.NicknameKey, fs.Keynames.RulesetKey} {
+		for _, k := range kl {
+			if k == key {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Could this Git config key match one of the `RejectIfParam` patterns?
+// We only look at the key here, since we need to keep the value to
+// be able to match it later.
+func (fs *FilterSettings) isRejectIfParamKey(key string) bool {
+	if fs == nil {
+		return false
+	}
+
+	lk := strings.ToLower(key)
+	for _, pm := range fs.rejectIfParam {
+		if pm.key.MatchString(lk) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Should we remember the value of this `def_param`?  We don't want to
+// keep denied values (which may contain secrets) any longer than
+// necessary, unless we need them for filtering (or for the CI build
+// id).  Denied keys are still removed from the emitted param set by
+// `makeEmittableParamSet()`.
+func shouldStoreParam(fs *FilterSettings, ciIdParam string, key string) bool {
+	if !isParamDenied(fs, key) || fs.isKeyname(key) || fs.isRejectIfParamKey(key) {
+		return true
+	}
+
+	return len(ciIdParam) > 0 && key == ciIdParam
+}
+
+// Return a copy of the param set with only the allowed keys and
+// without any denied keys.
+func makeEmittableParamSet(fs *FilterSettings, params map[string]string) map[string]string {
+	res := make(map[string]string)
+
+	for k, v := range params {
+		if !isParamDenied(fs, k) && isParamAllowed(fs, k) {
+			res[k] = v
+		}
+	}
+
+	return res
+}
+
 // For example:
 //
 // Tell Git to send a `def_param` for all config settings with
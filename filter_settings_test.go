@@ -1,9 +1,18 @@
 package trace2receiver
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
 )
 
 // Each of the "TEST/*" pathnames are a fake placeholder to make
@@ -149,6 +158,46 @@ func Test_RulesetKey_FilterSettings(t *testing.T) {
 
 // //////////////////////////////////////////////////////////////
 
+var x_fs_multikey_yml string = `
+keynames:
+  ruleset_key: ["company.git.ruleset", "otel.trace2.ruleset"]
+  nickname_key:
+    - "company.git.nickname"
+    - "otel.trace2.nickname"
+
+nicknames:
+  "monorepo": "rs:rsdef1"
+
+defaults:
+  ruleset: "rs:rsdef0"
+`
+
+// Verify that a list of key names can be used during a migration
+// and that the first key present wins.
+func Test_MultipleKeynames_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_multikey_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef1_name, x_rs_path, x_rs_rsdef1_yml)
+
+	assert.Equal(t, 2, len(fs.Keynames.RulesetKey))
+	assert.Equal(t, 2, len(fs.Keynames.NicknameKey))
+
+	params["otel.trace2.nickname"] = "monorepo"
+	dl, dl_debug := computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelSummary, dl)
+	assert.Equal(t, "[nickname(otel.trace2.nickname) -> monorepo]/[monorepo -> rs:rsdef1]/[command -> c:v#m]/[ruleset-default -> dl:summary]", dl_debug)
+
+	params["otel.trace2.ruleset"] = "dl:verbose"
+	params["company.git.ruleset"] = "dl:drop"
+	dl, dl_debug = computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelDrop, dl)
+	assert.Equal(t, "[rskey(company.git.ruleset) -> dl:drop]", dl_debug)
+}
+
+// //////////////////////////////////////////////////////////////
+
 var x_nnkey string = "otel.trace2.nickname"
 var x_nn string = "monorepo"
 
@@ -203,6 +252,82 @@ func Test_NicknameKey_FilterSettings(t *testing.T) {
 
 // //////////////////////////////////////////////////////////////
 
+// Verify that the raw nickname is emitted on the process span even
+// when it does not map to a ruleset.
+func Test_RepoNickname_FilterSettings(t *testing.T) {
+	fs := x_TryLoadFilterSettings(t, x_fs_nnkey_yml, x_fs_path)
+
+	assert.Equal(t, "", fs.lookupRepoNickname(map[string]string{}))
+	assert.Equal(t, "", (*FilterSettings)(nil).lookupRepoNickname(map[string]string{x_nnkey: x_nn}))
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_def_param("local", x_nnkey, "unmapped"),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+
+	tr2.repoNickname = fs.lookupRepoNickname(tr2.process.paramSetValues)
+	assert.Equal(t, "unmapped", tr2.repoNickname)
+
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2RepoNickname))
+	assert.True(t, ok)
+	assert.Equal(t, "unmapped", v.Str())
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_fs_nnglob_yml string = `
+keynames:
+  nickname_key: "otel.trace2.nickname"
+
+nicknames:
+  "monorepo": "dl:verbose"
+  "monorepo-*": "rs:rsdef1"
+  "monorepo-team-?": "dl:drop"
+  "mono*": "dl:process"
+
+defaults:
+  ruleset: "rs:rsdef0"
+`
+
+// Verify that nickname glob patterns are tried after an exact match
+// and that the longest matching pattern wins.
+func Test_NicknameGlob_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_nnglob_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef1_name, x_rs_path, x_rs_rsdef1_yml)
+
+	params[x_nnkey] = "monorepo"
+	dl, dl_debug := computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelVerbose, dl)
+	assert.Equal(t, "[nickname -> monorepo]/[monorepo -> dl:verbose]", dl_debug)
+
+	params[x_nnkey] = "monorepo-team-abc"
+	dl, dl_debug = computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelSummary, dl)
+	assert.Equal(t, "[nickname -> monorepo-team-abc]/[monorepo-team-abc -> monorepo-*]/[monorepo-* -> rs:rsdef1]/[command -> c:v#m]/[ruleset-default -> dl:summary]", dl_debug)
+
+	params[x_nnkey] = "monorepo-team-a"
+	dl, _ = computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelDrop, dl)
+
+	params[x_nnkey] = "monolith"
+	dl, _ = computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelProcess, dl)
+
+	params[x_nnkey] = "other"
+	dl, dl_debug = computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelProcess, dl)
+	assert.Equal(t, "[nickname -> other]/[other -> UNKNOWN]/[default-ruleset -> rs:rsdef0]/[command -> c:v#m]/[ruleset-default -> dl:process]", dl_debug)
+}
+
+// //////////////////////////////////////////////////////////////
+
 var x_fs_rscmd0_yml string = `
 rulesets:
   # "rs:rscmd0": "TEST/rs.yml" (use addRuleset())
@@ -319,3 +444,1025 @@ func Test_FSNNKey_Nil_FilterSettings(t *testing.T) {
 	assert.Equal(t, DetailLevelProcess, dl)
 	assert.Equal(t, "[default-ruleset -> rs:rsdef0]/[command -> c:v#m]/[ruleset-default -> dl:process]", dl_debug)
 }
+
+// //////////////////////////////////////////////////////////////
+
+var x_fs_denylist_yml string = `
+param_denylist:
+  - "my.private.*"
+`
+
+// Verify that the builtin param denylist is always applied and
+// that the filter settings can extend it.
+func Test_ParamDenylist_FilterSettings(t *testing.T) {
+	params := map[string]string{
+		"otel.trace2.nickname":                  "monorepo",
+		"http.extraheader":                      "Authorization: xyz",
+		"http.https://example.com/.extraHeader": "Authorization: xyz",
+		"my.github.token":                       "abc",
+		"my.private.value":                      "def",
+	}
+
+	p := makeEmittableParamSet(nil, params)
+	assert.Equal(t, 2, len(p))
+	assert.Equal(t, "monorepo", p["otel.trace2.nickname"])
+	assert.Equal(t, "def", p["my.private.value"])
+
+	fs := x_TryLoadFilterSettings(t, x_fs_denylist_yml, x_fs_path)
+
+	p = makeEmittableParamSet(fs, params)
+	assert.Equal(t, 1, len(p))
+	assert.Equal(t, "monorepo", p["otel.trace2.nickname"])
+}
+
+var x_fs_allowlist_yml string = `
+param_allowlist:
+  - "otel.*"
+  - "my.*"
+param_denylist:
+  - "my.private.*"
+keynames:
+  nickname_key: "my.private.nickname"
+`
+
+// Verify that only allowed (and not denied) params are emitted and
+// that denied params are not stored (unless they are keynames).
+func Test_ParamAllowlist_FilterSettings(t *testing.T) {
+	params := map[string]string{
+		"otel.trace2.nickname": "monorepo",
+		"core.editor":          "vi",
+		"my.github.token":      "abc",
+		"my.private.value":     "def",
+		"my.public.value":      "ghi",
+	}
+
+	fs := x_TryLoadFilterSettings(t, x_fs_allowlist_yml, x_fs_path)
+
+	p := makeEmittableParamSet(fs, params)
+	assert.Equal(t, 2, len(p))
+	assert.Equal(t, "monorepo", p["otel.trace2.nickname"])
+	assert.Equal(t, "ghi", p["my.public.value"])
+
+	assert.True(t, shouldStoreParam(fs, "", "core.editor"))
+	assert.True(t, shouldStoreParam(fs, "", "my.private.nickname"))
+	assert.False(t, shouldStoreParam(fs, "", "my.private.value"))
+	assert.False(t, shouldStoreParam(fs, "", "my.github.token"))
+	assert.False(t, shouldStoreParam(nil, "", "my.github.token"))
+	assert.True(t, shouldStoreParam(nil, "my.github.token", "my.github.token"))
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_fs_reject_yml string = `
+reject_if_param:
+  - "feature.experimental=true"
+  - "my.rollout.*"
+`
+
+// Verify that commands are rejected when they send a matching param.
+func Test_RejectIfParam_FilterSettings(t *testing.T) {
+	fs := x_TryLoadFilterSettings(t, x_fs_reject_yml, x_fs_path)
+
+	var tests = []struct {
+		params map[string]string
+		reject bool
+	}{
+		{map[string]string{}, false},
+		{map[string]string{"feature.experimental": "false"}, false},
+		{map[string]string{"feature.experimental": "true"}, true},
+		{map[string]string{"Feature.Experimental": "true"}, true},
+		{map[string]string{"feature.experimental": "TRUE"}, false},
+		{map[string]string{"my.rollout.phase": "1"}, true},
+		{map[string]string{"my.other": "1"}, false},
+	}
+
+	for _, test := range tests {
+		_, reject := fs.shouldRejectByParams(test.params)
+		assert.Equal(t, test.reject, reject, test.params)
+	}
+
+	var nilfs *FilterSettings
+	_, reject := nilfs.shouldRejectByParams(map[string]string{"feature.experimental": "true"})
+	assert.False(t, reject)
+
+	_, err := parseFilterSettingsFromBuffer([]byte("reject_if_param:\n  - \"=true\"\n"), x_fs_path)
+	assert.NotNil(t, err)
+}
+
+var x_fs_reject_denied_yml string = `
+param_denylist:
+  - "my.rollout.*"
+reject_if_param:
+  - "my.rollout.*"
+  - "my.github.token=abc"
+`
+
+// Verify that denied params are still available for `reject_if_param`
+// and `ci_id_param`, but are not emitted.
+func Test_RejectIfParam_DeniedParam(t *testing.T) {
+	fs := x_TryLoadFilterSettings(t, x_fs_reject_denied_yml, x_fs_path)
+
+	var tests = []struct {
+		param  string
+		value  string
+		reject bool
+	}{
+		{"my.rollout.phase", "1", true},     // denied by `param_denylist`
+		{"my.github.token", "abc", true},    // denied by the builtin list
+		{"my.github.token", "xyz", false},   // value does not match
+		{"my.other.password", "abc", false}, // not mentioned
+	}
+
+	for _, test := range tests {
+		cfg := createDefaultConfig().(*Config)
+		cfg.filterSettings = fs
+		cfg.CIIdParam = "ci.build.secret"
+
+		tr2 := NewTrace2Dataset(&Rcvr_Base{Logger: zap.NewNop(), RcvrConfig: cfg})
+		events := []string{
+			x_make_version(),
+			x_make_start(),
+			x_make_def_param("system", test.param, test.value),
+			x_make_def_param("system", "ci.build.secret", "build-42"),
+			x_make_atexit(), // Should be last
+		}
+		for _, s := range events {
+			assert.Nil(t, processRawLine([]byte(s), tr2, zap.NewNop(), false))
+		}
+
+		_, reject := fs.shouldRejectByParams(tr2.process.paramSetValues)
+		assert.Equal(t, test.reject, reject, test.param)
+		if reject {
+			tr2.exportTraces()
+			assert.Equal(t, int64(1), tr2.rcvr_base.stats.get(rcvrStatDatasetsDropped), test.param)
+			continue
+		}
+
+		assert.True(t, tr2.prepareDataset())
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, ok := sm.Get(string(Trace2CIBuildId))
+		assert.True(t, ok, test.param)
+		assert.Equal(t, "build-42", v.Str(), test.param)
+		_, ok = sm.Get(string(Trace2ParamSet))
+		assert.False(t, ok, test.param)
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_fs_reject_verbs_yml string = `
+reject_verbs:
+  - "daemon"
+`
+
+// Verify that the builtin and custom verbs are rejected.
+func Test_RejectVerbs_FilterSettings(t *testing.T) {
+	fs := x_TryLoadFilterSettings(t, x_fs_reject_verbs_yml, x_fs_path)
+
+	var tests = []struct {
+		verb   string
+		reject bool
+	}{
+		{"status", false},
+		{"daemon", true},
+		{"fsmonitor--daemon", true},
+	}
+
+	for _, test := range tests {
+		err := IsRejectedVerb(test.verb, fs)
+		assert.Equal(t, test.reject, err != nil, test.verb)
+		if err != nil {
+			_, ok := err.(*RejectClientError)
+			assert.True(t, ok)
+		}
+	}
+
+	assert.Nil(t, IsRejectedVerb("daemon", nil))
+	assert.NotNil(t, IsRejectedVerb("fsmonitor--daemon", nil))
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_pii_name string = "rs:pii"
+
+var x_rs_pii_yml string = `
+defaults:
+  detail: "dl:summary"
+
+pii:
+  include:
+    username: true
+`
+
+// Verify that a ruleset can override the global PII settings
+// and that other rulesets use the global settings.
+func Test_RulesetPii_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_key_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_pii_name, x_rs_path, x_rs_pii_yml)
+
+	global := &PiiSettings{Include: PiiInclude{Hostname: true}}
+
+	cfg := &Config{piiSettings: global, filterSettings: fs}
+	inc := cfg.piiGatherInclude()
+	assert.True(t, inc.Hostname)
+	assert.True(t, inc.Username)
+
+	// The default ruleset "rs:rsdef0" does not have a PII override.
+	pii := fs.resolveRulesetDef(params).piiSettings(global)
+	assert.Equal(t, global, pii)
+
+	params[x_rkey] = x_rs_pii_name
+
+	pii = fs.resolveRulesetDef(params).piiSettings(global)
+	assert.False(t, pii.Include.Hostname)
+	assert.True(t, pii.Include.Username)
+
+	tr2 := NewTrace2Dataset(nil)
+	tr2.pii[string(Trace2PiiHostname)] = "host"
+	tr2.pii[string(Trace2PiiUsername)] = "user"
+	tr2.scrubPii(pii)
+	assert.Equal(t, 1, len(tr2.pii))
+	assert.Equal(t, "user", tr2.pii[string(Trace2PiiUsername)])
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_datacat_name string = "rs:datacat"
+
+var x_rs_datacat_yml string = `
+defaults:
+  detail: "dl:verbose"
+
+data_categories:
+  include: ["fetch", "pack", "index"]
+  exclude: ["index"]
+`
+
+// Verify that a ruleset can limit the data categories.
+func Test_RulesetDataCategories_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_key_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_datacat_name, x_rs_path, x_rs_datacat_yml)
+
+	// The default ruleset "rs:rsdef0" does not have a data category list.
+	assert.Nil(t, fs.resolveRulesetDef(params).dataCategories())
+
+	params[x_rkey] = x_rs_datacat_name
+
+	dc := fs.resolveRulesetDef(params).dataCategories()
+	assert.NotNil(t, dc)
+
+	tr2 := NewTrace2Dataset(nil)
+	tr2.process.setGenericDataValue("fetch", "k", int64(1))
+	tr2.process.setGenericDataValue("index", "k", int64(2))
+	tr2.process.setGenericDataValue("fsmonitor", "k", int64(3))
+	tr2.filterDataCategories(dc)
+	assert.Equal(t, 1, len(tr2.process.dataValues))
+	assert.Equal(t, int64(1), tr2.process.dataValues["fetch"]["k"])
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_attrs_name string = "rs:attrs"
+
+var x_rs_attrs_yml string = `
+defaults:
+  detail: "dl:summary"
+
+attributes:
+  include: ["counters"]
+  exclude: ["argv"]
+`
+
+// Verify that a ruleset can add and remove attribute groups on top
+// of the detail level and that bad group names are reported.
+func Test_RulesetAttributes_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_key_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_attrs_name, x_rs_path, x_rs_attrs_yml)
+
+	// The default ruleset "rs:rsdef0" does not have an attributes section.
+	assert.Nil(t, fs.resolveRulesetDef(params).attributeGroups())
+
+	params[x_rkey] = x_rs_attrs_name
+
+	ag := fs.resolveRulesetDef(params).attributeGroups()
+	assert.NotNil(t, ag)
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_counter("cat", "ctr-1", 7),
+		x_make_timer("cat", "tmr-1", 5, 4.0, 1.0, 2.0),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+
+	for _, test := range []struct {
+		ag       *RulesetAttributes
+		argv     bool
+		counters bool
+	}{
+		{nil, true, false},
+		{ag, false, true},
+	} {
+		tr2.attributeGroups = test.ag
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+
+		_, ok := sm.Get(string(Trace2CmdArgv))
+		assert.Equal(t, test.argv, ok)
+		_, ok = sm.Get(string(Trace2ProcessCounters))
+		assert.Equal(t, test.counters, ok)
+		_, ok = sm.Get(string(Trace2ProcessTimers))
+		assert.False(t, ok)
+	}
+
+	for _, yml := range []string{
+		"attributes:\n  include: [\"bogus\"]\n",
+		"attributes:\n  include: [\"timers\"]\n  exclude: [\"timers\"]\n",
+	} {
+		_, err := parseRulesetFromBuffer([]byte(yml), x_rs_path)
+		assert.NotNil(t, err, yml)
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_thignore_name string = "rs:thignore"
+
+var x_rs_thignore_yml string = `
+defaults:
+  detail: "dl:verbose"
+
+thread_ignore: ["preload"]
+thread_ignore_regions: reparent
+`
+
+// Verify that a ruleset can omit the spans of helper threads and
+// either drop or reparent their regions.
+func Test_RulesetThreadIgnore_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_key_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_thignore_name, x_rs_path, x_rs_thignore_yml)
+
+	// The default ruleset "rs:rsdef0" does not ignore any threads.
+	assert.Nil(t, fs.resolveRulesetDef(params).threadIgnore())
+
+	params[x_rkey] = x_rs_thignore_name
+
+	ti := fs.resolveRulesetDef(params).threadIgnore()
+	assert.NotNil(t, ti)
+	assert.True(t, ti.Reparent)
+	assert.True(t, ti.matches("th01:preload"))
+	assert.True(t, ti.matches("preload-index"))
+	assert.False(t, ti.matches("th02:fsm-listen"))
+
+	tr2, sufficient, _ := load_test_dataset(t, []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_region_enter(x_main, 1, "cat", "l1", "m1"),
+		x_make_region_leave(x_main, 1, "cat", "l1", "m1"),
+		x_make_thread_start("th01:preload"),
+		x_make_region_enter("th01:preload", 1, "cat", "t1", "m1"),
+		x_make_region_enter("th01:preload", 2, "cat", "t2", "m2"),
+		x_make_region_leave("th01:preload", 2, "cat", "t2", "m2"),
+		x_make_region_leave("th01:preload", 1, "cat", "t1", "m1"),
+		x_make_thread_exit("th01:preload"),
+		x_make_atexit(), // Should be last
+	})
+	assert.True(t, sufficient)
+
+	mainSpanID := tr2.process.mainThread.lifetime.selfSpanID
+
+	for _, test := range []struct {
+		ti      *RulesetThreadIgnore
+		nrSpans int
+	}{
+		{nil, 5},
+		{&RulesetThreadIgnore{Prefixes: []string{"preload"}}, 2},
+		{ti, 4},
+	} {
+		tr2.threadIgnore = test.ti
+		spans := tr2.ToTraces(DetailLevelVerbose).ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+		assert.Equal(t, test.nrSpans, spans.Len())
+
+		nrReparented := 0
+		for k := 0; k < spans.Len(); k++ {
+			if [8]byte(spans.At(k).ParentSpanID()) == mainSpanID {
+				nrReparented++
+			}
+		}
+		// The main region, plus the thread span or its top-level region.
+		if test.nrSpans > 2 {
+			assert.Equal(t, 2, nrReparented)
+		}
+	}
+
+	for _, yml := range []string{
+		"thread_ignore: [\"\"]\n",
+		"thread_ignore: [\"preload\"]\nthread_ignore_regions: bogus\n",
+	} {
+		_, err := parseRulesetFromBuffer([]byte(yml), x_rs_path)
+		assert.NotNil(t, err, yml)
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_minregion_name string = "rs:minregion"
+
+var x_rs_minregion_yml string = `
+defaults:
+  detail: "dl:verbose"
+
+min_region_duration_sec: 0.5
+`
+
+// Verify that a ruleset can set the minimum region duration and that
+// it overrides the global setting.
+func Test_RulesetMinRegionDuration_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_key_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_rsdef0_yml)
+	x_TryLoadRuleset(t, fs, x_rs_minregion_name, x_rs_path, x_rs_minregion_yml)
+
+	// The default ruleset "rs:rsdef0" does not set it.
+	assert.Equal(t, time.Duration(0), fs.resolveRulesetDef(params).minRegionDuration())
+
+	params[x_rkey] = x_rs_minregion_name
+	assert.Equal(t, 500*time.Millisecond, fs.resolveRulesetDef(params).minRegionDuration())
+
+	tr2 := NewTrace2Dataset(&Rcvr_Base{
+		Logger:     zap.NewNop(),
+		RcvrConfig: &Config{MinRegionMs: 60000},
+	})
+	tr2.rulesetMinRegion = fs.resolveRulesetDef(params).minRegionDuration()
+
+	t0 := time.Now()
+	for k, d := range []time.Duration{100 * time.Millisecond, 2 * time.Second} {
+		tr2.completedRegions = append(tr2.completedRegions, &TrRegion{
+			lifetime: TrSpanEssentials{
+				selfSpanID: [8]byte{byte(k + 1)},
+				startTime:  t0,
+				endTime:    t0.Add(d),
+			},
+		})
+	}
+
+	rs := tr2.computeRegionSuppression()
+	assert.Equal(t, int64(1), rs.count())
+	assert.True(t, rs.isSuppressed(tr2.completedRegions[0]))
+	assert.False(t, rs.isSuppressed(tr2.completedRegions[1]))
+
+	_, err := parseRulesetFromBuffer([]byte("min_region_duration_sec: -1\n"), x_rs_path)
+	assert.NotNil(t, err)
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_wildcard_yml string = `
+commands:
+  "git:checkout#*": "dl:verbose"
+  "git:*#path":     "dl:drop"
+  "git:*":          "dl:process"
+  "git":            "dl:summary"
+defaults:
+  detail: "dl:drop"
+`
+
+// Verify that wildcard command keys are tried after the exact keys
+// and before the plain exe key, most specific first.
+func Test_RulesetWildcards_FilterSettings(t *testing.T) {
+	rsdef, err := parseRulesetFromBuffer([]byte(x_rs_wildcard_yml), x_rs_path)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		qn    QualifiedNames
+		dl    string
+		debug string
+	}{
+		{QualifiedNames{"git", "git:checkout", "git:checkout#branch"}, "dl:verbose", "[git:checkout#* -> dl:verbose]"},
+		{QualifiedNames{"git", "git:checkout", "git:checkout#path"}, "dl:verbose", "[git:checkout#* -> dl:verbose]"},
+		{QualifiedNames{"git", "git:restore", "git:restore#path"}, "dl:drop", "[git:*#path -> dl:drop]"},
+		{QualifiedNames{"git", "git:status", "git:status"}, "dl:process", "[git:* -> dl:process]"},
+		{QualifiedNames{"git", "git", "git"}, "dl:summary", "[git -> dl:summary]"},
+	}
+
+	for _, test := range tests {
+		dl_name, ok, debug := rsdef.lookupCommandDetailLevelName(test.qn, "")
+		assert.True(t, ok)
+		assert.Equal(t, test.dl, dl_name)
+		assert.Equal(t, test.debug, debug)
+	}
+
+	_, ok, _ := rsdef.lookupCommandDetailLevelName(QualifiedNames{"gcm", "gcm:get", "gcm:get"}, "")
+	assert.False(t, ok)
+
+	for _, k := range []string{"git:check*", "git*", "*:status", "git:*#", "git:#*", "*"} {
+		yml := fmt.Sprintf("commands:\n  \"%s\": \"dl:drop\"\n", k)
+		_, err = parseRulesetFromBuffer([]byte(yml), x_rs_path)
+		assert.NotNil(t, err, k)
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+// Verify that command keys that do not look like `<exe>[:<verb>[#<mode>]]`
+// are reported as warnings (or as errors in strict mode).
+func Test_RulesetCommandKeyFormat_FilterSettings(t *testing.T) {
+	for _, k := range []string{"git", "git:status", "git:checkout#branch", "git:*#*", "Foo.UI:run"} {
+		assert.Nil(t, checkCommandKeyFormat(k), k)
+	}
+	for _, k := range []string{"git.status", "git/status", "git status", "git:status:x", "git#branch", ":status", "git:#x"} {
+		assert.NotNil(t, checkCommandKeyFormat(k), k)
+	}
+
+	dir := t.TempDir()
+	fsPath := filepath.Join(dir, "filter.yml")
+	rsPath := filepath.Join(dir, "rs.yml")
+
+	assert.Nil(t, os.WriteFile(rsPath, []byte("commands:\n  \"git.status\": \"dl:drop\"\n"), 0644))
+	assert.Nil(t, os.WriteFile(fsPath, []byte(fmt.Sprintf("rulesets:\n  \"rs:a\": \"%s\"\n", rsPath)), 0644))
+
+	fs, err := parseFilterSettings(fsPath)
+	assert.Nil(t, err)
+	warnings := fs.commandKeyWarnings()
+	assert.Equal(t, 1, len(warnings))
+	assert.Contains(t, warnings[0], "git.status")
+	assert.Contains(t, warnings[0], rsPath)
+
+	assert.Nil(t, os.WriteFile(fsPath, []byte(fmt.Sprintf("rulesets:\n  \"rs:a\": \"%s\"\nstrict_command_keys: true\n", rsPath)), 0644))
+
+	_, err = parseFilterSettings(fsPath)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "git.status")
+	assert.Contains(t, err.Error(), rsPath)
+}
+
+// //////////////////////////////////////////////////////////////
+
+// Verify that environment variables are expanded in ruleset pathnames
+// and that an unset variable is reported.
+func Test_RulesetPathnameExpansion_FilterSettings(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("X_TRACE2_CONFIG_DIR", dir)
+
+	fsPath := filepath.Join(dir, "filter.yml")
+	rsPath := filepath.Join(dir, "rs.yml")
+
+	assert.Nil(t, os.WriteFile(rsPath, []byte("defaults:\n  detail: \"dl:process\"\n"), 0644))
+	assert.Nil(t, os.WriteFile(fsPath, []byte("rulesets:\n  \"rs:a\": \"${X_TRACE2_CONFIG_DIR}/rs.yml\"\n"), 0644))
+
+	path, err := expandPathname("$X_TRACE2_CONFIG_DIR/filter.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, dir+"/filter.yml", path)
+
+	fs, err := parseFilterSettings(fsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, dir+"/rs.yml", fs.Rulesets["rs:a"])
+	assert.NotNil(t, fs.rulesetDefs["rs:a"])
+
+	assert.Nil(t, os.WriteFile(fsPath, []byte("rulesets:\n  \"rs:a\": \"${X_TRACE2_UNSET_DIR}/rs.yml\"\n"), 0644))
+
+	_, err = parseFilterSettings(fsPath)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "X_TRACE2_UNSET_DIR")
+}
+
+// Verify that the `rs:*` ruleset entry loads all of the ruleset files
+// in a directory (or matching a glob) and that their derived names
+// cannot collide with the explicit entries.
+func Test_RulesetGlob_FilterSettings(t *testing.T) {
+	dir := t.TempDir()
+	rsDir := filepath.Join(dir, "rulesets")
+	assert.Nil(t, os.Mkdir(rsDir, 0755))
+
+	rs_yml := []byte("defaults:\n  detail: \"dl:process\"\n")
+	for _, name := range []string{"rs-status.yml", "rs-fetch.yml", "notes.yml"} {
+		assert.Nil(t, os.WriteFile(filepath.Join(rsDir, name), rs_yml, 0644))
+	}
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "rs-x.yml"), rs_yml, 0644))
+
+	fsPath := filepath.Join(dir, "filter.yml")
+
+	for _, test := range []struct {
+		yml         string
+		expected    []string
+		expectedDir string
+	}{
+		{"rulesets:\n  \"rs:*\": \"" + rsDir + "\"\n  \"rs:x\": \"" + dir + "/rs-x.yml\"\n",
+			[]string{"rs:fetch", "rs:status", "rs:x"}, rsDir},
+		{"rulesets:\n  \"rs:*\": \"" + rsDir + "/rs-s*.yml\"\n",
+			[]string{"rs:status"}, rsDir},
+		{"rulesets:\n  \"rs:*\": \"" + dir + "/empty-*.yml\"\n",
+			nil, dir},
+	} {
+		assert.Nil(t, os.WriteFile(fsPath, []byte(test.yml), 0644))
+
+		fs, err := parseFilterSettings(fsPath)
+		assert.Nil(t, err, test.yml)
+
+		var names []string
+		for k_rs_name := range fs.rulesetDefs {
+			names = append(names, k_rs_name)
+		}
+		sort.Strings(names)
+		assert.Equal(t, test.expected, names, test.yml)
+		assert.Equal(t, test.expectedDir, fs.rulesetDir)
+		if len(test.expected) > 0 {
+			assert.Equal(t, filepath.Join(rsDir, "rs-status.yml"), fs.Rulesets["rs:status"])
+		}
+		_, ok := fs.Rulesets[rulesetGlobName]
+		assert.False(t, ok)
+	}
+
+	// An explicit entry may not have the same name as a matching file.
+	assert.Nil(t, os.WriteFile(fsPath, []byte("rulesets:\n  \"rs:*\": \""+rsDir+"\"\n  \"rs:status\": \""+dir+"/rs-x.yml\"\n"), 0644))
+	_, err := parseFilterSettings(fsPath)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "collides")
+
+	assert.Equal(t, 1, len(ValidateConfigFiles(fsPath)))
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_sample_yml string = `
+commands:
+  "c:v": "dl:sample:10"
+defaults:
+  detail: "dl:sample:1"
+`
+
+// Verify that a sampling directive is recognized in a ruleset and
+// as a requested detail level and that malformed rates are rejected.
+func Test_SampleDetailLevel_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_key_yml, x_fs_path)
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_sample_yml)
+
+	dl, rate, dl_debug := computeDetailLevelAndSampleRate(fs, params, x_qn)
+	assert.Equal(t, DetailLevelSummary, dl)
+	assert.Equal(t, 10, rate)
+	assert.Equal(t, "[default-ruleset -> rs:rsdef0]/[command -> c:v#m]/[c:v -> dl:sample:10]", dl_debug)
+
+	dl, rate, _ = computeDetailLevelAndSampleRate(fs, params, QualifiedNames{"c", "c:w", "c:w"})
+	assert.Equal(t, DetailLevelSummary, dl)
+	assert.Equal(t, 1, rate)
+
+	params[x_rkey] = "dl:sample:5"
+	dl, rate, _ = computeDetailLevelAndSampleRate(fs, params, x_qn)
+	assert.Equal(t, DetailLevelSummary, dl)
+	assert.Equal(t, 5, rate)
+
+	params[x_rkey] = "dl:verbose"
+	dl, rate, _ = computeDetailLevelAndSampleRate(fs, params, x_qn)
+	assert.Equal(t, DetailLevelVerbose, dl)
+	assert.Equal(t, 0, rate)
+
+	for _, v := range []string{"dl:sample:", "dl:sample:0", "dl:sample:-3", "dl:sample:x"} {
+		yml := fmt.Sprintf("commands:\n  \"c:v\": \"%s\"\n", v)
+		_, err := parseRulesetFromBuffer([]byte(yml), x_rs_path)
+		assert.NotNil(t, err, v)
+
+		yml = fmt.Sprintf("defaults:\n  ruleset: \"%s\"\n", v)
+		_, err = parseFilterSettingsFromBuffer([]byte(yml), x_fs_path)
+		assert.NotNil(t, err, v)
+
+		yml = fmt.Sprintf("nicknames:\n  \"monorepo\": \"%s\"\n", v)
+		_, err = parseFilterSettingsFromBuffer([]byte(yml), x_fs_path)
+		assert.NotNil(t, err, v)
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_fs_shadow_yml string = `
+defaults:
+  ruleset: "dl:summary"
+shadow_ruleset: "dl:verbose"
+`
+
+// Verify that the shadow ruleset is computed independently of the
+// primary one and that unknown shadow rulesets are rejected.
+func Test_ShadowRuleset_FilterSettings(t *testing.T) {
+	params := make(map[string]string)
+
+	fs := x_TryLoadFilterSettings(t, x_fs_shadow_yml, x_fs_path)
+
+	dl, _ := computeDetailLevel(fs, params, x_qn)
+	assert.Equal(t, DetailLevelSummary, dl)
+
+	shadow_dl, rate, debug, ok := computeShadowDetailLevel(fs, x_qn)
+	assert.True(t, ok)
+	assert.Equal(t, DetailLevelVerbose, shadow_dl)
+	assert.Equal(t, 0, rate)
+	assert.Equal(t, "[shadow-ruleset -> dl:verbose]", debug)
+
+	x_TryLoadRuleset(t, fs, x_rs_rsdef0_name, x_rs_path, x_rs_sample_yml)
+	fs.ShadowRuleset = x_rs_rsdef0_name
+
+	shadow_dl, rate, debug, ok = computeShadowDetailLevel(fs, x_qn)
+	assert.True(t, ok)
+	assert.Equal(t, "dl:sample:10", detailLevelName(shadow_dl, rate))
+	assert.Equal(t, "[shadow-ruleset -> rs:rsdef0]/[command -> c:v#m]/[c:v -> dl:sample:10]", debug)
+
+	_, _, _, ok = computeShadowDetailLevel(nil, x_qn)
+	assert.False(t, ok)
+
+	for _, v := range []string{"rs:unknown", "dl:bogus", "dl:sample:0"} {
+		yml := fmt.Sprintf("shadow_ruleset: \"%s\"\n", v)
+		_, err := parseFilterSettingsFromBuffer([]byte(yml), x_fs_path)
+		assert.NotNil(t, err, v)
+	}
+}
+
+var x_fs_shadow_process_yml string = `
+defaults:
+  ruleset: "dl:process"
+shadow_ruleset: "dl:verbose"
+`
+
+// Verify that both detail levels are emitted on every span.
+func Test_ShadowRuleset_AllSpans(t *testing.T) {
+	var consumed []ptrace.Traces
+	next, _ := consumer.NewTraces(func(ctx context.Context, td ptrace.Traces) error {
+		consumed = append(consumed, td)
+		return nil
+	})
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.filterSettings = x_TryLoadFilterSettings(t, x_fs_shadow_process_yml, x_fs_path)
+
+	tr2 := NewTrace2Dataset(&Rcvr_Base{
+		Logger:         zap.NewNop(),
+		RcvrConfig:     cfg,
+		TracesConsumer: next,
+	})
+	events := []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_child_start(0, "class-0", "aa0", "bb0"),
+		x_make_child_exit(0, 1234, 0),
+		x_make_atexit(), // Should be last
+	}
+	for _, s := range events {
+		assert.Nil(t, processRawLine([]byte(s), tr2, zap.NewNop(), false))
+	}
+	tr2.exportTraces()
+
+	assert.Equal(t, 1, len(consumed))
+	spans := consumed[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	assert.Equal(t, 2, spans.Len())
+	for k := 0; k < spans.Len(); k++ {
+		sm := spans.At(k).Attributes()
+		v, ok := sm.Get(string(Trace2FilterDetail))
+		assert.True(t, ok, spans.At(k).Name())
+		assert.Equal(t, "dl:process", v.Str(), spans.At(k).Name())
+		v, ok = sm.Get(string(Trace2FilterShadowDetail))
+		assert.True(t, ok, spans.At(k).Name())
+		assert.Equal(t, "dl:verbose", v.Str(), spans.At(k).Name())
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_fs_opnames_yml string = `
+operation_names:
+  "index-pack": "receive-objects"
+  "git:remote-https": "https-transport"
+`
+
+// Verify that verbs are mapped to friendly operation names.
+func Test_OperationNames_FilterSettings(t *testing.T) {
+	fs := x_TryLoadFilterSettings(t, x_fs_opnames_yml, x_fs_path)
+
+	var tests = []struct {
+		argv      []interface{}
+		verb      string
+		operation string
+	}{
+		{[]interface{}{"git", "index-pack"}, "index-pack", "receive-objects"},
+		{[]interface{}{"git", "remote-https", "origin"}, "_run_dashed_", "https-transport"},
+		{[]interface{}{"git", "status"}, "status", "status"},
+	}
+
+	for _, test := range tests {
+		tr2 := NewTrace2Dataset(&Rcvr_Base{
+			Logger:     zap.NewNop(),
+			RcvrConfig: &Config{filterSettings: fs},
+		})
+		tr2.process.cmdArgv = test.argv
+		tr2.process.cmdVerb = test.verb
+		assert.True(t, tr2.prepareDataset())
+
+		sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+		v, ok := sm.Get(string(Trace2CmdOperation))
+		assert.True(t, ok, test.verb)
+		assert.Equal(t, test.operation, v.Str(), test.verb)
+	}
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_rs_piihash_yml string = `
+pii:
+  include:
+    hostname_hash: true
+  hash_salt: "pepper"
+`
+
+// Verify that the hostname and username can be hashed rather than
+// included as is and that the raw and hashed forms are exclusive.
+func Test_PiiHash_FilterSettings(t *testing.T) {
+	rsdef, err := parseRulesetFromBuffer([]byte(x_rs_piihash_yml), x_rs_path)
+	assert.Nil(t, err)
+
+	cfg := &Config{piiSettings: &PiiSettings{}}
+	assert.False(t, cfg.piiGatherInclude().Hostname)
+
+	cfg.piiSettings.Include.HostnameHash = true
+	assert.True(t, cfg.piiGatherInclude().Hostname)
+	assert.False(t, cfg.piiGatherInclude().Username)
+
+	tr2 := NewTrace2Dataset(nil)
+	tr2.pii[string(Trace2PiiHostname)] = "host"
+	tr2.pii[string(Trace2PiiUsername)] = "user"
+	tr2.scrubPii(rsdef.Pii)
+	assert.Equal(t, 1, len(tr2.pii))
+	assert.Equal(t, hashPiiValue("host", "pepper"), tr2.pii[string(Trace2PiiHostnameHash)])
+	assert.NotEqual(t, hashPiiValue("host", ""), hashPiiValue("host", "pepper"))
+
+	_, err = parsePiiFromBuffer([]byte("include:\n  username: true\n  username_hash: true\n"), x_fs_path)
+	assert.NotNil(t, err)
+
+	_, err = parseRulesetFromBuffer([]byte("pii:\n  include:\n    hostname: true\n    hostname_hash: true\n"), x_rs_path)
+	assert.NotNil(t, err)
+}
+
+// //////////////////////////////////////////////////////////////
+
+var x_pii_scrub_yml string = `
+scrub:
+  worktree_paths:
+    - pattern: "^/home/[^/]+/"
+      replacement: "/home/USER/"
+    - pattern: "/src/secret-[^/]*"
+      replacement: "/src/PROJECT"
+`
+
+// Verify that the worktree scrub rules are applied to the repo set.
+func Test_PiiScrubWorktreePaths(t *testing.T) {
+	pii, err := parsePiiFromBuffer([]byte(x_pii_scrub_yml), x_fs_path)
+	assert.Nil(t, err)
+
+	tr2 := NewTrace2Dataset(nil)
+	tr2.process.cmdArgv = []interface{}{"git", "status"}
+	tr2.process.repoSet[1] = "/home/alice/src/secret-project"
+	tr2.process.repoSet[2] = "/opt/build/repo"
+	tr2.prepareDataset()
+	tr2.scrubPii(pii)
+
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2RepoSet))
+	assert.True(t, ok)
+	assert.Equal(t, `{"1":"/home/USER/src/PROJECT","2":"/opt/build/repo"}`, v.Str())
+
+	_, err = parsePiiFromBuffer([]byte("scrub:\n  worktree_paths:\n    - pattern: \"(\"\n"), x_fs_path)
+	assert.NotNil(t, err)
+}
+
+// //////////////////////////////////////////////////////////////
+
+// Verify that the peer PID is only emitted when requested.
+func Test_PiiPeerPid(t *testing.T) {
+	pii, err := parsePiiFromBuffer([]byte("include:\n  peer_pid: true\n"), x_fs_path)
+	assert.Nil(t, err)
+	assert.True(t, pii.Include.PeerPid)
+
+	cfg := &Config{piiSettings: pii}
+	assert.True(t, cfg.piiGatherInclude().PeerPid)
+	assert.False(t, cfg.piiGatherInclude().Username)
+
+	tr2 := NewTrace2Dataset(nil)
+	tr2.process.cmdArgv = []interface{}{"git", "status"}
+	tr2.peerPid = 4242
+	tr2.prepareDataset()
+	tr2.scrubPii(pii)
+
+	sm := tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	v, ok := sm.Get(string(Trace2CmdPeerPid))
+	assert.True(t, ok)
+	assert.Equal(t, int64(4242), v.Int())
+
+	tr2.scrubPii(&PiiSettings{})
+	sm = tr2.ToTraces(DetailLevelSummary).ResourceSpans().At(0).ScopeSpans().At(0).Spans().At(0).Attributes()
+	_, ok = sm.Get(string(Trace2CmdPeerPid))
+	assert.False(t, ok)
+}
+
+// //////////////////////////////////////////////////////////////
+
+// Verify that we reload the filter settings when a ruleset file
+// changes and keep the previous settings when it is broken.
+func Test_SettingsReload_FilterSettings(t *testing.T) {
+	dir := t.TempDir()
+	fsPath := filepath.Join(dir, "filter.yml")
+	rsPath := filepath.Join(dir, "rs.yml")
+
+	write := func(path string, yml string, mtime time.Time) {
+		assert.Nil(t, os.WriteFile(path, []byte(yml), 0644))
+		assert.Nil(t, os.Chtimes(path, mtime, mtime))
+	}
+
+	t0 := time.Now().Add(-time.Hour)
+	write(rsPath, "defaults:\n  detail: \"dl:process\"\n", t0)
+	write(fsPath, fmt.Sprintf("rulesets:\n  \"rs:a\": \"%s\"\ndefaults:\n  ruleset: \"rs:a\"\n", rsPath), t0)
+
+	cfg := &Config{FilterSettingsPath: fsPath}
+	fs, err := parseFilterSettings(fsPath)
+	assert.Nil(t, err)
+	cfg.setFilterSettings(fs)
+
+	sr := newSettingsReloader(cfg, zap.NewNop())
+	assert.False(t, sr.reloadIfChanged())
+
+	dl, _ := computeDetailLevel(cfg.getFilterSettings(), nil, x_qn)
+	assert.Equal(t, DetailLevelProcess, dl)
+
+	write(rsPath, "defaults:\n  detail: \"dl:verbose\"\n", t0.Add(time.Minute))
+	assert.True(t, sr.reloadIfChanged())
+
+	dl, _ = computeDetailLevel(cfg.getFilterSettings(), nil, x_qn)
+	assert.Equal(t, DetailLevelVerbose, dl)
+
+	write(rsPath, "defaults:\n  detail: \"dl:bogus\"\n", t0.Add(2*time.Minute))
+	assert.False(t, sr.reloadIfChanged())
+
+	dl, _ = computeDetailLevel(cfg.getFilterSettings(), nil, x_qn)
+	assert.Equal(t, DetailLevelVerbose, dl)
+	assert.False(t, sr.reloadIfChanged())
+
+	// Switch to a new ruleset file that is broken, then fix it.  We
+	// should notice the fix without touching the filter settings file.
+	rs2Path := filepath.Join(dir, "rs2.yml")
+	write(rs2Path, "defaults:\n  detail: \"dl:bogus\"\n", t0.Add(3*time.Minute))
+	write(fsPath, fmt.Sprintf("rulesets:\n  \"rs:b\": \"%s\"\ndefaults:\n  ruleset: \"rs:b\"\n", rs2Path), t0.Add(3*time.Minute))
+	assert.False(t, sr.reloadIfChanged())
+	assert.False(t, sr.reloadIfChanged())
+
+	write(rs2Path, "defaults:\n  detail: \"dl:summary\"\n", t0.Add(4*time.Minute))
+	assert.True(t, sr.reloadIfChanged())
+
+	dl, _ = computeDetailLevel(cfg.getFilterSettings(), nil, x_qn)
+	assert.Equal(t, DetailLevelSummary, dl)
+}
+
+// //////////////////////////////////////////////////////////////
+
+// Verify that the config file validator infers the kind of each file
+// and reports all of the problems that it finds.
+func Test_ValidateConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, yml string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte(yml), 0644))
+		return path
+	}
+
+	rsGood := write("good.yml", "commands:\n  \"git:status\": \"dl:verbose\"\ndefaults:\n  detail: \"dl:process\"\n")
+	rsBad := write("bad.yml", "commands:\n  \"git:status\": \"dl:bogus\"\n")
+	piiGood := write("pii.yml", "include:\n  hostname: true\n")
+	fsGood := write("filter.yml", fmt.Sprintf("rulesets:\n  \"rs:good\": \"%s\"\ndefaults:\n  ruleset: \"rs:good\"\n", rsGood))
+
+	assert.Empty(t, ValidateConfigFiles(fsGood, rsGood, piiGood))
+
+	fsBad := write("filter-bad.yml", fmt.Sprintf(
+		"rulesets:\n  \"rs:bad\": \"%s\"\n  \"rs:missing\": \"%s\"\nrejct_verbs:\n  - \"daemon\"\n",
+		rsBad, filepath.Join(dir, "missing.yml")))
+
+	errs := ValidateConfigFiles(fsBad, rsBad)
+	assert.Equal(t, 3, len(errs))
+	assert.Contains(t, errs[0].Error(), "unknown key 'rejct_verbs'")
+	assert.Contains(t, errs[1].Error(), "dl:bogus")
+	assert.Contains(t, errs[2].Error(), "missing.yml")
+
+	dup := write("dup.yml", "include:\n  hostname: true\ninclude:\n  username: true\n")
+	errs = ValidateConfigFiles(dup)
+	assert.Equal(t, 1, len(errs))
+
+	unknown := write("unknown.yml", "foo: bar\n")
+	errs = ValidateConfigFiles(unknown, filepath.Join(dir, "nope.yml"))
+	assert.Equal(t, 2, len(errs))
+}
@@ -2,6 +2,9 @@ package trace2receiver
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 )
 
 // FilterDetailLevel describes the amount of detail in the output
@@ -14,6 +17,7 @@ const (
 	DetailLevelSummary
 	DetailLevelProcess
 	DetailLevelVerbose
+	DetailLevelRaw
 )
 
 // All detail level names have leading "dl:" to help avoid
@@ -23,8 +27,13 @@ const (
 	DetailLevelSummaryName string = "dl:summary"
 	DetailLevelProcessName string = "dl:process"
 	DetailLevelVerboseName string = "dl:verbose"
+	DetailLevelRawName     string = "dl:raw"
 
 	DetailLevelDefaultName string = DetailLevelSummaryName
+
+	// A sampling directive, such as "dl:sample:10", emits roughly
+	// 1 in N commands at `dl:summary` and drops the rest.
+	DetailLevelSamplePrefix string = "dl:sample:"
 )
 
 // Convert a detail level name into a detail level id.
@@ -38,29 +47,99 @@ func getDetailLevel(dl_name string) (FilterDetailLevel, error) {
 		return DetailLevelProcess, nil
 	case DetailLevelVerboseName:
 		return DetailLevelVerbose, nil
+	case DetailLevelRawName:
+		return DetailLevelRaw, nil
 	default:
 		return DetailLevelUnset, errors.New("invalid detail level")
 	}
 }
 
+// Convert a detail level name or a sampling directive into a detail
+// level id and a sample rate.  The sample rate is zero if the name is
+// not a sampling directive.
+func parseDetailLevel(dl_name string) (FilterDetailLevel, int, error) {
+	n_str, found := strings.CutPrefix(dl_name, DetailLevelSamplePrefix)
+	if !found {
+		dl, err := getDetailLevel(dl_name)
+		return dl, 0, err
+	}
+
+	n, err := strconv.Atoi(n_str)
+	if err != nil || n < 1 {
+		return DetailLevelUnset, 0, errors.New("invalid sample rate")
+	}
+
+	return DetailLevelSummary, n, nil
+}
+
+// Convert a detail level id and sample rate back into a name.
+func detailLevelName(dl FilterDetailLevel, rate int) string {
+	if rate > 0 {
+		return fmt.Sprintf("%s%d", DetailLevelSamplePrefix, rate)
+	}
+
+	switch dl {
+	case DetailLevelDrop:
+		return DetailLevelDropName
+	case DetailLevelSummary:
+		return DetailLevelSummaryName
+	case DetailLevelProcess:
+		return DetailLevelProcessName
+	case DetailLevelVerbose:
+		return DetailLevelVerboseName
+	case DetailLevelRaw:
+		return DetailLevelRawName
+	default:
+		return "dl:unset"
+	}
+}
+
+// Is this a sampling directive (with a possibly invalid rate)?
+func isSampleDetailLevelName(dl_name string) bool {
+	return strings.HasPrefix(dl_name, DetailLevelSamplePrefix)
+}
+
 func WantRegionAndThreadSpans(dl FilterDetailLevel) bool {
-	return dl == DetailLevelVerbose
+	return dl == DetailLevelVerbose || dl == DetailLevelRaw
 }
 
 func WantChildSpans(dl FilterDetailLevel) bool {
-	return dl == DetailLevelProcess || dl == DetailLevelVerbose
+	return dl == DetailLevelProcess || dl == DetailLevelVerbose || dl == DetailLevelRaw
 }
 
 func WantProcessAncestry(dl FilterDetailLevel) bool {
-	return dl == DetailLevelProcess || dl == DetailLevelVerbose
+	return dl == DetailLevelProcess || dl == DetailLevelVerbose || dl == DetailLevelRaw
 }
 
 func WantProcessAliases(dl FilterDetailLevel) bool {
-	return dl == DetailLevelProcess || dl == DetailLevelVerbose
+	return dl == DetailLevelProcess || dl == DetailLevelVerbose || dl == DetailLevelRaw
 }
 
 func WantProcessTimersCountersAndData(dl FilterDetailLevel) bool {
-	return dl == DetailLevelProcess || dl == DetailLevelVerbose
+	return dl == DetailLevelProcess || dl == DetailLevelVerbose || dl == DetailLevelRaw
+}
+
+func WantProcessExitEvents(dl FilterDetailLevel) bool {
+	return dl == DetailLevelVerbose || dl == DetailLevelRaw
+}
+
+func WantRegionSourceLocation(dl FilterDetailLevel) bool {
+	return dl == DetailLevelVerbose || dl == DetailLevelRaw
+}
+
+func WantDatasetEventTimes(dl FilterDetailLevel) bool {
+	return dl == DetailLevelVerbose || dl == DetailLevelRaw
+}
+
+func WantParamScopes(dl FilterDetailLevel) bool {
+	return dl == DetailLevelVerbose || dl == DetailLevelRaw
+}
+
+// At `dl:raw` we emit everything and ignore the config settings
+// that trim or omit spans and attributes, such as `min_region_ms`,
+// `min_child_ms`, `max_display_name_len`, and `max_attribute_bytes`.
+func WantUnabridgedOutput(dl FilterDetailLevel) bool {
+	return dl == DetailLevelRaw
 }
 
 func WantMainThreadTimersAndCounters(dl FilterDetailLevel) bool {
@@ -0,0 +1,67 @@
+package trace2receiver
+
+import (
+	"strings"
+)
+
+// FSMonitorIndicators describes how we decide whether a Git command
+// used a filesystem monitor (as opposed to the fsmonitor daemon
+// itself, which we reject).  Different fsmonitor implementations
+// (the builtin daemon, a hook script, Watchman, etc.) are reported
+// differently in the Trace2 data stream, so these are configurable.
+type FSMonitorIndicators struct {
+	// If the command entered a region with one of these categories,
+	// it talked to the filesystem monitor.
+	RegionCategories []string `mapstructure:"region_categories"`
+
+	// If the command sent a `def_param` for one of these Git config
+	// keys with a non-false value, it is configured to use the
+	// filesystem monitor.  (The key must be included in the
+	// `trace2.configparams` config setting for Git to send it.)
+	Params []string `mapstructure:"params"`
+}
+
+// The builtin indicators used when none are configured.  Git's
+// builtin fsmonitor client uses the "fsm_client" region category
+// and `fsmonitor.c` uses the "fsmonitor" region category.
+var defaultFSMonitorIndicators FSMonitorIndicators = FSMonitorIndicators{
+	RegionCategories: []string{"fsmonitor", "fsm_client"},
+	Params:           []string{"core.fsmonitor"},
+}
+
+// Is this Git config value a "false" boolean?
+func isConfigValueFalse(value string) bool {
+	switch strings.ToLower(value) {
+	case "", "false", "no", "off", "0":
+		return true
+	default:
+		return false
+	}
+}
+
+// Decide whether the command used the filesystem monitor.
+func (tr2 *trace2Dataset) computeUsedFSMonitor(ind *FSMonitorIndicators) bool {
+	if ind == nil || (len(ind.RegionCategories) == 0 && len(ind.Params) == 0) {
+		ind = &defaultFSMonitorIndicators
+	}
+
+	for _, p := range ind.Params {
+		if v, ok := tr2.process.paramSetValues[p]; ok && !isConfigValueFalse(v) {
+			return true
+		}
+	}
+
+	if len(ind.RegionCategories) == 0 {
+		return false
+	}
+
+	for _, r := range tr2.completedRegions {
+		for _, c := range ind.RegionCategories {
+			if r.category == c {
+				return true
+			}
+		}
+	}
+
+	return false
+}
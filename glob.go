@@ -0,0 +1,32 @@
+package trace2receiver
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compile a simple glob pattern into a regular expression.
+//
+// A `*` matches any sequence of characters (including none) and a
+// `?` matches any single character.  Unlike `path.Match()`, a `*`
+// will also match `/` and `.` characters since the strings that we
+// match (such as Git config keys) are not pathnames.  The pattern
+// must match the entire string.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+
+	sb.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+
+	return regexp.Compile(sb.String())
+}
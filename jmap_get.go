@@ -71,6 +71,32 @@ func (jm *jmap) getOptionalInt64(key string) (*int64, error) {
 	}
 }
 
+// Get an optional float value from the map.
+func (jm *jmap) getOptionalFloat64(key string) (*float64, error) {
+	if _, ok := (*jm)[key]; !ok {
+		return nil, nil
+	}
+
+	f, err := jm.getRequiredFloat64(key)
+	if err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Get an optional time value from the map.
+func (jm *jmap) getOptionalTime(key string) (*time.Time, error) {
+	if _, ok := (*jm)[key]; !ok {
+		return nil, nil
+	}
+
+	t, err := jm.getRequiredTime(key)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
 // Required keys/value pairs return the value or an hard error if
 // the key is not present or the map value is of a different type
 // than requested.
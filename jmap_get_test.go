@@ -84,6 +84,32 @@ func Test_getOptionalInt64_WrongType(t *testing.T) {
 	}
 }
 
+func Test_getOptionalFloat64_Present(t *testing.T) {
+	pf, err := jm.getOptionalFloat64("required-float")
+	if err != nil || pf == nil || math.Abs(*pf-3.14) > 0.0001 {
+		t.Fatalf("getOptionalFloat64")
+	}
+}
+func Test_getOptionalFloat64_NotPresent(t *testing.T) {
+	pf, err := jm.getOptionalFloat64("not-present-float")
+	if err != nil || pf != nil {
+		t.Fatalf("getOptionalFloat64")
+	}
+}
+
+func Test_getOptionalTime_Present(t *testing.T) {
+	pt, err := jm.getOptionalTime("required-time")
+	if err != nil || pt == nil || pt.Year() != 2023 {
+		t.Fatalf("getOptionalTime")
+	}
+}
+func Test_getOptionalTime_NotPresent(t *testing.T) {
+	pt, err := jm.getOptionalTime("not-present-time")
+	if err != nil || pt != nil {
+		t.Fatalf("getOptionalTime")
+	}
+}
+
 // Required getter functions
 
 func Test_getRequiredString_Present(t *testing.T) {
@@ -3,6 +3,7 @@ package trace2receiver
 import (
 	"fmt"
 	"os"
+	"reflect"
 
 	"github.com/mitchellh/mapstructure"
 	"gopkg.in/yaml.v2"
@@ -14,6 +15,28 @@ type MyYmlFileTypes interface {
 
 type MyYmlParseBufferFn[T MyYmlFileTypes] func(data []byte, path string) (*T, error)
 
+// Expand `$VAR` and `${VAR}` environment variable references in the
+// pathname of a YML file.  An unset variable is an error (rather than
+// silently expanding to an empty string and giving a confusing "file
+// not found" error later).
+func expandPathname(path string) (string, error) {
+	var missing []string
+	expanded := os.Expand(path, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("pathname '%s' refers to unset environment variable '%s'",
+			path, missing[0])
+	}
+
+	return expanded, nil
+}
+
 func parseYmlFile[T MyYmlFileTypes](path string, fnPB MyYmlParseBufferFn[T]) (*T, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -33,7 +56,14 @@ func parseYmlBuffer[T MyYmlFileTypes](data []byte, path string) (*T, error) {
 	}
 
 	p := new(T)
-	err = mapstructure.Decode(m, p)
+	dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: stringToKeynameListHook,
+		Result:     p,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = dec.Decode(m)
 	if err != nil {
 		return nil, fmt.Errorf("could not decode '%s': '%s'",
 			path, err.Error())
@@ -41,3 +71,16 @@ func parseYmlBuffer[T MyYmlFileTypes](data []byte, path string) (*T, error) {
 
 	return p, nil
 }
+
+// Allow a `FilterKeynameList` to be written as a single string
+// in the YML.
+func stringToKeynameListHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() == reflect.String && to == reflect.TypeOf(FilterKeynameList{}) {
+		s := data.(string)
+		if len(s) == 0 {
+			return FilterKeynameList{}, nil
+		}
+		return FilterKeynameList{s}, nil
+	}
+	return data, nil
+}
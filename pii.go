@@ -1,9 +1,44 @@
 package trace2receiver
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+)
+
 // Settings to enable/disable possibly GDPR-sensitive fields
 // in the telemetry output.
 type PiiSettings struct {
 	Include PiiInclude `mapstructure:"include"`
+
+	// The salt to prepend to the hostname and username before
+	// hashing them for `HostnameHash` and `UsernameHash`.
+	HashSalt string `mapstructure:"hash_salt"`
+
+	Scrub PiiScrub `mapstructure:"scrub"`
+
+	// The compiled `Scrub.WorktreePaths` rules.
+	worktreeRules []*piiScrubRule
+}
+
+// Settings to redact sensitive data found within the Trace2 data stream.
+type PiiScrub struct {
+	// Rules to rewrite the worktree pathnames in the `trace2.repo.set`
+	// attribute.  These may contain usernames or project names.
+	WorktreePaths []PiiScrubRule `mapstructure:"worktree_paths"`
+}
+
+// A regular expression and the replacement for the matching text.
+// The replacement may refer to submatches using `$1` or `${name}`.
+type PiiScrubRule struct {
+	Pattern     string `mapstructure:"pattern"`
+	Replacement string `mapstructure:"replacement"`
+}
+
+type piiScrubRule struct {
+	re          *regexp.Regexp
+	replacement string
 }
 
 type PiiInclude struct {
@@ -12,6 +47,18 @@ type PiiInclude struct {
 
 	// Lookup the client username and add to process span.
 	Username bool `mapstructure:"username"`
+
+	// Add a salted SHA-256 hash of the hostname or username to
+	// the process span rather than the actual value.  This gives
+	// us stable values for grouping without revealing them.
+	// These are mutually exclusive with `Hostname` and `Username`.
+	HostnameHash bool `mapstructure:"hostname_hash"`
+	UsernameHash bool `mapstructure:"username_hash"`
+
+	// Lookup the OS-reported PID of the client process and add it
+	// to the process span.  This is best-effort and only available
+	// on Unix domain socket connections on Linux and macOS.
+	PeerPid bool `mapstructure:"peer_pid"`
 }
 
 func parsePiiFile(path string) (*PiiSettings, error) {
@@ -24,7 +71,130 @@ func parsePiiFromBuffer(data []byte, path string) (*PiiSettings, error) {
 		return nil, err
 	}
 
-	// TODO insert any post-parse validation or data structure setup here.
+	err = pii.validate()
+	if err != nil {
+		return nil, fmt.Errorf("pii settings '%s' has invalid include: '%s'",
+			path, err.Error())
+	}
 
 	return pii, nil
 }
+
+// Validate the PII settings and compile the scrub rules after parsing.
+// This is also used for the `pii` section in a custom ruleset.
+func (pii *PiiSettings) validate() error {
+	if pii.Include.Hostname && pii.Include.HostnameHash {
+		return fmt.Errorf("cannot include both hostname and hostname_hash")
+	}
+	if pii.Include.Username && pii.Include.UsernameHash {
+		return fmt.Errorf("cannot include both username and username_hash")
+	}
+
+	pii.worktreeRules = nil
+	for _, r := range pii.Scrub.WorktreePaths {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid worktree_paths pattern '%s': %s",
+				r.Pattern, err.Error())
+		}
+		pii.worktreeRules = append(pii.worktreeRules,
+			&piiScrubRule{re: re, replacement: r.Replacement})
+	}
+
+	return nil
+}
+
+// Apply each of the scrub rules (in order) to the pathname.
+// Pathnames that do not match any rule are unchanged.
+func scrubWorktreePath(path string, rules []*piiScrubRule) string {
+	for _, r := range rules {
+		path = r.re.ReplaceAllString(path, r.replacement)
+	}
+	return path
+}
+
+// Return the union of the global PII settings and any PII overrides
+// in the custom rulesets.  We don't know which ruleset will be used
+// for a dataset until after we have received the data stream, but
+// some PII (such as the client username) can only be gathered when
+// the client connects.  So we gather everything that might be needed
+// and then scrub the unwanted fields after the ruleset is resolved.
+//
+// We must gather the raw value if we need to hash it, so the hash
+// flags are folded into `Hostname` and `Username` here.
+func (cfg *Config) piiGatherInclude() PiiInclude {
+	var inc PiiInclude
+
+	fold := func(pi *PiiInclude) {
+		inc.Hostname = inc.Hostname || pi.Hostname || pi.HostnameHash
+		inc.Username = inc.Username || pi.Username || pi.UsernameHash
+		inc.PeerPid = inc.PeerPid || pi.PeerPid
+	}
+
+	if cfg.piiSettings != nil {
+		fold(&cfg.piiSettings.Include)
+	}
+
+	if fs := cfg.getFilterSettings(); fs != nil {
+		for _, rsdef := range fs.rulesetDefs {
+			if rsdef.Pii != nil {
+				fold(&rsdef.Pii.Include)
+			}
+		}
+	}
+
+	return inc
+}
+
+// Return the PII settings for a dataset.  If the dataset resolved to a
+// custom ruleset that has its own PII settings, they override the
+// global settings.  Either may be nil.
+func (rsdef *RulesetDefinition) piiSettings(global *PiiSettings) *PiiSettings {
+	if rsdef == nil || rsdef.Pii == nil {
+		return global
+	}
+
+	return rsdef.Pii
+}
+
+// Remove any gathered PII fields that are not allowed by the resolved
+// PII settings.  Replace them with their hashes if requested.  Remember
+// the worktree scrub rules for when we emit the repo set.
+func (tr2 *trace2Dataset) scrubPii(pii *PiiSettings) {
+	if pii != nil {
+		tr2.worktreeScrubRules = pii.worktreeRules
+	}
+
+	if pii != nil && pii.Include.HostnameHash {
+		tr2.hashPii(string(Trace2PiiHostname), string(Trace2PiiHostnameHash), pii.HashSalt)
+	}
+	if pii != nil && pii.Include.UsernameHash {
+		tr2.hashPii(string(Trace2PiiUsername), string(Trace2PiiUsernameHash), pii.HashSalt)
+	}
+
+	if pii == nil || !pii.Include.Hostname {
+		delete(tr2.pii, string(Trace2PiiHostname))
+	}
+	if pii == nil || !pii.Include.Username {
+		delete(tr2.pii, string(Trace2PiiUsername))
+	}
+	if pii == nil || !pii.Include.PeerPid {
+		tr2.peerPid = 0
+	}
+}
+
+// Add the salted hash of a gathered PII field (if present).
+func (tr2 *trace2Dataset) hashPii(key string, hashKey string, salt string) {
+	v, ok := tr2.pii[key]
+	if !ok {
+		return
+	}
+
+	tr2.pii[hashKey] = hashPiiValue(v, salt)
+}
+
+// Compute the hex SHA-256 of the salt followed by the value.
+func hashPiiValue(v string, salt string) string {
+	h := sha256.Sum256([]byte(salt + v))
+	return hex.EncodeToString(h[:])
+}
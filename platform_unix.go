@@ -8,10 +8,12 @@ import (
 	"errors"
 	"net"
 	"os"
+	"syscall"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/receiver"
+	"golang.org/x/sys/unix"
 )
 
 var (
@@ -27,33 +29,104 @@ func createTraces(_ context.Context,
 		return nil, errNilNextConsumer
 	}
 
-	trace2Cfg := baseCfg.(*Config)
+	sr := getPlatformReceiver(params, baseCfg.(*Config))
+	sr.base.TracesConsumer = consumer
+	return sr, nil
+}
+
+func createMetrics(_ context.Context,
+	params receiver.Settings,
+	baseCfg component.Config,
+	consumer consumer.Metrics) (receiver.Metrics, error) {
+
+	if consumer == nil {
+		return nil, errNilNextConsumer
+	}
+
+	sr := getPlatformReceiver(params, baseCfg.(*Config))
+	sr.base.MetricsConsumer = consumer
+	return sr, nil
+}
 
-	rcvr := &Rcvr_UnixSocket{
-		Base: &Rcvr_Base{
-			Settings:       params,
-			Logger:         params.Logger,
-			TracesConsumer: consumer,
-			RcvrConfig:     trace2Cfg,
-		},
-		SocketPath: trace2Cfg.UnixSocketPath,
+func createLogs(_ context.Context,
+	params receiver.Settings,
+	baseCfg component.Config,
+	consumer consumer.Logs) (receiver.Logs, error) {
+
+	if consumer == nil {
+		return nil, errNilNextConsumer
 	}
-	return rcvr, nil
+
+	sr := getPlatformReceiver(params, baseCfg.(*Config))
+	sr.base.LogsConsumer = consumer
+	return sr, nil
+}
+
+// Create (or lookup) the receiver that listens for this config.
+func getPlatformReceiver(params receiver.Settings, trace2Cfg *Config) *sharedReceiver {
+	return getSharedReceiver(trace2Cfg, func() (component.Component, *Rcvr_Base) {
+		if len(trace2Cfg.TCPListen) > 0 {
+			rcvr := &Rcvr_TCP{
+				Base: &Rcvr_Base{
+					Settings:   params,
+					Logger:     params.Logger,
+					RcvrConfig: trace2Cfg,
+				},
+				TCPListen: trace2Cfg.TCPListen,
+			}
+			return rcvr, rcvr.Base
+		}
+
+		rcvr := &Rcvr_UnixSocket{
+			Base: &Rcvr_Base{
+				Settings:   params,
+				Logger:     params.Logger,
+				RcvrConfig: trace2Cfg,
+			},
+			SocketPath:    trace2Cfg.UnixSocketPath,
+			SocketNetwork: trace2Cfg.unixSocketNetwork,
+		}
+		return rcvr, rcvr.Base
+	})
 }
 
 // Gather up any requested PII from the machine or
 // possibly the connection from the client process.
-// Add any requested PII data to `tr2.pii[]`.
-func (tr2 *trace2Dataset) pii_gather(cfg *Config, conn *net.UnixConn) {
-	if cfg.piiSettings != nil && cfg.piiSettings.Include.Hostname {
+// Add any requested PII data to `tr2.pii[]`.  This may include
+// fields that are later removed by `scrubPii()`.
+//
+// We can only get the peer credentials on a Unix domain socket.
+// On a TCP connection, the username and PID are not available.  Nor
+// is the hostname, since the client is probably not on our machine
+// (and our hostname would be misleading).
+func (tr2 *trace2Dataset) pii_gather(cfg *Config, conn net.Conn) {
+	inc := cfg.piiGatherInclude()
+
+	if _, isTCP := conn.(*net.TCPConn); inc.Hostname && !isTCP {
 		if h, err := os.Hostname(); err == nil {
 			tr2.pii[string(Trace2PiiHostname)] = h
 		}
 	}
 
-	if cfg.piiSettings != nil && cfg.piiSettings.Include.Username {
-		if u, err := getPeerUsername(conn); err == nil {
+	if uconn, ok := conn.(*net.UnixConn); ok && inc.Username {
+		if u, err := getPeerUsername(uconn); err == nil {
 			tr2.pii[string(Trace2PiiUsername)] = u
 		}
 	}
+
+	if uconn, ok := conn.(*net.UnixConn); ok && inc.PeerPid {
+		if pid, err := getPeerPid(uconn); err == nil && pid > 0 {
+			tr2.peerPid = pid
+		}
+	}
+}
+
+// Map a signal number to its symbolic name, such as "SIGPIPE".  Signal
+// numbers vary between Unix platforms, so use the table for the current
+// platform.  (The client is always on the same machine as us.)
+func signalName(signo int64) string {
+	if name := unix.SignalName(syscall.Signal(signo)); len(name) > 0 {
+		return name
+	}
+	return unknownSignalName
 }
@@ -6,6 +6,7 @@ package trace2receiver
 import (
 	"context"
 	"errors"
+	"net"
 	"os"
 	"os/user"
 
@@ -27,31 +28,88 @@ func createTraces(_ context.Context,
 		return nil, errNilNextConsumer
 	}
 
-	trace2Cfg := baseCfg.(*Config)
+	sr := getPlatformReceiver(params, baseCfg.(*Config))
+	sr.base.TracesConsumer = consumer
+	return sr, nil
+}
+
+func createMetrics(_ context.Context,
+	params receiver.Settings,
+	baseCfg component.Config,
+	consumer consumer.Metrics) (receiver.Metrics, error) {
+
+	if consumer == nil {
+		return nil, errNilNextConsumer
+	}
 
-	rcvr := &Rcvr_NamedPipe{
-		Base: &Rcvr_Base{
-			Settings:       params,
-			Logger:         params.Logger,
-			TracesConsumer: consumer,
-			RcvrConfig:     trace2Cfg,
-		},
-		NamedPipePath: trace2Cfg.NamedPipePath,
+	sr := getPlatformReceiver(params, baseCfg.(*Config))
+	sr.base.MetricsConsumer = consumer
+	return sr, nil
+}
+
+func createLogs(_ context.Context,
+	params receiver.Settings,
+	baseCfg component.Config,
+	consumer consumer.Logs) (receiver.Logs, error) {
+
+	if consumer == nil {
+		return nil, errNilNextConsumer
 	}
-	return rcvr, nil
+
+	sr := getPlatformReceiver(params, baseCfg.(*Config))
+	sr.base.LogsConsumer = consumer
+	return sr, nil
+}
+
+// Create (or lookup) the receiver that listens for this config.
+func getPlatformReceiver(params receiver.Settings, trace2Cfg *Config) *sharedReceiver {
+	return getSharedReceiver(trace2Cfg, func() (component.Component, *Rcvr_Base) {
+		if len(trace2Cfg.TCPListen) > 0 {
+			rcvr := &Rcvr_TCP{
+				Base: &Rcvr_Base{
+					Settings:   params,
+					Logger:     params.Logger,
+					RcvrConfig: trace2Cfg,
+				},
+				TCPListen: trace2Cfg.TCPListen,
+			}
+			return rcvr, rcvr.Base
+		}
+
+		rcvr := &Rcvr_NamedPipe{
+			Base: &Rcvr_Base{
+				Settings:   params,
+				Logger:     params.Logger,
+				RcvrConfig: trace2Cfg,
+			},
+			NamedPipePath: trace2Cfg.NamedPipePath,
+		}
+		return rcvr, rcvr.Base
+	})
 }
 
 // Gather up any requested PII from the machine or
 // possibly the connection from the client process.
-// Add any requested PII data to `tr2.pii[]`.
-func (tr2 *trace2Dataset) pii_gather(cfg *Config) {
-	if cfg.piiSettings != nil && cfg.piiSettings.Include.Hostname {
+// Add any requested PII data to `tr2.pii[]`.  This may include
+// fields that are later removed by `scrubPii()`.
+//
+// On a named pipe, the client is on our machine, but on a TCP
+// connection it probably is not, so our hostname and username would
+// be misleading.
+func (tr2 *trace2Dataset) pii_gather(cfg *Config, conn net.Conn) {
+	inc := cfg.piiGatherInclude()
+
+	if _, isTCP := conn.(*net.TCPConn); isTCP {
+		return
+	}
+
+	if inc.Hostname {
 		if h, err := os.Hostname(); err == nil {
 			tr2.pii[string(Trace2PiiHostname)] = h
 		}
 	}
 
-	if cfg.piiSettings != nil && cfg.piiSettings.Include.Username {
+	if inc.Username {
 		// TODO For now, just lookup the current user.  This may
 		// or may not be valid when the service is officially
 		// installed.  Ideally we should get the user-id of the
@@ -63,4 +121,34 @@ func (tr2 *trace2Dataset) pii_gather(cfg *Config) {
 			tr2.pii[string(Trace2PiiUsername)] = u.Username
 		}
 	}
+
+	// TODO The PID of the client process (`inc.PeerPid`) is not
+	// available on a named pipe connection yet.
+}
+
+// The signal numbers used by Git for Windows.  These are a mix of the
+// MSVC CRT signals and the POSIX signals emulated by the MinGW compat
+// layer.
+var windowsSignalNames = map[int64]string{
+	1:  "SIGHUP",
+	2:  "SIGINT",
+	3:  "SIGQUIT",
+	4:  "SIGILL",
+	8:  "SIGFPE",
+	9:  "SIGKILL",
+	11: "SIGSEGV",
+	13: "SIGPIPE",
+	14: "SIGALRM",
+	15: "SIGTERM",
+	17: "SIGCHLD",
+	21: "SIGBREAK",
+	22: "SIGABRT",
+}
+
+// Map a signal number to its symbolic name, such as "SIGPIPE".
+func signalName(signo int64) string {
+	if name, ok := windowsSignalNames[signo]; ok {
+		return name
+	}
+	return unknownSignalName
 }
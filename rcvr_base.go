@@ -1,7 +1,14 @@
 package trace2receiver
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
@@ -23,18 +30,47 @@ type Rcvr_Base struct {
 	ctx    context.Context
 	host   component.Host
 	cancel context.CancelFunc
+
+	// The time that the receiver was started.
+	startTime time.Time
+
+	// Counters describing the datasets that we have handled.
+	stats rcvrStats
+
+	// A semaphore limiting the number of connections that we process
+	// at the same time.  Nil if there is no limit.
+	connSlots chan struct{}
 }
 
 // `Start()` handles base-class portions of receiver initialization.
 func (rcvr_base *Rcvr_Base) Start(unused_ctx context.Context, host component.Host) error {
 	rcvr_base.host = host
+	rcvr_base.startTime = time.Now()
 	rcvr_base.ctx = context.Background()
 	rcvr_base.ctx, rcvr_base.cancel = context.WithCancel(rcvr_base.ctx)
 
+	if n := rcvr_base.RcvrConfig.MaxConcurrentConnections; n > 0 {
+		rcvr_base.connSlots = make(chan struct{}, n)
+	}
+
+	if mp := rcvr_base.statsMeterProvider(); mp != nil {
+		if err := rcvr_base.stats.register(mp); err != nil {
+			rcvr_base.Logger.Warn(fmt.Sprintf("could not register receiver metrics: %v", err))
+		}
+	}
+
+	if rcvr_base.RcvrConfig.SettingsReloadInterval > 0 && len(rcvr_base.RcvrConfig.FilterSettingsPath) > 0 {
+		go rcvr_base.settingsReloadLoop()
+	}
+
 	if rcvr_base.RcvrConfig.AllowCommandControlVerbs {
 		rcvr_base.Logger.Info("Command verbs are enabled")
 	}
 
+	for _, w := range rcvr_base.RcvrConfig.getFilterSettings().commandKeyWarnings() {
+		rcvr_base.Logger.Warn(w)
+	}
+
 	if rcvr_base.RcvrConfig.piiSettings != nil {
 		if rcvr_base.RcvrConfig.piiSettings.Include.Hostname {
 			rcvr_base.Logger.Info("PII: Hostname logging is enabled")
@@ -45,3 +81,92 @@ func (rcvr_base *Rcvr_Base) Start(unused_ctx context.Context, host component.Hos
 	}
 	return nil
 }
+
+// Compute the deadline for the next read on a client connection that
+// was accepted at `connStart` using the `read_idle_timeout` and
+// `max_connection_duration` config settings.  Returns the zero time
+// if there is no deadline.
+func (rcvr_base *Rcvr_Base) nextReadDeadline(connStart time.Time) time.Time {
+	var deadline time.Time
+
+	if rcvr_base.RcvrConfig.ReadIdleTimeout > 0 {
+		deadline = time.Now().Add(rcvr_base.RcvrConfig.ReadIdleTimeout)
+	}
+
+	if rcvr_base.RcvrConfig.MaxConnectionDuration > 0 {
+		end := connStart.Add(rcvr_base.RcvrConfig.MaxConnectionDuration)
+		if deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+
+	return deadline
+}
+
+// Did a read fail because the read deadline passed?
+func isReadTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// Wait for (or try to get) one of the `max_concurrent_connections`
+// slots before processing a client connection.  Returns false if the
+// caller should close the connection without reading it, either because
+// we are saturated and the policy is "reject" or because the receiver
+// is shutting down.  If this returns true, the caller must call
+// `releaseConnection()` when finished with it.
+func (rcvr_base *Rcvr_Base) acquireConnection() bool {
+	if rcvr_base.connSlots != nil {
+		if rcvr_base.RcvrConfig.connectionLimitPolicy == connectionLimitReject {
+			select {
+			case rcvr_base.connSlots <- struct{}{}:
+			default:
+				rcvr_base.stats.inc(rcvrStatConnectionsRejected)
+				return false
+			}
+		} else {
+			select {
+			case rcvr_base.connSlots <- struct{}{}:
+			case <-rcvr_base.ctx.Done():
+				return false
+			}
+		}
+	}
+
+	rcvr_base.stats.inFlight.Add(1)
+	return true
+}
+
+// Give back the connection slot acquired by `acquireConnection()`.
+func (rcvr_base *Rcvr_Base) releaseConnection() {
+	rcvr_base.stats.inFlight.Add(-1)
+
+	if rcvr_base.connSlots != nil {
+		<-rcvr_base.connSlots
+	}
+}
+
+// The first two bytes of a gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Some clients send the Trace2 data stream through a shim that gzip
+// compresses it.  Peek at the start of the stream and, if it looks
+// compressed, return a reader that decompresses it.  Otherwise (and
+// if we cannot peek because the client hung up or timed out), return
+// the original reader and let the caller see the read error.
+//
+// A Trace2 data stream always starts with a '{' (or a command verb),
+// so it cannot be confused with the gzip magic bytes.
+func maybeDecompressStream(r *bufio.Reader) (*bufio.Reader, error) {
+	magic, err := r.Peek(len(gzipMagic))
+	if err != nil || !bytes.Equal(magic, gzipMagic) {
+		return r, nil
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read compressed stream: %w", err)
+	}
+
+	return bufio.NewReader(gz), nil
+}
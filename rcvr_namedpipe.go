@@ -12,6 +12,7 @@ import (
 	"net"
 	"os"
 	"sync"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/component/componentstatus"
@@ -245,6 +246,13 @@ func (rcvr *Rcvr_NamedPipe) worker(conn net.Conn, acceptId int, workerId uint64)
 	var wg sync.WaitGroup
 	defer conn.Close()
 
+	if !rcvr.Base.acquireConnection() {
+		rcvr.Base.Logger.Debug(fmt.Sprintf("worker[%d] closing connection: too many connections",
+			workerId))
+		return
+	}
+	defer rcvr.Base.releaseConnection()
+
 	//rcvr.Base.Logger.Debug(fmt.Sprintf("worker[%d,%d] starting", acceptId, workerId))
 
 	doneReading := make(chan bool, 1)
@@ -284,12 +292,30 @@ func (rcvr *Rcvr_NamedPipe) worker(conn net.Conn, acceptId int, workerId uint64)
 	// Dataset mapping.
 	tr2 := NewTrace2Dataset(rcvr.Base)
 
-	tr2.pii_gather(rcvr.Base.RcvrConfig)
+	tr2.pii_gather(rcvr.Base.RcvrConfig, conn)
 
 	var nrBytesRead int = 0
 
+	connStart := time.Now()
+
 	r := bufio.NewReader(conn)
+	sniffed := false
 	for {
+		if deadline := rcvr.Base.nextReadDeadline(connStart); !deadline.IsZero() {
+			conn.SetReadDeadline(deadline)
+		}
+
+		if !sniffed {
+			// Wait until we have set the read deadline to peek.
+			sniffed = true
+			var err error
+			if r, err = maybeDecompressStream(r); err != nil {
+				rcvr.Base.Logger.Error(err.Error())
+				haveError = true
+				break
+			}
+		}
+
 		rawLine, err := r.ReadBytes('\n')
 		if err == io.EOF {
 			//if nrBytesRead == 0 {
@@ -301,6 +327,14 @@ func (rcvr *Rcvr_NamedPipe) worker(conn net.Conn, acceptId int, workerId uint64)
 		if errors.Is(err, net.ErrClosed) {
 			break
 		}
+		if isReadTimeout(err) {
+			// A stalled or long-running client.  Force close the
+			// connection, but still export the partial data (like
+			// we do when `context.cancelFunc` is called).
+			rcvr.Base.Logger.Debug(fmt.Sprintf("[dsid %06d] closing connection: read timeout",
+				tr2.datasetId))
+			break
+		}
 		if err != nil {
 			rcvr.Base.Logger.Error(err.Error())
 			haveError = true
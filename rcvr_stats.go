@@ -0,0 +1,132 @@
+package trace2receiver
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/collector/config/configtelemetry"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// rcvrStatKind identifies one of the receiver health counters.
+type rcvrStatKind int
+
+const (
+	// A dataset was exported to the traces pipeline.
+	rcvrStatDatasetsExported rcvrStatKind = iota
+
+	// A dataset was dropped because of `dl:drop`, `dl:sample:<n>`,
+	// or `reject_if_param`.
+	rcvrStatDatasetsDropped
+
+	// A dataset was discarded because `prepareDataset()` decided that
+	// we did not have sufficient data.
+	rcvrStatDatasetsInsufficient
+
+	// A client was rejected (such as `git fsmonitor--daemon` or one
+	// of the `reject_verbs`).
+	rcvrStatClientsRejected
+
+	// A line in the data stream could not be parsed.
+	rcvrStatParseErrors
+
+	// A command and control verb was seen in the data stream.
+	rcvrStatCommandVerbs
+
+	// A connection was closed without reading it because we were
+	// already processing `max_concurrent_connections`.
+	rcvrStatConnectionsRejected
+
+	// An event arrived before the "version" event (whether it was
+	// held until the "version" event arrived or discarded).
+	rcvrStatPreVersionEvents
+
+	rcvrStatCount
+)
+
+// The names and descriptions of the counters that we expose through
+// the collector's internal telemetry.
+var rcvrStatDefs = [rcvrStatCount]struct {
+	name        string
+	description string
+}{
+	{"trace2receiver.datasets.exported", "Number of datasets exported"},
+	{"trace2receiver.datasets.dropped", "Number of datasets dropped by the filter settings"},
+	{"trace2receiver.datasets.insufficient", "Number of datasets discarded for insufficient data"},
+	{"trace2receiver.clients.rejected", "Number of clients rejected"},
+	{"trace2receiver.parse_errors", "Number of data stream lines that could not be parsed"},
+	{"trace2receiver.command_verbs", "Number of command and control verbs seen"},
+	{"trace2receiver.connections.rejected", "Number of connections rejected by max_concurrent_connections"},
+	{"trace2receiver.events.before_version", "Number of events received before the version event"},
+}
+
+// rcvrStats counts the decisions that the receiver makes about the
+// datasets that it receives, so that operators can monitor the
+// health of the receiver without scraping the debug logs.
+type rcvrStats struct {
+	counts [rcvrStatCount]atomic.Int64
+
+	// The number of client connections currently being processed.
+	// Unlike the counters, this goes up and down.
+	inFlight atomic.Int64
+}
+
+// Increment a counter.  This is nil-safe so that datasets without a
+// receiver (such as in the tests) do not need to check.
+func (s *rcvrStats) inc(k rcvrStatKind) {
+	if s != nil {
+		s.counts[k].Add(1)
+	}
+}
+
+func (s *rcvrStats) get(k rcvrStatKind) int64 {
+	if s == nil {
+		return 0
+	}
+	return s.counts[k].Load()
+}
+
+// Register an observable counter for each of our counters with the
+// collector's internal telemetry.
+func (s *rcvrStats) register(mp metric.MeterProvider) error {
+	meter := mp.Meter("github.com/git-ecosystem/trace2receiver")
+
+	for k := range rcvrStatDefs {
+		kind := rcvrStatKind(k)
+		_, err := meter.Int64ObservableCounter(rcvrStatDefs[k].name,
+			metric.WithDescription(rcvrStatDefs[k].description),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(s.get(kind))
+				return nil
+			}))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := meter.Int64ObservableGauge("trace2receiver.connections.in_flight",
+		metric.WithDescription("Number of client connections being processed"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(s.inFlight.Load())
+			return nil
+		}))
+	return err
+}
+
+// Return the receiver stats for this dataset (or nil if the dataset
+// is not associated with a receiver).
+func (tr2 *trace2Dataset) stats() *rcvrStats {
+	if tr2.rcvr_base == nil {
+		return nil
+	}
+	return &tr2.rcvr_base.stats
+}
+
+// Get the meter provider for our internal telemetry, if the collector
+// gave us one.
+func (rcvr_base *Rcvr_Base) statsMeterProvider() metric.MeterProvider {
+	if rcvr_base.Settings.TelemetrySettings.LeveledMeterProvider == nil {
+		return nil
+	}
+	return rcvr_base.Settings.TelemetrySettings.LeveledMeterProvider(configtelemetry.LevelBasic)
+}
@@ -0,0 +1,238 @@
+package trace2receiver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+)
+
+// `Rcvr_TCP` implements the `component.TracesReceiver` (aka `component.Receiver`
+// (aka `component.Component`)) interface.
+//
+// Git can only send Trace2 data to a Unix domain socket or a named
+// pipe, so this is intended for use with a small shim (that forwards
+// the data stream from the socket to us), such as when the collector
+// runs in a container and the Git commands run on the host.
+type Rcvr_TCP struct {
+	// These fields should be set in ctor()
+	Base      *Rcvr_Base
+	TCPListen string
+
+	// TCP listener properties
+	listener   *net.TCPListener
+	mutex      sync.Mutex
+	isShutdown bool
+}
+
+// Start receiving connections from Trace2 clients.
+//
+// This is part of the `component.Component` interface.
+func (rcvr *Rcvr_TCP) Start(unused_ctx context.Context, host component.Host) error {
+	var err error
+
+	err = rcvr.Base.Start(unused_ctx, host)
+	if err != nil {
+		return err
+	}
+
+	err = rcvr.openTCPListener()
+	if err != nil {
+		componentstatus.ReportStatus(host, componentstatus.NewFatalErrorEvent(err))
+		return err
+	}
+
+	go rcvr.listenLoop()
+	return nil
+}
+
+// Stop accepting new connections from Trace2 clients.
+//
+// This is part of the `component.Component` interface.
+func (rcvr *Rcvr_TCP) Shutdown(context.Context) error {
+	rcvr.mutex.Lock()
+	rcvr.isShutdown = true
+
+	if rcvr.listener != nil {
+		rcvr.listener.Close()
+	}
+	rcvr.Base.cancel()
+
+	rcvr.mutex.Unlock()
+	return nil
+}
+
+// Open the server-side of a TCP listener.  The address may be an IPv4
+// or IPv6 `<host>:<port>` (for example, `127.0.0.1:9411` or `[::1]:9411`).
+func (rcvr *Rcvr_TCP) openTCPListener() error {
+	addr, err := net.ResolveTCPAddr("tcp", rcvr.TCPListen)
+	if err != nil {
+		rcvr.Base.Logger.Error(fmt.Sprintf("could not resolve tcp address: %v", err))
+		return err
+	}
+
+	rcvr.listener, err = net.ListenTCP("tcp", addr)
+	if err != nil {
+		rcvr.Base.Logger.Error(fmt.Sprintf("could not create tcp listener: %v", err))
+		return err
+	}
+
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		rcvr.Base.Logger.Warn(fmt.Sprintf("tcp listener '%s' accepts unauthenticated connections on all interfaces",
+			rcvr.TCPListen))
+	}
+
+	rcvr.Base.Logger.Info(fmt.Sprintf("listening on tcp '%s'", rcvr.listener.Addr().String()))
+	return nil
+}
+
+// Listen for incoming connections from Trace2 clients.
+// Dispatch each to a worker thread.
+func (rcvr *Rcvr_TCP) listenLoop() {
+	var wg sync.WaitGroup
+	var worker_id uint64
+
+	doneListening := make(chan bool, 1)
+
+	// Create a subordinate thread to watch for `context.cancelFunc`
+	// being called by another thread.  We need to interrupt our
+	// (blocking) call to `AcceptTCP()` in this thread and start
+	// shutting down.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-rcvr.Base.ctx.Done():
+			rcvr.Base.Logger.Info("ctx.Done signalled")
+			rcvr.listener.Close()
+		case <-doneListening:
+		}
+	}()
+
+	for {
+		conn, err := rcvr.listener.AcceptTCP()
+		if err == nil {
+			worker_id++
+			go rcvr.worker(conn, worker_id)
+			continue
+		}
+
+		rcvr.mutex.Lock()
+		if rcvr.isShutdown || errors.Is(err, net.ErrClosed) {
+			// We already know why the accept() failed because we
+			// (or our subordinate thread) closed the listener.
+			rcvr.mutex.Unlock()
+			break
+		}
+		// Normal accept() errors do happen from time to time. Perhaps
+		// the client hung up before we could service this connection.
+		rcvr.Base.Logger.Error(err.Error())
+		rcvr.mutex.Unlock()
+	}
+
+	// Tell the subordinate thread that we are finished accepting
+	// connections so it can go away now.  This must not block.
+	doneListening <- true
+
+	wg.Wait()
+}
+
+func (rcvr *Rcvr_TCP) worker(conn *net.TCPConn, worker_id uint64) {
+	var haveError = false
+	var wg sync.WaitGroup
+	defer conn.Close()
+
+	if !rcvr.Base.acquireConnection() {
+		rcvr.Base.Logger.Debug(fmt.Sprintf("worker[%d] closing connection: too many connections",
+			worker_id))
+		return
+	}
+	defer rcvr.Base.releaseConnection()
+
+	doneReading := make(chan bool, 1)
+
+	// Create a subordinate thread to watch for `context.cancelFunc`
+	// being called by another thread.  We need to interrupt our
+	// (blocking) call to `ReadBytes()` in this worker and (maybe)
+	// let it emit partial results (if it can do so quickly).
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-rcvr.Base.ctx.Done():
+			conn.Close()
+		case <-doneReading:
+		}
+	}()
+
+	// Like the other receivers, we assume that each connection
+	// contains the data stream from a single Git command.
+	tr2 := NewTrace2Dataset(rcvr.Base)
+
+	tr2.pii_gather(rcvr.Base.RcvrConfig, conn)
+
+	connStart := time.Now()
+
+	r := bufio.NewReader(conn)
+	sniffed := false
+	for {
+		if deadline := rcvr.Base.nextReadDeadline(connStart); !deadline.IsZero() {
+			conn.SetReadDeadline(deadline)
+		}
+
+		if !sniffed {
+			// Wait until we have set the read deadline to peek.
+			sniffed = true
+			var err error
+			if r, err = maybeDecompressStream(r); err != nil {
+				rcvr.Base.Logger.Error(err.Error())
+				haveError = true
+				break
+			}
+		}
+
+		rawLine, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if isReadTimeout(err) {
+			rcvr.Base.Logger.Debug(fmt.Sprintf("[dsid %06d] closing connection: read timeout",
+				tr2.datasetId))
+			break
+		}
+		if err != nil {
+			rcvr.Base.Logger.Error(err.Error())
+			haveError = true
+			break
+		}
+
+		if processRawLine(rawLine, tr2, rcvr.Base.Logger,
+			rcvr.Base.RcvrConfig.AllowCommandControlVerbs) != nil {
+			haveError = true
+			break
+		}
+	}
+
+	// Tell the subordinate thread that we are finished reading from
+	// the client so it can go away now.  This must not block.
+	doneReading <- true
+
+	conn.Close()
+
+	if !haveError {
+		tr2.exportTraces()
+	}
+
+	// Wait for our subordinate thread to exit
+	wg.Wait()
+}
@@ -0,0 +1,159 @@
+package trace2receiver
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// Verify that we only allow one type of listener and require an
+// explicit host for the TCP listener.
+func Test_Config_TCPListen(t *testing.T) {
+	var tests = []struct {
+		listen string
+		other  bool
+		valid  bool
+	}{
+		{"127.0.0.1:9411", false, true},
+		{"[::1]:9411", false, true},
+		{"0.0.0.0:9411", false, true},
+		{":9411", false, false},
+		{"127.0.0.1", false, false},
+		{"127.0.0.1:9411", true, false},
+	}
+
+	for _, test := range tests {
+		cfg := createDefaultConfig().(*Config)
+		cfg.TCPListen = test.listen
+		if test.other {
+			if runtime.GOOS == "windows" {
+				cfg.NamedPipePath = "trace2"
+			} else {
+				cfg.UnixSocketPath = "/tmp/trace2.socket"
+			}
+		}
+
+		err := cfg.Validate()
+		assert.Equal(t, test.valid, err == nil, test.listen)
+	}
+}
+
+// Start a TCP receiver on an ephemeral port that passes the traces
+// that it exports to the returned channel.
+func startTestTCPReceiver(t *testing.T) (*Rcvr_TCP, chan ptrace.Traces) {
+	ch := make(chan ptrace.Traces, 10)
+	next, _ := consumer.NewTraces(func(ctx context.Context, td ptrace.Traces) error {
+		ch <- td
+		return nil
+	})
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.TCPListen = "127.0.0.1:0"
+
+	rcvr := &Rcvr_TCP{
+		Base: &Rcvr_Base{
+			Logger:         zap.NewNop(),
+			TracesConsumer: next,
+			RcvrConfig:     cfg,
+		},
+		TCPListen: cfg.TCPListen,
+	}
+
+	// The host is only used to report a fatal error.
+	assert.Nil(t, rcvr.Start(context.Background(), nil))
+	t.Cleanup(func() { rcvr.Shutdown(context.Background()) })
+
+	return rcvr, ch
+}
+
+// Verify that we accept connections and export a trace for the data
+// stream in each of them.
+func Test_TCP_RoundTrip(t *testing.T) {
+	rcvr, ch := startTestTCPReceiver(t)
+
+	events := []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	}
+
+	var wg sync.WaitGroup
+	for k := 0; k < 2; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", rcvr.listener.Addr().String())
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer conn.Close()
+			_, err = conn.Write([]byte(strings.Join(events, "\n") + "\n"))
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	for k := 0; k < 2; k++ {
+		select {
+		case td := <-ch:
+			assert.Equal(t, 1, td.SpanCount())
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for trace %d", k)
+		}
+	}
+	assert.Equal(t, int64(2), rcvr.Base.stats.get(rcvrStatDatasetsExported))
+}
+
+// Verify that we stop accepting connections after shutdown.
+func Test_TCP_Shutdown(t *testing.T) {
+	rcvr, _ := startTestTCPReceiver(t)
+	addr := rcvr.listener.Addr().String()
+
+	assert.Nil(t, rcvr.Shutdown(context.Background()))
+
+	_, err := net.DialTimeout("tcp", addr, time.Second)
+	assert.NotNil(t, err)
+}
+
+// Verify that we do not report our own hostname for a TCP client,
+// since it is probably on another machine.
+func Test_TCP_PiiHostname(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.piiSettings = &PiiSettings{Include: PiiInclude{Hostname: true}}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+
+	client, err := net.Dial("tcp", l.Addr().String())
+	assert.Nil(t, err)
+	defer client.Close()
+
+	server, err := l.Accept()
+	assert.Nil(t, err)
+	defer server.Close()
+
+	tr2 := NewTrace2Dataset(&Rcvr_Base{Logger: zap.NewNop(), RcvrConfig: cfg})
+	tr2.pii_gather(cfg, server)
+	_, ok := tr2.pii[string(Trace2PiiHostname)]
+	assert.False(t, ok)
+
+	// But we do on a local connection.
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+
+	tr2 = NewTrace2Dataset(&Rcvr_Base{Logger: zap.NewNop(), RcvrConfig: cfg})
+	tr2.pii_gather(cfg, p1)
+	_, ok = tr2.pii[string(Trace2PiiHostname)]
+	assert.True(t, ok)
+}
@@ -26,6 +26,10 @@ type Rcvr_UnixSocket struct {
 	Base       *Rcvr_Base
 	SocketPath string
 
+	// The type of socket: "unix" (SOCK_STREAM) or "unixpacket"
+	// (SOCK_SEQPACKET).  Defaults to "unix" if empty.
+	SocketNetwork string
+
 	// Unix socket properties
 	listener   *net.UnixListener
 	inode      uint64
@@ -111,6 +115,43 @@ func (e *SocketInodeChangedError) Error() string {
 	return fmt.Sprintf("Inode changed: expected %v observed %v", e.InodeExpected, e.InodeObserved)
 }
 
+type SocketInUseError struct {
+	Pathname string
+}
+
+func NewSocketInUseError(pathname string) error {
+	return &SocketInUseError{
+		Pathname: pathname,
+	}
+}
+
+func (e *SocketInUseError) Error() string {
+	return fmt.Sprintf("Socket in use by another process: '%v'", e.Pathname)
+}
+
+// How long to wait when probing for another process listening on
+// an existing socket.
+const socketLiveProbeTimeout = time.Second
+
+// Is another process listening on this socket?  We connect and
+// immediately disconnect without sending any data, so the other
+// process will see an empty data stream (which it ignores).
+func isSocketLive(network string, path string) bool {
+	conn, err := net.DialTimeout(network, path, socketLiveProbeTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func (rcvr *Rcvr_UnixSocket) network() string {
+	if len(rcvr.SocketNetwork) == 0 {
+		return unixNetworkStream
+	}
+	return rcvr.SocketNetwork
+}
+
 func get_inode(path string) (uint64, error) {
 	var stat unix.Stat_t
 	err := unix.Lstat(path, &stat)
@@ -125,8 +166,6 @@ func get_inode(path string) (uint64, error) {
 func (rcvr *Rcvr_UnixSocket) openSocketForListening() error {
 	var err error
 
-	rcvr.mutex = sync.Mutex{}
-
 	// The `listen(2)` system call must create the unix domain socket
 	// in the file system.  If the pathname already exists on disk,
 	// the listen() call will fail.
@@ -153,23 +192,37 @@ func (rcvr *Rcvr_UnixSocket) openSocketForListening() error {
 	// We will capture the inode of the socket that we create here and
 	// add periodically verify in the listener loop's that the socket
 	// still exists and has the same inode.
+	//
+	// Optionally, try to connect to the socket first.  If that works,
+	// another process is servicing it, so refuse to steal it.  (There
+	// is still a small race here, but it avoids orphaning the other
+	// daemon in the common restart case.)
+	if rcvr.Base.RcvrConfig.RefuseIfSocketLive && isSocketLive(rcvr.network(), rcvr.SocketPath) {
+		err = NewSocketInUseError(rcvr.SocketPath)
+		rcvr.Base.Logger.Error(err.Error())
+		return err
+	}
+
 	_ = os.Remove(rcvr.SocketPath)
 
 	// There are 3 types of Unix Domain Sockets: SOCK_STREAM, SOCK_DGRAM,
 	// and SOCK_SEQPACKET.  Git Trace2 supports the first two.  However,
-	// We're only going to support the first.  This corresponds to the
-	// "af_unix:<path>" or "af_unix:stream:<path>" values for `GIT_TRACE2_EVENT`
-	// environment variable or the `trace2.eventtarget` config value.
+	// We're only going to support the first by default.  This corresponds
+	// to the "af_unix:<path>" or "af_unix:stream:<path>" values for
+	// `GIT_TRACE2_EVENT` environment variable or the `trace2.eventtarget`
+	// config value.
 	//
 	// Note: In the C# .Net Core class libraries on Unix, the NamedPipe
 	// classes are implemented using SOCK_STREAM Unix Domain Sockets
 	// under the hood.
 	//
-	// So limiting ourselves here to SOCK_STREAM is fine.
+	// Some constrained (non-Git) clients send one event per message on
+	// a SOCK_SEQPACKET socket instead, so we optionally support that
+	// with the "af_unix:seqpacket:<path>" form.  (Git itself does not.)
 	//
-	rcvr.listener, err = net.ListenUnix("unix",
+	rcvr.listener, err = net.ListenUnix(rcvr.network(),
 		&net.UnixAddr{Name: rcvr.SocketPath,
-			Net: "unix"})
+			Net: rcvr.network()})
 	if err != nil {
 		rcvr.Base.Logger.Error(fmt.Sprintf("could not create socket: %v", err))
 		return err
@@ -202,6 +255,127 @@ func (rcvr *Rcvr_UnixSocket) openSocketForListening() error {
 	return nil
 }
 
+// Bounds on how long to wait between attempts to recreate our socket
+// when `recreate_on_inode_change` is set.  We double the delay after
+// each attempt (successful or not) until the socket looks healthy for
+// a full tick, so that we don't get into a tight loop fighting with
+// another process over the pathname.
+const (
+	socketRecreateMinBackoff = 30 * time.Second
+	socketRecreateMaxBackoff = 10 * time.Minute
+)
+
+func nextSocketRecreateBackoff(backoff time.Duration) time.Duration {
+	if backoff < socketRecreateMinBackoff {
+		return socketRecreateMinBackoff
+	}
+	backoff *= 2
+	if backoff > socketRecreateMaxBackoff {
+		return socketRecreateMaxBackoff
+	}
+	return backoff
+}
+
+// Create a new socket to replace one that was deleted or stolen out
+// from under us.  If that fails, we keep the old listener (so that
+// the accept loop keeps running) and try again later.  The caller
+// must hold the mutex.
+func (rcvr *Rcvr_UnixSocket) recreateSocket() error {
+	oldListener := rcvr.listener
+	oldInode := rcvr.inode
+
+	err := rcvr.openSocketForListening()
+	if err != nil {
+		if rcvr.listener != nil && rcvr.listener != oldListener {
+			rcvr.listener.Close()
+		}
+		rcvr.listener = oldListener
+		rcvr.inode = oldInode
+		return err
+	}
+
+	// This will wake up the accept loop, which will notice that the
+	// listener changed and start accepting on the new one.
+	oldListener.Close()
+	return nil
+}
+
+// When we last tried to recreate our socket and how long to wait
+// before trying again.
+type socketRecreateState struct {
+	backoff time.Duration
+	next    time.Time
+}
+
+// Verify that our socket still exists and has the same inode.  If it
+// was deleted or stolen, either recreate it (if allowed) or report a
+// fatal error.  Returns false if we should stop watching the socket.
+func (rcvr *Rcvr_UnixSocket) checkSocket(host component.Host, recreate *socketRecreateState) bool {
+	rcvr.mutex.Lock()
+	defer rcvr.mutex.Unlock()
+
+	if rcvr.isShutdown || rcvr.inode == 0 {
+		// If Shutdown has already been called, then we don't
+		// want our timeout handler to touch anything and
+		// especially to not signal a new socket-stolen error
+		// (because the shutdown code just deleted it). We only
+		// want to throw a socket-stolen error if something
+		// happened in the filesystem behind our back.
+		return false
+	}
+
+	// See if the socket inode was changed by external events.
+	var errStolen error
+	inode, err := get_inode(rcvr.SocketPath)
+	if err != nil {
+		// We could not lstat() our socket, assume it
+		// has been deleted and/or stolen.  (We could
+		// check the error code to be more precise, but
+		// we'll probably do the same thing in all cases
+		// anyway.)
+		errStolen = NewSocketPathnameStolenError(rcvr.SocketPath, err)
+	} else if inode != rcvr.inode {
+		// Someone stole the pathname to the socket and
+		// created a different file/socket on the path.
+		// So we will never see another connection on our
+		// (still functional) socket.
+		errChanged := NewSocketInodeChangedError(rcvr.inode, inode)
+		errStolen = NewSocketPathnameStolenError(rcvr.SocketPath, errChanged)
+	}
+	if errStolen == nil {
+		recreate.backoff = 0
+		return true
+	}
+
+	if rcvr.Base.RcvrConfig.RecreateOnInodeChange {
+		// Take the pathname back (for example, when the
+		// socket directory was recreated in a container)
+		// and keep running.
+		if time.Now().Before(recreate.next) {
+			return true
+		}
+		rcvr.Base.Logger.Error(errStolen.Error())
+		recreate.backoff = nextSocketRecreateBackoff(recreate.backoff)
+		recreate.next = time.Now().Add(recreate.backoff)
+		if err := rcvr.recreateSocket(); err != nil {
+			rcvr.Base.Logger.Error(fmt.Sprintf("could not recreate socket (retrying in %v): %v",
+				recreate.backoff, err))
+		}
+		return true
+	}
+
+	// Otherwise, give up and shutdown (without deleting
+	// the new socket instance, if any; ours should
+	// magically go away when we close our file
+	// descriptor).
+	rcvr.Base.Logger.Error(errStolen.Error())
+
+	rcvr.inode = 0
+
+	componentstatus.ReportStatus(host, componentstatus.NewFatalErrorEvent(errStolen))
+	return false
+}
+
 // Listen for incoming connections from Trace2 clients.
 // Dispatch each to a worker thread.
 func (rcvr *Rcvr_UnixSocket) listenLoop(host component.Host) {
@@ -219,6 +393,8 @@ func (rcvr *Rcvr_UnixSocket) listenLoop(host component.Host) {
 	// our loop terminates for other reasons.
 	wg.Add(1)
 	go func() {
+		var recreate socketRecreateState
+
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
 		defer wg.Done()
@@ -230,65 +406,28 @@ func (rcvr *Rcvr_UnixSocket) listenLoop(host component.Host) {
 				// Force close the socket so that current and
 				// futuer calls to `AcceptUnix()` will fail.
 				rcvr.Base.Logger.Info("ctx.Done signalled")
+				rcvr.mutex.Lock()
 				rcvr.listener.Close()
+				rcvr.mutex.Unlock()
 				break LOOP
 			case <-doneListening:
 				break LOOP
 			case <-ticker.C:
-				rcvr.mutex.Lock()
-				if rcvr.isShutdown || rcvr.inode == 0 {
-					// If Shutdown has already been called, then we don't
-					// want our timeout handler to touch anything and
-					// especially to not signal a new socket-stolen error
-					// (because the shutdown code just deleted it). We only
-					// want to throw a socket-stolen error if something
-					// happened in the filesystem behind our back.
-					ticker.Stop()
-					rcvr.mutex.Unlock()
-					break LOOP
-				}
-				// See if the socket inode was changed by external events.
-				inode, err := get_inode(rcvr.SocketPath)
-				if err != nil {
-					// We could not lstat() our socket, assume it
-					// has been deleted and/or stolen and give up.
-					// (We could check the error code to be more
-					// precise, but we'll probably do the same thing
-					// in all cases anyway.)
-					errStolen := NewSocketPathnameStolenError(rcvr.SocketPath, err)
-					rcvr.Base.Logger.Error(errStolen.Error())
-
-					rcvr.inode = 0
-
-					componentstatus.ReportStatus(host, componentstatus.NewFatalErrorEvent(errStolen))
-					rcvr.mutex.Unlock()
-					break LOOP
-				}
-				if inode != rcvr.inode {
-					// Someone stole the pathname to the socket and
-					// created a different file/socket on the path.
-					// So we will never see another connection on our
-					// (still functional) socket.  We should give up
-					// and shutdown (without deleting the new socket
-					// instance; ours should magically go away when
-					// we close our file descriptor).
-					errChanged := NewSocketInodeChangedError(rcvr.inode, inode)
-					errStolen := NewSocketPathnameStolenError(rcvr.SocketPath, errChanged)
-					rcvr.Base.Logger.Error(errStolen.Error())
-
-					rcvr.inode = 0
-
-					componentstatus.ReportStatus(host, componentstatus.NewFatalErrorEvent(errStolen))
-					rcvr.mutex.Unlock()
+				if !rcvr.checkSocket(host, &recreate) {
 					break LOOP
 				}
-				rcvr.mutex.Unlock()
 			}
 		}
 	}()
 
 	for {
-		conn, err := rcvr.listener.AcceptUnix()
+		// The listener may be replaced by `recreateSocket()` in our
+		// subordinate thread.
+		rcvr.mutex.Lock()
+		listener := rcvr.listener
+		rcvr.mutex.Unlock()
+
+		conn, err := listener.AcceptUnix()
 		if err == nil {
 			worker_id++
 			go rcvr.worker(conn, worker_id)
@@ -302,6 +441,12 @@ func (rcvr *Rcvr_UnixSocket) listenLoop(host component.Host) {
 			rcvr.mutex.Unlock()
 			break
 		}
+		if listener != rcvr.listener {
+			// Our subordinate thread recreated the socket and closed
+			// the old one.  Start accepting on the new one.
+			rcvr.mutex.Unlock()
+			continue
+		}
 		if errors.Is(err, net.ErrClosed) {
 			// (This may not be possible any now because of refactorings.)
 			// Another thread closed our socket fd before Shutdown() was
@@ -334,6 +479,13 @@ func (rcvr *Rcvr_UnixSocket) worker(conn *net.UnixConn, worker_id uint64) {
 	var wg sync.WaitGroup
 	defer conn.Close()
 
+	if !rcvr.Base.acquireConnection() {
+		rcvr.Base.Logger.Debug(fmt.Sprintf("worker[%d] closing connection: too many connections",
+			worker_id))
+		return
+	}
+	defer rcvr.Base.releaseConnection()
+
 	doneReading := make(chan bool, 1)
 
 	// Create a subordinate thread to watch for `context.cancelFunc`
@@ -373,40 +525,121 @@ func (rcvr *Rcvr_UnixSocket) worker(conn *net.UnixConn, worker_id uint64) {
 
 	tr2.pii_gather(rcvr.Base.RcvrConfig, conn)
 
+	connStart := time.Now()
+
+	if rcvr.network() == unixNetworkSeqpacket {
+		haveError = rcvr.readMessages(conn, tr2, connStart)
+	} else {
+		haveError = rcvr.readLines(conn, tr2, connStart)
+	}
+
+	// Tell the subordinate thread that we are finished reading from
+	// the client so it can go away now.  This must not block (because
+	// the subordinate may already be gone (which is the case if the
+	// `context.cancelFunc` was called)).
+	doneReading <- true
+
+	conn.Close()
+
+	if !haveError {
+		tr2.exportTraces()
+	}
+
+	// Wait for our subordinate thread to exit
+	wg.Wait()
+}
+
+// Read newline-delimited lines from a SOCK_STREAM connection.
+// Returns true if there was an error.
+func (rcvr *Rcvr_UnixSocket) readLines(conn *net.UnixConn, tr2 *trace2Dataset, connStart time.Time) bool {
 	r := bufio.NewReader(conn)
+	sniffed := false
 	for {
+		if deadline := rcvr.Base.nextReadDeadline(connStart); !deadline.IsZero() {
+			conn.SetReadDeadline(deadline)
+		}
+
+		if !sniffed {
+			// Wait until we have set the read deadline to peek.
+			sniffed = true
+			var err error
+			if r, err = maybeDecompressStream(r); err != nil {
+				rcvr.Base.Logger.Error(err.Error())
+				return true
+			}
+		}
+
 		rawLine, err := r.ReadBytes('\n')
 		if err == io.EOF {
-			break
+			return false
 		}
 		if errors.Is(err, net.ErrClosed) {
-			break
+			return false
+		}
+		if isReadTimeout(err) {
+			// A stalled or long-running client.  Force close the
+			// connection, but still export the partial data (like
+			// we do when `context.cancelFunc` is called).
+			rcvr.Base.Logger.Debug(fmt.Sprintf("[dsid %06d] closing connection: read timeout",
+				tr2.datasetId))
+			return false
 		}
 		if err != nil {
 			rcvr.Base.Logger.Error(err.Error())
-			haveError = true
-			break
+			return true
 		}
 
 		if processRawLine(rawLine, tr2, rcvr.Base.Logger,
 			rcvr.Base.RcvrConfig.AllowCommandControlVerbs) != nil {
-			haveError = true
-			break
+			return true
 		}
 	}
+}
 
-	// Tell the subordinate thread that we are finished reading from
-	// the client so it can go away now.  This must not block (because
-	// the subordinate may already be gone (which is the case if the
-	// `context.cancelFunc` was called)).
-	doneReading <- true
+// The largest SOCK_SEQPACKET message that we accept.  A message that
+// is larger than this will be truncated by the kernel, so we treat it
+// as an error.  (This is a variable so that the tests can make it
+// smaller than the socket buffer.)
+var seqpacketMaxMessageSize = 1024 * 1024
 
-	conn.Close()
+// Read whole messages from a SOCK_SEQPACKET connection rather than
+// newline-delimited lines.  Each message should contain one event.
+// We do not look for a compressed stream here, since each message is
+// independent.  Returns true if there was an error.
+func (rcvr *Rcvr_UnixSocket) readMessages(conn *net.UnixConn, tr2 *trace2Dataset, connStart time.Time) bool {
+	buf := make([]byte, seqpacketMaxMessageSize)
 
-	if !haveError {
-		tr2.exportTraces()
-	}
+	for {
+		if deadline := rcvr.Base.nextReadDeadline(connStart); !deadline.IsZero() {
+			conn.SetReadDeadline(deadline)
+		}
 
-	// Wait for our subordinate thread to exit
-	wg.Wait()
+		n, _, flags, _, err := conn.ReadMsgUnix(buf, nil)
+		if err == io.EOF || (err == nil && n == 0) {
+			// A zero-length read means that the client hung up.
+			return false
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return false
+		}
+		if isReadTimeout(err) {
+			rcvr.Base.Logger.Debug(fmt.Sprintf("[dsid %06d] closing connection: read timeout",
+				tr2.datasetId))
+			return false
+		}
+		if err != nil {
+			rcvr.Base.Logger.Error(err.Error())
+			return true
+		}
+		if flags&unix.MSG_TRUNC != 0 {
+			rcvr.Base.Logger.Error(fmt.Sprintf("[dsid %06d] message larger than %d bytes",
+				tr2.datasetId, seqpacketMaxMessageSize))
+			return true
+		}
+
+		if processRawLine(buf[:n], tr2, rcvr.Base.Logger,
+			rcvr.Base.RcvrConfig.AllowCommandControlVerbs) != nil {
+			return true
+		}
+	}
 }
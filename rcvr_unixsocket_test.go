@@ -0,0 +1,213 @@
+//go:build !windows
+// +build !windows
+
+package trace2receiver
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.uber.org/zap"
+)
+
+// Make a pathname for a test socket.  Unix domain socket pathnames
+// are limited to about 100 bytes, so we can't always use `t.TempDir()`.
+func makeTestSocketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "t2r")
+	assert.Nil(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	return filepath.Join(dir, "trace2.socket")
+}
+
+// Create (but do not start) a Unix domain socket receiver that passes
+// the traces that it exports to the returned channel.
+func makeTestUnixSocketReceiver(t *testing.T, path string, network string, cfg *Config) (*Rcvr_UnixSocket, chan ptrace.Traces) {
+	ch := make(chan ptrace.Traces, 10)
+	next, _ := consumer.NewTraces(func(ctx context.Context, td ptrace.Traces) error {
+		ch <- td
+		return nil
+	})
+
+	rcvr := &Rcvr_UnixSocket{
+		Base: &Rcvr_Base{
+			Logger:         zap.NewNop(),
+			TracesConsumer: next,
+			RcvrConfig:     cfg,
+		},
+		SocketPath:    path,
+		SocketNetwork: network,
+	}
+
+	return rcvr, ch
+}
+
+// Send a complete (but minimal) Trace2 data stream to the socket.
+func sendTestUnixSocketStream(t *testing.T, path string) {
+	conn, err := net.Dial("unix", path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	events := []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	}
+	_, err = conn.Write([]byte(strings.Join(events, "\n") + "\n"))
+	assert.Nil(t, err)
+}
+
+func waitForTestTraces(t *testing.T, ch chan ptrace.Traces) ptrace.Traces {
+	select {
+	case td := <-ch:
+		return td
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for traces")
+	}
+	return ptrace.NewTraces()
+}
+
+// Verify that we recognize the socket types in the Git-style prefix.
+func Test_NormalizeUdsPath(t *testing.T) {
+	var tests = []struct {
+		in      string
+		path    string
+		network string
+		valid   bool
+	}{
+		{"/tmp/t2.socket", "/tmp/t2.socket", unixNetworkStream, true},
+		{"af_unix:/tmp/t2.socket", "/tmp/t2.socket", unixNetworkStream, true},
+		{"af_unix:stream:/tmp/t2.socket", "/tmp/t2.socket", unixNetworkStream, true},
+		{"af_unix:seqpacket:/tmp/t2.socket", "/tmp/t2.socket", unixNetworkSeqpacket, true},
+		{"af_unix:dgram:/tmp/t2.socket", "", "", false},
+	}
+
+	for _, test := range tests {
+		path, network, err := normalize_uds_path(test.in)
+		assert.Equal(t, test.valid, err == nil, test.in)
+		assert.Equal(t, test.path, path, test.in)
+		assert.Equal(t, test.network, network, test.in)
+	}
+}
+
+// Verify that the backoff between attempts to recreate the socket
+// doubles up to the maximum.
+func Test_NextSocketRecreateBackoff(t *testing.T) {
+	var tests = []struct {
+		in       time.Duration
+		expected time.Duration
+	}{
+		{0, socketRecreateMinBackoff},
+		{time.Second, socketRecreateMinBackoff},
+		{socketRecreateMinBackoff, 2 * socketRecreateMinBackoff},
+		{2 * socketRecreateMinBackoff, 4 * socketRecreateMinBackoff},
+		{socketRecreateMaxBackoff - time.Second, socketRecreateMaxBackoff},
+		{socketRecreateMaxBackoff, socketRecreateMaxBackoff},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, nextSocketRecreateBackoff(test.in), test.in)
+	}
+}
+
+// Verify that we recreate our socket when it is deleted or replaced
+// and keep accepting connections on the new one.
+func Test_UnixSocket_RecreateOnInodeChange(t *testing.T) {
+	path := makeTestSocketPath(t)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.RecreateOnInodeChange = true
+
+	rcvr, ch := makeTestUnixSocketReceiver(t, path, "", cfg)
+
+	// The host is only used to report a fatal error.
+	assert.Nil(t, rcvr.Start(context.Background(), nil))
+	defer rcvr.Shutdown(context.Background())
+
+	sendTestUnixSocketStream(t, path)
+	waitForTestTraces(t, ch)
+
+	var recreate socketRecreateState
+
+	// Nothing changed.
+	inode := rcvr.inode
+	assert.True(t, rcvr.checkSocket(nil, &recreate))
+	assert.Equal(t, inode, rcvr.inode)
+	assert.Equal(t, time.Duration(0), recreate.backoff)
+
+	// Someone deletes the socket.
+	assert.Nil(t, os.Remove(path))
+	assert.True(t, rcvr.checkSocket(nil, &recreate))
+	assert.NotEqual(t, uint64(0), rcvr.inode)
+	assert.Equal(t, socketRecreateMinBackoff, recreate.backoff)
+
+	sendTestUnixSocketStream(t, path)
+	waitForTestTraces(t, ch)
+
+	// Someone replaces the socket with a file.  We are still waiting
+	// for the backoff, so we leave it alone for now.
+	assert.Nil(t, os.Remove(path))
+	assert.Nil(t, os.WriteFile(path, []byte("not a socket"), 0644))
+	assert.True(t, rcvr.checkSocket(nil, &recreate))
+	fi, err := os.Lstat(path)
+	assert.Nil(t, err)
+	assert.True(t, fi.Mode().IsRegular())
+
+	// After the backoff, we take the pathname back.
+	recreate.next = time.Time{}
+	assert.True(t, rcvr.checkSocket(nil, &recreate))
+	assert.Equal(t, 2*socketRecreateMinBackoff, recreate.backoff)
+	inode, err = get_inode(path)
+	assert.Nil(t, err)
+	assert.Equal(t, inode, rcvr.inode)
+
+	sendTestUnixSocketStream(t, path)
+	waitForTestTraces(t, ch)
+
+	// Once the socket looks healthy again, the backoff is reset.
+	assert.True(t, rcvr.checkSocket(nil, &recreate))
+	assert.Equal(t, time.Duration(0), recreate.backoff)
+}
+
+// Verify that we refuse to steal a socket that another process is
+// listening on, but replace a dead socket file.
+func Test_UnixSocket_RefuseIfSocketLive(t *testing.T) {
+	path := makeTestSocketPath(t)
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.RefuseIfSocketLive = true
+
+	// Someone else is listening on the socket.
+	other, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	assert.Nil(t, err)
+	other.SetUnlinkOnClose(false)
+	assert.True(t, isSocketLive("unix", path))
+
+	rcvr, _ := makeTestUnixSocketReceiver(t, path, "", cfg)
+	err = rcvr.openSocketForListening()
+	_, ok := err.(*SocketInUseError)
+	assert.True(t, ok)
+
+	// They went away without deleting it.
+	other.Close()
+	assert.False(t, isSocketLive("unix", path))
+
+	rcvr, ch := makeTestUnixSocketReceiver(t, path, "", cfg)
+
+	// The host is only used to report a fatal error.
+	assert.Nil(t, rcvr.Start(context.Background(), nil))
+	defer rcvr.Shutdown(context.Background())
+
+	sendTestUnixSocketStream(t, path)
+	waitForTestTraces(t, ch)
+}
@@ -0,0 +1,122 @@
+package trace2receiver
+
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// regionSummary describes the aggregate spans that we emit in place
+// of the individual region spans when a command has more than
+// `region_summarize_threshold` of them.  There is one aggregate span
+// for each (category, label) pair, parented to the process span.
+// Spans that were nested within a summarized region are reparented
+// to the process span.
+type regionSummary struct {
+	// The aggregates in the order that we first saw each pair.
+	groups []*regionGroup
+
+	// The SpanIDs of the summarized regions.
+	summarized map[[8]byte]bool
+
+	mainSpanID [8]byte
+}
+
+// regionGroup is the aggregate of all of the (emitted) regions with
+// the same category and label.  The lifetime goes from the start of
+// the first one to the end of the last one.
+type regionGroup struct {
+	lifetime TrSpanEssentials
+
+	count int64
+	total time.Duration
+}
+
+type regionGroupKey struct {
+	category string
+	label    string
+}
+
+// Decide whether to summarize the regions that we would otherwise
+// emit (those not suppressed by `rs` or `ts`).  Returns nil if we
+// are not summarizing them.
+func (tr2 *trace2Dataset) computeRegionSummary(rs *regionSuppression, ts *threadSuppression) *regionSummary {
+	if tr2.rcvr_base == nil {
+		return nil
+	}
+
+	threshold := tr2.rcvr_base.RcvrConfig.RegionSummarizeThreshold
+	if threshold <= 0 || len(tr2.completedRegions) <= threshold {
+		return nil
+	}
+
+	var regions []*TrRegion
+	for _, r := range tr2.completedRegions {
+		if !rs.isSuppressed(r) && !ts.isOmitted(&r.lifetime) {
+			regions = append(regions, r)
+		}
+	}
+	if len(regions) <= threshold {
+		return nil
+	}
+
+	sum := &regionSummary{
+		summarized: make(map[[8]byte]bool),
+		mainSpanID: tr2.process.mainThread.lifetime.selfSpanID,
+	}
+
+	index := make(map[regionGroupKey]*regionGroup)
+	for _, r := range regions {
+		sum.summarized[r.lifetime.selfSpanID] = true
+
+		key := regionGroupKey{r.category, r.label}
+		g, ok := index[key]
+		if !ok {
+			g = &regionGroup{
+				lifetime: TrSpanEssentials{
+					selfSpanID:   tr2.NewSpanID(),
+					parentSpanID: sum.mainSpanID,
+					startTime:    r.lifetime.startTime,
+					endTime:      r.lifetime.endTime,
+					displayName:  r.lifetime.displayName,
+				},
+			}
+			index[key] = g
+			sum.groups = append(sum.groups, g)
+		}
+
+		if r.lifetime.startTime.Before(g.lifetime.startTime) {
+			g.lifetime.startTime = r.lifetime.startTime
+		}
+		if r.lifetime.endTime.After(g.lifetime.endTime) {
+			g.lifetime.endTime = r.lifetime.endTime
+		}
+		g.count++
+		g.total += r.lifetime.endTime.Sub(r.lifetime.startTime)
+	}
+
+	return sum
+}
+
+// Reparent an emitted span to the process span if its parent was
+// summarized.
+func (sum *regionSummary) fixupSpan(span *ptrace.Span) {
+	if sum == nil {
+		return
+	}
+
+	if sum.summarized[[8]byte(span.ParentSpanID())] {
+		span.SetParentSpanID(pcommon.SpanID(sum.mainSpanID))
+	}
+}
+
+func emitRegionGroupSpan(span *ptrace.Span, g *regionGroup, tr2 *trace2Dataset, dl FilterDetailLevel) {
+	emitSpanEssentials(span, &g.lifetime, tr2, dl)
+
+	sm := span.Attributes()
+	sm.PutStr(string(Trace2SpanType), "region_summary")
+
+	sm.PutInt(string(Trace2RegionCount), g.count)
+	sm.PutDouble(string(Trace2RegionTotalSec), g.total.Seconds())
+}
@@ -0,0 +1,143 @@
+package trace2receiver
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// regionSuppression describes the region spans that we will not emit
+// because they are shorter than `min_region_ms` (or the ruleset's
+// `min_region_duration_sec`).  Spans nested within
+// a suppressed region are reparented to the nearest emitted ancestor.
+type regionSuppression struct {
+	// Map the SpanID of each suppressed region to its parent SpanID.
+	parents map[[8]byte][8]byte
+
+	// Optionally, the data values of suppressed regions that were
+	// promoted to the nearest emitted ancestor span, indexed by the
+	// SpanID of that ancestor.
+	inheritedData map[[8]byte]map[string]map[string]interface{}
+}
+
+// Decide which regions to suppress and (optionally) promote the
+// data values of the suppressed regions onto the nearest emitted
+// ancestor.  Returns nil if we are not suppressing any regions.
+func (tr2 *trace2Dataset) computeRegionSuppression() *regionSuppression {
+	if tr2.rcvr_base == nil {
+		return nil
+	}
+
+	minRegion := tr2.rulesetMinRegion
+	if minRegion <= 0 {
+		minRegion = time.Duration(tr2.rcvr_base.RcvrConfig.MinRegionMs) * time.Millisecond
+	}
+	if minRegion <= 0 {
+		return nil
+	}
+
+	rs := &regionSuppression{
+		parents: make(map[[8]byte][8]byte),
+	}
+
+	var suppressed []*TrRegion
+	for _, r := range tr2.completedRegions {
+		if r.lifetime.endTime.Sub(r.lifetime.startTime) < minRegion {
+			rs.parents[r.lifetime.selfSpanID] = r.lifetime.parentSpanID
+			suppressed = append(suppressed, r)
+		}
+	}
+
+	if len(suppressed) == 0 {
+		return nil
+	}
+
+	if tr2.rcvr_base.RcvrConfig.PromoteSuppressedRegionData {
+		rs.inheritedData = make(map[[8]byte]map[string]map[string]interface{})
+
+		for _, r := range suppressed {
+			if len(r.dataValues) == 0 {
+				continue
+			}
+
+			target := rs.resolveParent(r.lifetime.parentSpanID)
+			cmap, ok := rs.inheritedData[target]
+			if !ok {
+				cmap = make(map[string]map[string]interface{})
+				rs.inheritedData[target] = cmap
+			}
+			for category, kv := range r.dataValues {
+				kmap, ok := cmap[category]
+				if !ok {
+					kmap = make(map[string]interface{})
+					cmap[category] = kmap
+				}
+				for k, v := range kv {
+					kmap[k] = v
+				}
+			}
+		}
+	}
+
+	return rs
+}
+
+// Return the `min_region_duration_sec` of the custom ruleset used by
+// the dataset, or zero if it did not resolve to a custom ruleset (or
+// the ruleset does not set it).
+func (rsdef *RulesetDefinition) minRegionDuration() time.Duration {
+	if rsdef == nil {
+		return 0
+	}
+
+	return time.Duration(rsdef.MinRegionDurationSec * float64(time.Second))
+}
+
+// The number of region spans that we will not emit.
+func (rs *regionSuppression) count() int64 {
+	if rs == nil {
+		return 0
+	}
+	return int64(len(rs.parents))
+}
+
+// Is this region suppressed?
+func (rs *regionSuppression) isSuppressed(r *TrRegion) bool {
+	if rs == nil {
+		return false
+	}
+
+	_, ok := rs.parents[r.lifetime.selfSpanID]
+	return ok
+}
+
+// Walk up the parent chain until we find a span that we will emit.
+func (rs *regionSuppression) resolveParent(parent [8]byte) [8]byte {
+	if rs == nil {
+		return parent
+	}
+
+	for {
+		p, ok := rs.parents[parent]
+		if !ok {
+			return parent
+		}
+		parent = p
+	}
+}
+
+// Fixup the parent SpanID of an emitted span and add any data values
+// inherited from suppressed descendant regions.
+func (rs *regionSuppression) fixupSpan(span *ptrace.Span, se *TrSpanEssentials) {
+	if rs == nil {
+		return
+	}
+
+	span.SetParentSpanID(rs.resolveParent(se.parentSpanID))
+
+	if data, ok := rs.inheritedData[se.selfSpanID]; ok {
+		jargs, _ := json.Marshal(data)
+		span.Attributes().PutStr(string(Trace2RegionInheritedData), string(jargs))
+	}
+}
@@ -1,6 +1,9 @@
 package trace2receiver
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // There are some clients that we want to reject as soon as we
 // learn their identity.  Primarily this is for daemon Git processes
@@ -15,9 +18,8 @@ import "errors"
 // There may be other background commands (like the new bundle server),
 // so we may have to have more than one detection methods.
 //
-// At this point I'm just going to hard code the rejection.  I don't
-// think it is worth adding code to `FilterSettings` to make this
-// optional.
+// We always reject `git fsmonitor--daemon`.  Other verbs can be listed
+// in the `reject_verbs` section of the `FilterSettings`.
 
 type RejectClientError struct {
 	Err       error
@@ -44,3 +46,20 @@ func IsFSMonitorDaemon(verb string) error {
 
 	return nil
 }
+
+// Is this Git command verb one that we should reject?  This includes
+// the builtin `fsmonitor--daemon` and any verbs in the `reject_verbs`
+// list in the filter settings (which may be nil).
+func IsRejectedVerb(verb string, fs *FilterSettings) error {
+	if err := IsFSMonitorDaemon(verb); err != nil {
+		return err
+	}
+
+	if fs.isRejectedVerb(verb) {
+		return &RejectClientError{
+			Err: fmt.Errorf("rejecting telemetry from '%s'", verb),
+		}
+	}
+
+	return nil
+}
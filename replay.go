@@ -0,0 +1,51 @@
+package trace2receiver
+
+import (
+	"bufio"
+	"context"
+	"io"
+)
+
+// ReplayStream reads a captured Trace2 event stream (one JSON event
+// per line, as written by `GIT_TRACE2_EVENT=<pathname>`) and sends it
+// through the same parse, apply, and export pipeline that the socket
+// and named pipe workers use.  This can be used to re-ingest archived
+// streams or to write golden tests.
+//
+// The stream must contain the events from a single Git process.
+// Command and control verbs are handled according to the
+// `enable_commands` setting in `base.RcvrConfig`.  PII is not
+// gathered since there is no client connection.  Pass
+// `WithSpanIDSeed()` to get reproducible SpanIDs.
+func ReplayStream(r io.Reader, base *Rcvr_Base, opts ...DatasetOption) error {
+	if base.ctx == nil {
+		// The receiver was not started, so we do not have a context
+		// to pass to the consumers.
+		base.ctx = context.Background()
+	}
+
+	tr2 := NewTrace2Dataset(base, opts...)
+
+	br := bufio.NewReader(r)
+	for {
+		rawLine, err := br.ReadBytes('\n')
+		if len(rawLine) > 0 {
+			// Unlike a client connection, a file may be missing the
+			// final newline, so process any partial last line too.
+			if perr := processRawLine(rawLine, tr2, base.Logger,
+				base.RcvrConfig.AllowCommandControlVerbs); perr != nil {
+				return perr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	tr2.exportTraces()
+
+	return nil
+}
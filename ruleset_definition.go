@@ -2,12 +2,45 @@ package trace2receiver
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 )
 
 // RulesetDefinition captures the content of a custom ruleset YML file.
 type RulesetDefinition struct {
 	Commands RulesetCommands `mapstructure:"commands"`
 	Defaults RulesetDefaults `mapstructure:"defaults"`
+
+	// Optional PII settings for datasets that use this ruleset.
+	// If present, these override the global PII settings.
+	Pii *PiiSettings `mapstructure:"pii"`
+
+	// Optional list of data event categories to keep or drop for
+	// datasets that use this ruleset.
+	DataCategories *RulesetDataCategories `mapstructure:"data_categories"`
+
+	// Optionally omit region spans shorter than this many seconds for
+	// datasets that use this ruleset.  If set, this overrides the
+	// global `min_region_ms` setting.
+	MinRegionDurationSec float64 `mapstructure:"min_region_duration_sec"`
+
+	// Optional groups of process span attributes to add to or remove
+	// from those selected by the detail level for datasets that use
+	// this ruleset.
+	Attributes *RulesetAttributes `mapstructure:"attributes"`
+
+	// Optional list of thread name prefixes.  The spans for matching
+	// (helper) threads are not emitted for datasets that use this
+	// ruleset.  Their regions are dropped or reparented to the main
+	// thread, depending on `thread_ignore_regions` ("drop" or "reparent").
+	ThreadIgnore        []string `mapstructure:"thread_ignore"`
+	ThreadIgnoreRegions string   `mapstructure:"thread_ignore_regions"`
+
+	threadIgnoreReparent bool
+
+	// Warnings about command keys that do not look like the names
+	// that we generate (and so will probably never match).
+	keyWarnings []string
 }
 
 // RulesetCommands is used to map a Git command to a detail level.
@@ -18,7 +51,8 @@ type RulesetDefinition struct {
 // to avoid circular dependencies.
 //
 // A command key should be in the format described in
-// `trace2Dataset.setQualifiedExeVerbModeName()`.
+// `trace2Dataset.setQualifiedExeVerbModeName()`.  The verb and/or
+// mode part may be a `*` wildcard, such as `git:*` or `git:checkout#*`.
 //
 // The value must be one of [`DetailLevelDropName`, ... ].
 type RulesetCommands map[string]string
@@ -31,6 +65,67 @@ type RulesetDefaults struct {
 	DetailLevelName string `mapstructure:"detail"`
 }
 
+// Is this a valid command key?  A `*` is only allowed as the entire
+// verb and/or mode part of the key, such as `git:*` or `git:checkout#*`.
+// (We check this so that typos like `git:check*` or `git*` are caught
+// when the ruleset is loaded rather than silently never matching.)
+func isValidCommandKey(k string) bool {
+	if !strings.Contains(k, "*") {
+		return true
+	}
+
+	exe, verbMode, found := strings.Cut(k, ":")
+	if !found || len(exe) == 0 || strings.Contains(exe, "*") {
+		return false
+	}
+
+	verb, mode, haveMode := strings.Cut(verbMode, "#")
+	if len(verb) == 0 || (strings.Contains(verb, "*") && verb != "*") {
+		return false
+	}
+	if haveMode && (len(mode) == 0 || (strings.Contains(mode, "*") && mode != "*")) {
+		return false
+	}
+
+	return true
+}
+
+// Does this command key look like one of the names generated by
+// `trace2Dataset.setQualifiedExeVerbModeName()`, such as `<exe>`,
+// `<exe>:<verb>`, or `<exe>:<verb>#<mode>`?  Keys that don't will
+// never match, but they are not strictly invalid (exe names may
+// contain unusual characters), so we only report them as suspicious.
+// For example, `git.status` or `git/status` for `git:status`.
+func checkCommandKeyFormat(k string) error {
+	if strings.ContainsAny(k, "/\\ \t") {
+		return fmt.Errorf("contains a path separator or whitespace")
+	}
+	if strings.Count(k, ":") > 1 || strings.Count(k, "#") > 1 {
+		return fmt.Errorf("contains more than one ':' or '#'")
+	}
+
+	exe, verbMode, haveVerb := strings.Cut(k, ":")
+	if len(exe) == 0 {
+		return fmt.Errorf("has an empty exe name")
+	}
+	if !haveVerb {
+		if strings.Contains(exe, "#") {
+			return fmt.Errorf("has a mode but no verb")
+		}
+		if strings.Contains(exe, ".") {
+			return fmt.Errorf("has a '.' where ':' was probably intended")
+		}
+		return nil
+	}
+
+	verb, mode, haveMode := strings.Cut(verbMode, "#")
+	if len(verb) == 0 || (haveMode && len(mode) == 0) {
+		return fmt.Errorf("has an empty verb or mode")
+	}
+
+	return nil
+}
+
 // Parse a `ruleset.yml` and decode.
 func parseRulesetFile(path string) (*RulesetDefinition, error) {
 	return parseYmlFile[RulesetDefinition](path, parseRulesetFromBuffer)
@@ -49,17 +144,57 @@ func parseRulesetFromBuffer(data []byte, path string) (*RulesetDefinition, error
 	for k_cmd, v_dl := range rsdef.Commands {
 		// Commands must map to detail levels and not to another ruleset (to
 		// avoid lookup loops).
-		_, err = getDetailLevel(v_dl)
-		if len(k_cmd) == 0 || err != nil {
+		_, _, err = parseDetailLevel(v_dl)
+		if len(k_cmd) == 0 || err != nil || !isValidCommandKey(k_cmd) {
 			return nil, fmt.Errorf("ruleset '%s' has invalid command '%s':'%s'",
 				path, k_cmd, v_dl)
 		}
+
+		if err = checkCommandKeyFormat(k_cmd); err != nil {
+			rsdef.keyWarnings = append(rsdef.keyWarnings,
+				fmt.Sprintf("ruleset '%s' has suspicious command '%s': %s",
+					path, k_cmd, err.Error()))
+		}
+	}
+	sort.Strings(rsdef.keyWarnings)
+
+	if rsdef.Pii != nil {
+		err = rsdef.Pii.validate()
+		if err != nil {
+			return nil, fmt.Errorf("ruleset '%s' has invalid pii settings: '%s'",
+				path, err.Error())
+		}
+	}
+
+	if rsdef.Attributes != nil {
+		err = rsdef.Attributes.validate()
+		if err != nil {
+			return nil, fmt.Errorf("ruleset '%s' has invalid attributes: '%s'",
+				path, err.Error())
+		}
+	}
+
+	for _, p := range rsdef.ThreadIgnore {
+		if len(p) == 0 {
+			return nil, fmt.Errorf("ruleset '%s' has empty thread_ignore prefix",
+				path)
+		}
+	}
+
+	rsdef.threadIgnoreReparent, err = parseThreadIgnoreRegions(rsdef.ThreadIgnoreRegions)
+	if err != nil {
+		return nil, fmt.Errorf("ruleset '%s' has %s", path, err.Error())
+	}
+
+	if rsdef.MinRegionDurationSec < 0 {
+		return nil, fmt.Errorf("ruleset '%s' has invalid min_region_duration_sec '%v'",
+			path, rsdef.MinRegionDurationSec)
 	}
 
 	if len(rsdef.Defaults.DetailLevelName) > 0 {
 		// The rulset default detail level must be a detail level and not the
 		// name of another ruleset (to avoid lookup loops).
-		_, err = getDetailLevel(rsdef.Defaults.DetailLevelName)
+		_, _, err = parseDetailLevel(rsdef.Defaults.DetailLevelName)
 		if err != nil {
 			return nil, fmt.Errorf("ruleset '%s' has invalid default detail level",
 				path)
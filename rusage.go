@@ -0,0 +1,56 @@
+package trace2receiver
+
+import (
+	"strconv"
+)
+
+// The category of the Trace2 "data" events that describe the
+// resource usage of the process.
+const rusageDataCategory string = "rusage"
+
+// TrRusage captures the well-known resource usage values of the
+// process from "rusage" data events, so that we can emit them as
+// first-class attributes at all detail levels rather than only in
+// the `trace2.process.data` JSON blob.
+type TrRusage struct {
+	// The peak resident set size (from the `max_rss` key) in the
+	// units reported by the platform (usually kilobytes).
+	maxRss     int64
+	haveMaxRss bool
+
+	// The user and system CPU time in seconds (from the
+	// `user_time_sec` and `system_time_sec` keys).
+	userTimeSec       float64
+	haveUserTimeSec   bool
+	systemTimeSec     float64
+	haveSystemTimeSec bool
+}
+
+// Remember the value of an "rusage" data event if it has one of the
+// well-known keys.  Other keys are ignored (but are still available
+// in the generic data values).
+func (ru *TrRusage) setDataValue(key string, value interface{}) {
+	switch key {
+	case "max_rss":
+		ru.maxRss, ru.haveMaxRss = dataValueToInt64(value)
+	case "user_time_sec":
+		ru.userTimeSec, ru.haveUserTimeSec = dataValueToFloat64(value)
+	case "system_time_sec":
+		ru.systemTimeSec, ru.haveSystemTimeSec = dataValueToFloat64(value)
+	}
+}
+
+// Convert a generic data value into a float, if possible.
+func dataValueToFloat64(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,141 @@
+package trace2receiver
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// `settingsReloader` watches the filter settings file and the ruleset
+// files that it references and reloads them when any of them change.
+// This lets us tune the filtering without restarting the collector
+// (and dropping any in-flight connections).
+//
+// We poll the modification times rather than using a file system
+// notification package, since the files rarely change and editors
+// have many different ways of replacing a file.
+type settingsReloader struct {
+	cfg    *Config
+	logger *zap.Logger
+
+	// The modification times of the files when we last loaded them.
+	mtimes map[string]time.Time
+}
+
+func newSettingsReloader(cfg *Config, logger *zap.Logger) *settingsReloader {
+	sr := &settingsReloader{
+		cfg:    cfg,
+		logger: logger,
+	}
+	sr.mtimes = sr.collectMtimes(cfg.getFilterSettings())
+	return sr
+}
+
+// Get the modification times of the filter settings file and all of
+// the ruleset files.  A missing file gets the zero time.
+func (sr *settingsReloader) collectMtimes(fs *FilterSettings) map[string]time.Time {
+	mtimes := make(map[string]time.Time)
+
+	paths := []string{sr.cfg.FilterSettingsPath}
+	if fs != nil {
+		for _, path := range fs.Rulesets {
+			paths = append(paths, path)
+		}
+		// Notice when ruleset files are added to or removed from the
+		// `rs:*` directory.
+		if len(fs.rulesetDir) > 0 {
+			paths = append(paths, fs.rulesetDir)
+		}
+	}
+
+	for _, path := range paths {
+		var mtime time.Time
+		if fi, err := os.Stat(path); err == nil {
+			mtime = fi.ModTime()
+		}
+		mtimes[path] = mtime
+	}
+
+	return mtimes
+}
+
+// Get the ruleset table from the filter settings file without loading
+// (or validating) the ruleset files.  Returns nil if we cannot parse
+// the filter settings file itself.
+func peekFilterSettingsRulesets(path string) *FilterSettings {
+	fs, err := parseYmlFile[FilterSettings](path, parseYmlBuffer[FilterSettings])
+	if err != nil {
+		return nil
+	}
+
+	if rulesets, dir, err := expandRulesetGlob(fs.Rulesets); err == nil {
+		fs.Rulesets = rulesets
+		fs.rulesetDir = dir
+	}
+
+	return fs
+}
+
+// Have any of the files changed since we last loaded them?
+func (sr *settingsReloader) changed() bool {
+	for path, mtime := range sr.mtimes {
+		var now time.Time
+		if fi, err := os.Stat(path); err == nil {
+			now = fi.ModTime()
+		}
+		if !now.Equal(mtime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reload the filter settings if any of the files have changed.  If
+// we cannot parse them, we keep using the previous (known good)
+// settings.  Returns true if the settings were replaced.
+func (sr *settingsReloader) reloadIfChanged() bool {
+	if !sr.changed() {
+		return false
+	}
+
+	fs, err := parseFilterSettings(sr.cfg.FilterSettingsPath)
+	if err != nil {
+		// Remember the current modification times so that we don't
+		// keep complaining about the same broken file.  Use the files
+		// referenced by the new filter settings file (rather than the
+		// previous settings), so that fixing a broken ruleset file
+		// that it just started using triggers a reload.
+		sr.mtimes = sr.collectMtimes(peekFilterSettingsRulesets(sr.cfg.FilterSettingsPath))
+		sr.logger.Error(fmt.Sprintf("could not reload filter settings (keeping previous settings): %v", err))
+		return false
+	}
+
+	sr.mtimes = sr.collectMtimes(fs)
+	sr.cfg.setFilterSettings(fs)
+	sr.logger.Info(fmt.Sprintf("reloaded filter settings from '%s'", sr.cfg.FilterSettingsPath))
+	for _, w := range fs.commandKeyWarnings() {
+		sr.logger.Warn(w)
+	}
+	return true
+}
+
+// Periodically check for changes to the filter settings until the
+// receiver is shutdown.
+func (rcvr_base *Rcvr_Base) settingsReloadLoop() {
+	sr := newSettingsReloader(rcvr_base.RcvrConfig, rcvr_base.Logger)
+
+	ticker := time.NewTicker(rcvr_base.RcvrConfig.SettingsReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rcvr_base.ctx.Done():
+			return
+		case <-ticker.C:
+			sr.reloadIfChanged()
+		}
+	}
+}
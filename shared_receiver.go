@@ -0,0 +1,72 @@
+package trace2receiver
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/collector/component"
+)
+
+// The collector creates a receiver for each signal type (traces,
+// metrics, logs) that references us in the service pipelines.  However, we
+// can only listen on the Unix domain socket or Windows named pipe
+// once.  So we create a single platform receiver for each config and
+// share it between the traces, metrics, and logs receivers.
+type sharedReceiver struct {
+	rcvr component.Component
+	base *Rcvr_Base
+
+	startOnce    sync.Once
+	startErr     error
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+var sharedReceiversMutex sync.Mutex
+var sharedReceivers map[*Config]*sharedReceiver = make(map[*Config]*sharedReceiver)
+
+// Lookup the shared receiver for this config or use `create()` to
+// create a new platform receiver.
+func getSharedReceiver(cfg *Config,
+	create func() (component.Component, *Rcvr_Base)) *sharedReceiver {
+
+	sharedReceiversMutex.Lock()
+	defer sharedReceiversMutex.Unlock()
+
+	sr, ok := sharedReceivers[cfg]
+	if !ok {
+		sr = &sharedReceiver{}
+		sr.rcvr, sr.base = create()
+		sharedReceivers[cfg] = sr
+	}
+
+	return sr
+}
+
+// Start the platform receiver the first time we are called.
+//
+// This is part of the `component.Component` interface.
+func (sr *sharedReceiver) Start(ctx context.Context, host component.Host) error {
+	sr.startOnce.Do(func() {
+		sr.startErr = sr.rcvr.Start(ctx, host)
+	})
+	return sr.startErr
+}
+
+// Shutdown the platform receiver the first time we are called.
+//
+// This is part of the `component.Component` interface.
+func (sr *sharedReceiver) Shutdown(ctx context.Context) error {
+	sr.shutdownOnce.Do(func() {
+		sharedReceiversMutex.Lock()
+		for cfg, v := range sharedReceivers {
+			if v == sr {
+				delete(sharedReceivers, cfg)
+			}
+		}
+		sharedReceiversMutex.Unlock()
+
+		sr.shutdownErr = sr.rcvr.Shutdown(ctx)
+	})
+	return sr.shutdownErr
+}
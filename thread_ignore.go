@@ -0,0 +1,156 @@
+package trace2receiver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// What to do with the regions of an ignored thread.
+const (
+	threadIgnoreRegionsDrop     string = "drop"
+	threadIgnoreRegionsReparent string = "reparent"
+)
+
+// RulesetThreadIgnore describes the (helper) threads whose spans we
+// should not emit for datasets that use a ruleset.
+type RulesetThreadIgnore struct {
+	// Thread name prefixes.  A prefix may match either the full
+	// thread name (such as "th01:preload") or the name without the
+	// "th<nr>:" prefix that Git adds (such as "preload").
+	Prefixes []string
+
+	// Drop the regions of an ignored thread or reparent the top-level
+	// ones to the main thread.
+	Reparent bool
+}
+
+func parseThreadIgnoreRegions(s string) (bool, error) {
+	switch s {
+	case "", threadIgnoreRegionsDrop:
+		return false, nil
+	case threadIgnoreRegionsReparent:
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid thread_ignore_regions '%s'", s)
+	}
+}
+
+var reThreadNumber = regexp.MustCompile(`^th[0-9]+:`)
+
+// Does this thread name match one of the ignored prefixes?
+func (ti *RulesetThreadIgnore) matches(name string) bool {
+	if ti == nil {
+		return false
+	}
+
+	short := reThreadNumber.ReplaceAllString(name, "")
+	for _, p := range ti.Prefixes {
+		if strings.HasPrefix(name, p) || strings.HasPrefix(short, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the thread ignore settings for a dataset.  These are only
+// defined in custom rulesets, so we return nil if the dataset did not
+// resolve to a custom ruleset (or the ruleset has an empty list).
+func (rsdef *RulesetDefinition) threadIgnore() *RulesetThreadIgnore {
+	if rsdef == nil || len(rsdef.ThreadIgnore) == 0 {
+		return nil
+	}
+
+	return &RulesetThreadIgnore{
+		Prefixes: rsdef.ThreadIgnore,
+		Reparent: rsdef.threadIgnoreReparent,
+	}
+}
+
+// threadSuppression describes the thread (and optionally region)
+// spans that we will not emit because the thread was ignored.  Any
+// emitted span whose parent was not emitted is reparented to the
+// main thread.
+type threadSuppression struct {
+	// The SpanIDs of the threads and regions that we will not emit.
+	omitted map[[8]byte]bool
+
+	mainSpanID [8]byte
+}
+
+// Decide which thread and region spans to omit.  Returns nil if
+// we are not omitting any.
+func (tr2 *trace2Dataset) computeThreadSuppression() *threadSuppression {
+	if tr2.threadIgnore == nil {
+		return nil
+	}
+
+	ts := &threadSuppression{
+		omitted:    make(map[[8]byte]bool),
+		mainSpanID: tr2.process.mainThread.lifetime.selfSpanID,
+	}
+
+	for _, th := range tr2.threads {
+		if tr2.threadIgnore.matches(th.lifetime.displayName) {
+			ts.omitted[th.lifetime.selfSpanID] = true
+		}
+	}
+
+	if len(ts.omitted) == 0 {
+		return nil
+	}
+
+	if !tr2.threadIgnore.Reparent {
+		// Regions are completed inside-out, so we cannot tell which
+		// thread a nested region belongs to in one pass.  Walk up the
+		// parent chain of each region until we find a thread.
+		parents := make(map[[8]byte][8]byte)
+		for _, r := range tr2.completedRegions {
+			parents[r.lifetime.selfSpanID] = r.lifetime.parentSpanID
+		}
+
+		var dropped [][8]byte
+		for _, r := range tr2.completedRegions {
+			p := r.lifetime.parentSpanID
+			for {
+				next, ok := parents[p]
+				if !ok {
+					break
+				}
+				p = next
+			}
+			if ts.omitted[p] {
+				dropped = append(dropped, r.lifetime.selfSpanID)
+			}
+		}
+		for _, id := range dropped {
+			ts.omitted[id] = true
+		}
+	}
+
+	return ts
+}
+
+// Should we omit this span?
+func (ts *threadSuppression) isOmitted(se *TrSpanEssentials) bool {
+	if ts == nil {
+		return false
+	}
+
+	return ts.omitted[se.selfSpanID]
+}
+
+// Reparent an emitted span to the main thread if its parent was omitted.
+func (ts *threadSuppression) fixupSpan(span *ptrace.Span) {
+	if ts == nil {
+		return
+	}
+
+	if ts.omitted[[8]byte(span.ParentSpanID())] {
+		span.SetParentSpanID(pcommon.SpanID(ts.mainSpanID))
+	}
+}
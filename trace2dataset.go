@@ -2,9 +2,13 @@ package trace2receiver
 
 import (
 	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"math/rand"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -35,8 +39,31 @@ type trace2Dataset struct {
 	// Did we see at least one Trace2 event from the client?
 	sawData bool
 
+	// Did we see the "version" event?  It is used to compute the
+	// TraceID and process SpanIDs, so the other event handlers
+	// assume that it has already been applied.
+	sawVersion bool
+
+	// Events that arrived before the "version" event.  Unless the
+	// receiver is configured to reject such clients, we hold them
+	// here and apply them after the "version" event arrives.
+	preVersionEvents []*TrEvent
+
+	// The earliest and latest event timestamps seen in the data
+	// stream.  These may be outside of the nominal process lifetime.
+	firstEventTime time.Time
+	lastEventTime  time.Time
+
+	// The largest `t_abs` (seconds since the start of the process, as
+	// measured by Git) seen on any event.
+	maxTAbs  float64
+	haveTAbs bool
+
 	randSource *rand.Rand
 
+	// The recent raw lines, if `debug_ring_size` is set.
+	debugRing *rawLineRing
+
 	otelTraceID [16]byte
 
 	// The Trace2 SID for the command.  Technically, this should be
@@ -54,21 +81,72 @@ type trace2Dataset struct {
 	// Map of thread data for non-main threads.
 	threads map[string]*TrThread
 
+	// Per-thread timers and counters that arrived before the
+	// "thread_start" event for their thread.  These are moved to
+	// the thread when it starts.  (Only the metrics and the times of
+	// the buffered events are set.)
+	pendingThreads map[string]*TrThread
+
 	// The set of child processes spawned by the current process.
 	children map[int64]*TrChild
 
+	// The number of children with each display name.  This is used
+	// to give repeated display names an occurrence index.
+	childDisplayNameCounts map[string]int
+
 	// The set of exec()-style replacement processes spawned.
 	exec map[int64]*TrExec
 
 	// The set of completed regions (across any thread).
 	completedRegions []*TrRegion
 
+	// The number of regions entered (across any thread).  This is
+	// used to enforce `max_regions`.
+	regionCount int
+
+	// Which of the `max_regions`, `max_children`, and `max_threads`
+	// caps were hit.  We stop accumulating new entries when a cap is
+	// hit rather than letting a misbehaving client exhaust memory.
+	truncatedRegions  bool
+	truncatedChildren bool
+	truncatedThreads  bool
+
+	// The names of the detail level used and the one that the
+	// `shadow_ruleset` would have selected.  These are empty if
+	// there is no shadow ruleset.
+	filterDetail       string
+	filterShadowDetail string
+
+	// The raw repo nickname sent by the worktree (using the
+	// `nickname_key`), whether or not it mapped to a ruleset.
+	repoNickname string
+
+	// If the detail level was given as a sampling directive, such as
+	// "dl:sample:10", the N in "1 in N".  Zero if not sampled.
+	sampleRate int
+
 	// Dictionary of optional PII data that we want to include in
 	// the process data.  This is only used when bits are enabled
 	// in the `receivers.trace2receiver.pii.*` are set in config.yml.
 	// These fields maybe GDPR-restricted, so use this at your own risk.
 	// Map from the SemConv keys to the data value.
 	pii map[string]string
+
+	// The OS-reported PID of the client process, if requested by
+	// the PII settings and available.  Zero if not.
+	peerPid int64
+
+	// The worktree pathname scrub rules from the resolved PII settings.
+	worktreeScrubRules []*piiScrubRule
+
+	// The `min_region_duration_sec` from the resolved ruleset, if any.
+	rulesetMinRegion time.Duration
+
+	// The `attributes` groups from the resolved ruleset, if any.
+	attributeGroups *RulesetAttributes
+
+	// The `thread_ignore` settings from the resolved ruleset, if any.
+	threadIgnore *RulesetThreadIgnore
 }
 
 // Data associated with the entire process.
@@ -77,6 +155,8 @@ type TrProcess struct {
 
 	// The version string from the Git command
 	exeVersion string
+	// The numeric components of `exeVersion`, if we could parse it.
+	exeVersionParts TrExeVersion
 	// The Trace2 file format version
 	evtVersion string
 
@@ -88,6 +168,8 @@ type TrProcess struct {
 	cmdVerb string
 	// The concise verb hierarchy.
 	cmdHierarchy string
+	// The depth of the command in the Git process tree (from the SID).
+	sidDepth int
 	// The command mode (set for commands like `checkout` that
 	// have multiple uses, like branch switching to single file
 	// restore).
@@ -103,9 +185,35 @@ type TrProcess struct {
 
 	// The exit code for the main process
 	exeExitCode int64
+	// The codes and times from the "exit" and "atexit" events.
+	// The times are zero if we did not see the event.
+	exitCode   int64
+	exitTime   time.Time
+	atexitCode int64
+	atexitTime time.Time
+
+	// True if the process was terminated by a signal.
+	sawSignal bool
+	signo     int64
+	// True if we saw an "exit", "atexit", or "signal" event.  If not,
+	// the data stream ended before the process exited (it was killed
+	// or crashed) and we synthesized the end time and exit code.
+	cleanExit bool
+	// True if the dataset is partial: we had to force-close a region,
+	// thread, or child (or the process itself) in `prepareDataset()`
+	// or the process was terminated by a signal.
+	incomplete bool
+
+	// The optional normalized outcome of the command.
+	outcome string
 	// Arbitrarily pick one error messages from the process
 	exeErrorMsg string
 	exeErrorFmt string
+	// The first few error messages from the process.
+	exeErrors []TrErrorMessage
+	// The first few "error" and "printf" events (in order) when
+	// emitting log records.
+	logRecords []TrLogRecord
 
 	// Map repo-ids to worktree from `def_repo` events.
 	// We use a map rather than an array because we are
@@ -113,9 +221,12 @@ type TrProcess struct {
 	repoSet map[int64]string
 
 	// The collapsed set of advertised parameters from the
-	// `def_param` events.
+	// `def_param` events.  We also remember the scope (such as
+	// "local" or "submodule") of the winning value for each key.
+	// Parameters from environment variables do not have a scope.
 	paramSetValues     map[string]string
 	paramSetPriorities map[string]int
+	paramSetScopes     map[string]string
 
 	// Collect the values of all process-level "data" and "data_json"
 	// events using a "data[<category>][<key>] = <value>" model.
@@ -123,12 +234,67 @@ type TrProcess struct {
 	// rather, we just remember the last value.
 	dataValues map[string]map[string]interface{}
 
+	// The number of region-level data events that we attached to
+	// the process because we could not find the region.
+	orphanedDataCount int64
+
 	// Process-level stopwatch timers
 	timers map[string]map[string]TrStopwatchTimer
 
 	// Process-level global counters
 	counters map[string]map[string]int64
 
+	// Values of well-known data events extracted from `dataValues`
+	// (and the region data values).  Map from attribute name to value.
+	wellKnownData map[string]int64
+
+	// The derived rates (value per second of process duration) of the
+	// well-known data values whose rule requests one.
+	wellKnownRates map[string]float64
+
+	// Well-known resource usage values from "rusage" data events.
+	rusage TrRusage
+
+	// Whether the command used the filesystem monitor.
+	usedFSMonitor bool
+
+	// Optional sum of the process-level counters in each category.
+	counterTotals map[string]int64
+
+	// Did the command run longer than the slow threshold?
+	slow bool
+
+	// The friendly name for the verb from `operation_names`.
+	operation string
+
+	// Whether the command ran in a worktree or outside of any repo.
+	// Empty if we cannot tell.
+	repoContext string
+
+	// A hash of the command line with the operand values redacted,
+	// so that commands with the same "shape" can be grouped.
+	argvShapeHash string
+
+	// A hash of the qualified name, argc, and the set of flags.
+	fingerprint string
+
+	// The number of child processes, the sum of their elapsed times,
+	// and the number of them that were hooks or credential helpers.
+	childCount     int64
+	childTotalTime time.Duration
+	hookCount      int64
+	credCount      int64
+
+	// The number of distinct region categories and (category, label)
+	// pairs in the completed regions.
+	regionCategoryCount int64
+	regionLabelCount    int64
+
+	// The time between the start of the process and the start of
+	// the earliest region (if there were any regions).
+	startupDelay     time.Duration
+	haveStartupDelay bool
+
 	qualifiedNames QualifiedNames
 }
 
@@ -162,6 +328,11 @@ type TrThread struct {
 	// Stack of open regions on this thread.
 	regionStack []*TrRegion
 
+	// The region most recently popped from `regionStack`.  Git
+	// sometimes emits a data event just after the matching
+	// "region_leave" event, so we use this as a fallback.
+	lastCompletedRegion *TrRegion
+
 	// Per-thread timers[<category>][<name>]
 	timers map[string]map[string]TrStopwatchTimer
 
@@ -185,6 +356,39 @@ type TrChild struct {
 	readystate string
 	class      string
 	hookname   string
+
+	// The working directory of the child, if it was sent.
+	cd string
+
+	// The name of the credential helper and the operation, if this
+	// is a credential helper child.
+	credHelper string
+	credOp     string
+
+	// When the child reported a ready state (from "child_ready"),
+	// if it did.
+	readyTime time.Time
+
+	// Did we see a "child_exit" event (meaning that the parent waited
+	// for the child)?
+	sawExit bool
+}
+
+// How long did a credential helper block the parent?  Only defined
+// for credential helpers that the parent waited for.  If the helper
+// reported a ready state first, we only count the time until then
+// (since an interactive prompt would happen before that).
+func (child *TrChild) credBlockingTime() (time.Duration, bool) {
+	if child.class != "cred" || !child.sawExit {
+		return 0, false
+	}
+
+	end := child.lifetime.endTime
+	if !child.readyTime.IsZero() && child.readyTime.Before(end) {
+		end = child.readyTime
+	}
+
+	return end.Sub(child.lifetime.startTime), true
 }
 
 type TrExec struct {
@@ -193,6 +397,10 @@ type TrExec struct {
 	argv     []interface{}
 	exe      string
 	exitcode int64
+
+	// Was the end time taken from an "exit" or "atexit" event
+	// (because there was no "exec_result" event)?
+	endedByExit bool
 }
 
 type TrRegion struct {
@@ -200,8 +408,14 @@ type TrRegion struct {
 
 	repoId       int64
 	nestingLevel int64
+	category     string
+	label        string
 	message      string
 
+	// The source location of the "region_enter" event, if present.
+	file string
+	line int64
+
 	// Collect the values of all region-level "data" and "data_json"
 	// events using a "data[<category>][<key>] = <value>" model.
 	// We assume that Git does not repeat (category,key) pairs, or
@@ -209,6 +423,11 @@ type TrRegion struct {
 	dataValues map[string]map[string]interface{}
 }
 
+type TrErrorMessage struct {
+	Fmt string
+	Msg string
+}
+
 type TrStopwatchTimer struct {
 	Intervals int64   `json:"intervals"`
 	Total_sec float64 `json:"total_sec"`
@@ -240,7 +459,19 @@ func makeDatasetId() uint64 {
 	return dsid
 }
 
-func NewTrace2Dataset(rcvr_base *Rcvr_Base) *trace2Dataset {
+// DatasetOption customizes a new dataset in `NewTrace2Dataset()`.
+type DatasetOption func(tr2 *trace2Dataset)
+
+// WithSpanIDSeed seeds the SpanID generator with a fixed value rather
+// than from crypto/rand, so that replaying the same stream gives the
+// same SpanIDs.  This is intended for tests and the replay tool.
+func WithSpanIDSeed(seed int64) DatasetOption {
+	return func(tr2 *trace2Dataset) {
+		tr2.randSource = rand.New(rand.NewSource(seed))
+	}
+}
+
+func NewTrace2Dataset(rcvr_base *Rcvr_Base, opts ...DatasetOption) *trace2Dataset {
 	var tr2 *trace2Dataset = new(trace2Dataset)
 
 	tr2.rcvr_base = rcvr_base
@@ -250,16 +481,26 @@ func NewTrace2Dataset(rcvr_base *Rcvr_Base) *trace2Dataset {
 	_ = binary.Read(crand.Reader, binary.LittleEndian, &rngSeed)
 	tr2.randSource = rand.New(rand.NewSource(rngSeed))
 
+	if rcvr_base != nil {
+		tr2.debugRing = newRawLineRing(rcvr_base.RcvrConfig.DebugRingSize)
+	}
+
 	tr2.threads = make(map[string]*TrThread)
 	tr2.children = make(map[int64]*TrChild)
+	tr2.childDisplayNameCounts = make(map[string]int)
 
 	tr2.process.repoSet = make(map[int64]string)
 	tr2.process.paramSetValues = make(map[string]string)
 	tr2.process.paramSetPriorities = make(map[string]int)
+	tr2.process.paramSetScopes = make(map[string]string)
 
 	tr2.pii = make(map[string]string)
 	tr2.exec = make(map[int64]*TrExec)
 
+	for _, opt := range opts {
+		opt(tr2)
+	}
+
 	return tr2
 }
 
@@ -279,6 +520,7 @@ func (tr2 *trace2Dataset) popRegionStack(th *TrThread, t time.Time) {
 
 	tr2.completedRegions = append(tr2.completedRegions, r)
 	th.regionStack = th.regionStack[:rCount-1]
+	th.lastCompletedRegion = r
 }
 
 func (tr2 *trace2Dataset) popAllRegionStack(th *TrThread, t time.Time) {
@@ -331,37 +573,97 @@ func (tr2 *trace2Dataset) prepareDataset() bool {
 		return false
 	}
 
-	now := time.Now()
+	incompleteEnd := tr2.computeIncompleteEnd(time.Now())
 
 	for _, child := range tr2.children {
 		if child.lifetime.isIncomplete() {
-			child.lifetime.endTime = now
+			child.lifetime.endTime = incompleteEnd
 			child.pid = -1
 			child.exitcode = -1
+			tr2.process.incomplete = true
 		}
 	}
 
+	tr2.synthesizePendingThreads()
+
 	for _, th := range tr2.threads {
 		if th.lifetime.isIncomplete() {
-			tr2.popAllRegionStack(th, now)
-			th.lifetime.endTime = now
+			tr2.popAllRegionStack(th, incompleteEnd)
+			th.lifetime.endTime = incompleteEnd
+			tr2.process.incomplete = true
 		}
 	}
 
 	// The main thread is special, both because it is not in the thread
 	// vector and because we normally expect "exit" and "atexit" events
 	// and we deferred the region stack cleanup.
-	tr2.popAllRegionStack(&tr2.process.mainThread, now)
+	if len(tr2.process.mainThread.regionStack) > 0 {
+		tr2.popAllRegionStack(&tr2.process.mainThread, incompleteEnd)
+		tr2.process.incomplete = true
+	}
 
 	if tr2.process.mainThread.lifetime.isIncomplete() {
-		tr2.process.mainThread.lifetime.endTime = now
+		tr2.process.mainThread.lifetime.endTime = incompleteEnd
 		tr2.process.exeExitCode = -1
+		tr2.process.incomplete = true
+	} else if tr2.process.mainThread.lifetime.endTime.Before(tr2.process.mainThread.lifetime.startTime) {
+		// The wall-clock times are inconsistent, perhaps because the
+		// system clock was changed while the command was running.
+		tr2.process.mainThread.lifetime.endTime = tr2.endTimeFromTAbs(
+			tr2.process.mainThread.lifetime.startTime)
+	}
+
+	if tr2.process.sawSignal {
+		tr2.process.incomplete = true
+	}
+
+	tr2.process.exeVersionParts = parseExeVersion(tr2.process.exeVersion)
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.EmitCmdOutcome {
+		tr2.process.outcome = tr2.computeCmdOutcome(tr2.rcvr_base.RcvrConfig.outcomeExitCodes)
+	}
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.EmitWellKnownData {
+		tr2.extractWellKnownData(tr2.rcvr_base.RcvrConfig.DataValueRules)
+	}
+
+	var ind *FSMonitorIndicators
+	if tr2.rcvr_base != nil {
+		ind = &tr2.rcvr_base.RcvrConfig.FSMonitorIndicators
+	}
+	tr2.process.usedFSMonitor = tr2.computeUsedFSMonitor(ind)
+
+	tr2.process.repoContext = tr2.computeRepoContext()
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.SlowThresholdSec > 0 {
+		sec, ok := tr2.processDurationSec()
+		tr2.process.slow = ok && sec > tr2.rcvr_base.RcvrConfig.SlowThresholdSec
+	}
+
+	tr2.countChildren()
+	tr2.countDistinctRegions()
+	tr2.computeStartupDelay()
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.FoldThreadMetrics {
+		tr2.foldThreadMetrics()
+	}
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.EmitCounterCategoryTotals {
+		tr2.computeCounterCategoryTotals()
 	}
 
 	// Compute normalized <exe>, <exe>[:<verb>], and <exe>[:<verb>][#<mode>]
 	tr2.setQualifiedExeName()
 	tr2.setQualifiedExeVerbName()
 	tr2.setQualifiedExeVerbModeName()
+	tr2.setArgvShapeHash()
+	tr2.setFingerprint()
+
+	var fs *FilterSettings
+	if tr2.rcvr_base != nil {
+		fs = tr2.rcvr_base.RcvrConfig.getFilterSettings()
+	}
+	tr2.setOperationName(fs)
 
 	// Update the display name of the process-level work unit to be
 	// this normalized/qualified name so that the process-level span
@@ -371,6 +673,294 @@ func (tr2 *trace2Dataset) prepareDataset() bool {
 	return true
 }
 
+// Summarize the child processes so that we can tell at a glance
+// how much of the command's time was spent waiting on them.
+func (tr2 *trace2Dataset) countChildren() {
+	tr2.process.childCount = int64(len(tr2.children))
+
+	for _, child := range tr2.children {
+		tr2.process.childTotalTime += child.lifetime.endTime.Sub(child.lifetime.startTime)
+
+		switch child.class {
+		case "hook":
+			tr2.process.hookCount++
+		case "cred":
+			tr2.process.credCount++
+		}
+	}
+}
+
+// Count the distinct region categories and (category, label) pairs
+// to give a rough idea of how many Git subsystems the command used.
+func (tr2 *trace2Dataset) countDistinctRegions() {
+	type categoryLabel struct {
+		category string
+		label    string
+	}
+
+	categories := make(map[string]bool)
+	labels := make(map[categoryLabel]bool)
+
+	for _, r := range tr2.completedRegions {
+		categories[r.category] = true
+		labels[categoryLabel{r.category, r.label}] = true
+	}
+
+	tr2.process.regionCategoryCount = int64(len(categories))
+	tr2.process.regionLabelCount = int64(len(labels))
+}
+
+// Compute how long the command ran before the first region started
+// (that is, before it did its first measurable work).
+func (tr2 *trace2Dataset) computeStartupDelay() {
+	var earliest time.Time
+
+	for _, r := range tr2.completedRegions {
+		if earliest.IsZero() || r.lifetime.startTime.Before(earliest) {
+			earliest = r.lifetime.startTime
+		}
+	}
+
+	if earliest.IsZero() {
+		return
+	}
+
+	tr2.process.startupDelay = earliest.Sub(tr2.process.mainThread.lifetime.startTime)
+	tr2.process.haveStartupDelay = true
+}
+
+// Fold the per-thread timers and counters (from all threads, including
+// the main thread) into the process-level timers and counters, so that
+// the process span reflects the work done on worker threads when thread
+// spans are not emitted.
+//
+// Git normally emits a process-level "timer" and "counter" event that
+// already aggregates the values across all threads, so we only fold
+// the (category, name) pairs that are missing at the process level.
+// Otherwise we would count them twice.
+func (tr2 *trace2Dataset) foldThreadMetrics() {
+	var foldedTimers map[string]map[string]TrStopwatchTimer
+	var foldedCounters map[string]map[string]int64
+
+	threads := []*TrThread{&tr2.process.mainThread}
+	for _, th := range tr2.threads {
+		threads = append(threads, th)
+	}
+
+	for _, th := range threads {
+		for category, nmap := range th.timers {
+			for name, t := range nmap {
+				if _, ok := tr2.process.timers[category][name]; ok {
+					continue
+				}
+				if foldedTimers == nil {
+					foldedTimers = make(map[string]map[string]TrStopwatchTimer)
+				}
+				fmap, ok := foldedTimers[category]
+				if !ok {
+					fmap = make(map[string]TrStopwatchTimer)
+					foldedTimers[category] = fmap
+				}
+				if f, ok := fmap[name]; ok {
+					f.Intervals += t.Intervals
+					f.Total_sec += t.Total_sec
+					f.Min_sec = min(f.Min_sec, t.Min_sec)
+					f.Max_sec = max(f.Max_sec, t.Max_sec)
+					fmap[name] = f
+				} else {
+					fmap[name] = t
+				}
+			}
+		}
+
+		for category, nmap := range th.counters {
+			for name, c := range nmap {
+				if _, ok := tr2.process.counters[category][name]; ok {
+					continue
+				}
+				if foldedCounters == nil {
+					foldedCounters = make(map[string]map[string]int64)
+				}
+				fmap, ok := foldedCounters[category]
+				if !ok {
+					fmap = make(map[string]int64)
+					foldedCounters[category] = fmap
+				}
+				fmap[name] += c
+			}
+		}
+	}
+
+	for category, fmap := range foldedTimers {
+		if tr2.process.timers == nil {
+			tr2.process.timers = make(map[string]map[string]TrStopwatchTimer)
+		}
+		nmap, ok := tr2.process.timers[category]
+		if !ok {
+			nmap = make(map[string]TrStopwatchTimer)
+			tr2.process.timers[category] = nmap
+		}
+		for name, t := range fmap {
+			nmap[name] = t
+		}
+	}
+
+	for category, fmap := range foldedCounters {
+		if tr2.process.counters == nil {
+			tr2.process.counters = make(map[string]map[string]int64)
+		}
+		nmap, ok := tr2.process.counters[category]
+		if !ok {
+			nmap = make(map[string]int64)
+			tr2.process.counters[category] = nmap
+		}
+		for name, c := range fmap {
+			nmap[name] = c
+		}
+	}
+}
+
+// Sum the process-level counters within each category to give a
+// coarse view of the work done in each area.
+func (tr2 *trace2Dataset) computeCounterCategoryTotals() {
+	if len(tr2.process.counters) == 0 {
+		return
+	}
+
+	tr2.process.counterTotals = make(map[string]int64)
+	for category, counters := range tr2.process.counters {
+		var total int64
+		for _, v := range counters {
+			total += v
+		}
+		tr2.process.counterTotals[category] = total
+	}
+}
+
+// Git omits "time" from most events in "brief" mode.  Reconstruct it
+// from "t_abs" (seconds since the start of the process, which we
+// approximate using the time of the "version" event) or "t_rel"
+// (seconds since the start of whatever the event ends; see
+// `lookupTRelBase()`).  If neither is available, synthesize a time
+// slightly after the latest event that we have seen so that spans
+// still have positive durations.
+func (tr2 *trace2Dataset) reconstructBriefTime(evt *TrEvent) {
+	processStart := tr2.process.mainThread.lifetime.startTime
+	if evt.pmf_t_abs != nil && !processStart.IsZero() {
+		evt.mf_time = processStart.Add(secondsToDuration(*evt.pmf_t_abs))
+		return
+	}
+
+	if evt.pmf_t_rel != nil {
+		base := tr2.lookupTRelBase(evt)
+		if !base.IsZero() {
+			evt.mf_time = base.Add(secondsToDuration(*evt.pmf_t_rel))
+			return
+		}
+	}
+
+	if tr2.lastEventTime.IsZero() {
+		evt.mf_time = time.Now()
+	} else {
+		evt.mf_time = tr2.lastEventTime.Add(time.Microsecond)
+	}
+}
+
+// Return the start time that the `t_rel` on this event is relative
+// to.  Git reports the elapsed time of the thread on "thread_exit",
+// of the matching "region_enter" on "region_leave", of the matching
+// "child_start" on "child_exit", and of the innermost open region
+// (or the thread) on "data".  Returns a zero time if we cannot find
+// it.
+func (tr2 *trace2Dataset) lookupTRelBase(evt *TrEvent) time.Time {
+	if evt.pm_child_exit != nil {
+		child, ok := tr2.children[evt.pm_child_exit.mf_child_id]
+		if !ok || child == nil {
+			return time.Time{}
+		}
+		return child.lifetime.startTime
+	}
+
+	th, ok := tr2.lookupThread(evt.mf_thread)
+	if !ok || th == nil {
+		return time.Time{}
+	}
+
+	if evt.pm_region_leave != nil {
+		rCount := len(th.regionStack)
+		if rCount == 0 || th.regionStack[rCount-1].nestingLevel != evt.pm_region_leave.mf_nesting {
+			return time.Time{}
+		}
+		return th.regionStack[rCount-1].lifetime.startTime
+	}
+
+	if evt.pm_generic_data != nil && evt.pm_generic_data.mf_nesting > 1 {
+		if r := th.lookupDataRegion(evt.pm_generic_data.mf_nesting); r != nil {
+			return r.lifetime.startTime
+		}
+	}
+
+	return th.lifetime.startTime
+}
+
+// Remember the largest `t_abs` seen in the data stream.
+func (tr2 *trace2Dataset) updateMaxTAbs(evt *TrEvent) {
+	if evt.pmf_t_abs == nil {
+		return
+	}
+	if !tr2.haveTAbs || *evt.pmf_t_abs > tr2.maxTAbs {
+		tr2.maxTAbs = *evt.pmf_t_abs
+		tr2.haveTAbs = true
+	}
+}
+
+// Compute the end time of the process from the largest `t_abs` that
+// we saw, for when the wall-clock time is missing or inconsistent.
+// The wall-clock time always wins when it is usable.  Return
+// `fallback` if we did not see any `t_abs` values.
+func (tr2 *trace2Dataset) endTimeFromTAbs(fallback time.Time) time.Time {
+	start := tr2.process.mainThread.lifetime.startTime
+	if !tr2.haveTAbs || start.IsZero() {
+		return fallback
+	}
+	return start.Add(secondsToDuration(tr2.maxTAbs))
+}
+
+// Choose the end time for the work units that were still open when
+// the client disconnected, so that they all end together and do not
+// outlast the process span.  If the process exited normally, use its
+// end time.  Otherwise, use the largest `t_abs` (or `now`), but not
+// before the latest event that we saw.
+func (tr2 *trace2Dataset) computeIncompleteEnd(now time.Time) time.Time {
+	main := &tr2.process.mainThread.lifetime
+	if !main.isIncomplete() && !main.endTime.Before(main.startTime) {
+		return main.endTime
+	}
+
+	end := tr2.endTimeFromTAbs(now)
+	if end.Before(tr2.lastEventTime) {
+		end = tr2.lastEventTime
+	}
+	return end
+}
+
+func secondsToDuration(sec float64) time.Duration {
+	return time.Duration(sec * float64(time.Second))
+}
+
+// Remember the earliest and latest event times seen in the stream.
+func (tr2 *trace2Dataset) updateEventTimeRange(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if tr2.firstEventTime.IsZero() || t.Before(tr2.firstEventTime) {
+		tr2.firstEventTime = t
+	}
+	if tr2.lastEventTime.IsZero() || t.After(tr2.lastEventTime) {
+		tr2.lastEventTime = t
+	}
+}
+
 // A span (region, thread, etc.) is said to be "incomplete"
 // (meaning unclosed) if the end time is still zero.  This is
 // possible if the corresponding `endRegion()` or `endThread()`
@@ -403,6 +993,137 @@ func (tr2 *trace2Dataset) setQualifiedExeName() {
 	tr2.process.qualifiedNames.exe = exeName
 }
 
+// The placeholder for a redacted command line argument.
+const argvRedacted string = "<val>"
+
+// Redact the operand values in a command line, keeping the names of
+// the flags.  An argument that does not start with `-` (or any that
+// follows `--`) is replaced by a placeholder, as is the value in a
+// `--<name>=<value>` flag.  The pathname in `argv[0]` is replaced by
+// the normalized exe name and the first non-flag argument is kept if
+// it is the verb, so that `git -C /a checkout -b x` and `git -C /b
+// checkout -b y` have the same shape.
+func redactArgv(argv []interface{}, exe string, verb string) []string {
+	if len(argv) == 0 {
+		return nil
+	}
+
+	shape := []string{exe}
+	sawVerb := false
+	sawDashDash := false
+
+	for _, v := range argv[1:] {
+		arg, _ := v.(string)
+		switch {
+		case sawDashDash:
+			shape = append(shape, argvRedacted)
+		case arg == "--":
+			sawDashDash = true
+			shape = append(shape, arg)
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			if name, _, found := strings.Cut(arg, "="); found {
+				arg = name + "=" + argvRedacted
+			}
+			shape = append(shape, arg)
+		case !sawVerb && len(verb) > 0 && arg == verb:
+			sawVerb = true
+			shape = append(shape, arg)
+		default:
+			shape = append(shape, argvRedacted)
+		}
+	}
+
+	return shape
+}
+
+// Compute a stable hash of the redacted command line.
+func (tr2 *trace2Dataset) setArgvShapeHash() {
+	shape := redactArgv(tr2.process.cmdArgv, tr2.process.qualifiedNames.exe,
+		tr2.process.cmdVerb)
+	if len(shape) == 0 {
+		return
+	}
+
+	h := sha256.Sum256([]byte(strings.Join(shape, "\x00")))
+	tr2.process.argvShapeHash = hex.EncodeToString(h[0:8])
+}
+
+// Compute a stable hash of the qualified <exe>:<verb>#<mode> name,
+// the number of args, and the (sorted and deduplicated) set of flag
+// names, so that commands with the same shape can be grouped by a
+// single key.  Unlike the argv shape hash, this ignores the order
+// and repetition of the flags.
+func (tr2 *trace2Dataset) setFingerprint() {
+	shape := redactArgv(tr2.process.cmdArgv, tr2.process.qualifiedNames.exe,
+		tr2.process.cmdVerb)
+
+	seen := make(map[string]bool)
+	var flags []string
+	for _, arg := range shape {
+		if strings.HasPrefix(arg, "-") && arg != "--" && !seen[arg] {
+			seen[arg] = true
+			flags = append(flags, arg)
+		}
+	}
+	sort.Strings(flags)
+
+	parts := append([]string{
+		tr2.process.qualifiedNames.exeVerbMode,
+		fmt.Sprintf("%d", len(tr2.process.cmdArgv)),
+	}, flags...)
+
+	h := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	tr2.process.fingerprint = hex.EncodeToString(h[0:8])
+}
+
+// Git commands that never look for a repo, so we know that they ran
+// outside of one even though we did not see a "def_repo" event.
+var repoContextNoRepoVerbs map[string]bool = map[string]bool{
+	"version": true,
+	"help":    true,
+}
+
+// Decide whether the command ran in a worktree or outside of any
+// repo.  Git only sends a "def_repo" event when it sets up a
+// worktree, so a command in a bare repo (such as a server-side
+// "upload-pack") looks just like one that did not find a repo.
+// Return "" if we cannot tell.
+func (tr2 *trace2Dataset) computeRepoContext() string {
+	if _, ok := tr2.process.repoSet[1]; ok {
+		return "worktree"
+	}
+
+	if len(tr2.process.repoSet) == 0 && repoContextNoRepoVerbs[tr2.process.cmdVerb] {
+		return "none"
+	}
+
+	return ""
+}
+
+// Map the verb to a friendly operation name using the optional
+// `operation_names` table in the filter settings.  We try the
+// qualified `<exe>:<verb>` name first (which has the real verb for
+// `_run_dashed_` commands) and then the raw verb.  Unmapped verbs
+// use the verb itself.
+func (tr2 *trace2Dataset) setOperationName(fs *FilterSettings) {
+	verb, ok := strings.CutPrefix(tr2.process.qualifiedNames.exeVerb,
+		tr2.process.qualifiedNames.exe+":")
+	if !ok {
+		return
+	}
+
+	tr2.process.operation = verb
+
+	if fs == nil {
+		return
+	}
+	if op, ok := fs.OperationNames[tr2.process.qualifiedNames.exeVerb]; ok {
+		tr2.process.operation = op
+	} else if op, ok := fs.OperationNames[tr2.process.cmdVerb]; ok {
+		tr2.process.operation = op
+	}
+}
+
 // Set the "qualified exe + verb name"
 //
 // The `git` executable assumes a top-level command (aka verb),
@@ -490,30 +1211,115 @@ func (tr2 *trace2Dataset) setQualifiedExeVerbModeName() {
 	tr2.process.qualifiedNames.exeVerbMode += "#" + tr2.process.cmdMode
 }
 
+// Decide whether to keep this command when sampling 1 in `rate`.
+// Rather than using a random number, we use the TraceID (which is
+// derived from the SID of the top-level Git command), so that the
+// decision is repeatable and all of the Git commands in a process
+// tree that use the same rate are either kept or dropped together.
+func (tr2 *trace2Dataset) keepSample(rate int) bool {
+	if rate <= 1 {
+		return true
+	}
+
+	v := binary.BigEndian.Uint64(tr2.otelTraceID[0:8])
+	return v%uint64(rate) == 0
+}
+
 func (tr2 *trace2Dataset) exportTraces() {
 	if !tr2.sawData {
 		return
 	}
 
+	if !tr2.sawVersion && len(tr2.preVersionEvents) > 0 {
+		tr2.rcvr_base.Logger.Warn(fmt.Sprintf("[dsid %06d] discarding %d events; never received 'version' event",
+			tr2.datasetId, len(tr2.preVersionEvents)))
+	}
+
 	if !tr2.prepareDataset() {
+		tr2.stats().inc(rcvrStatDatasetsInsufficient)
+		return
+	}
+
+	// The filter settings may be reloaded at any time, so use the same
+	// ones for all of the decisions about this dataset.
+	fs := tr2.rcvr_base.RcvrConfig.getFilterSettings()
+
+	if k, reject := fs.shouldRejectByParams(
+		tr2.process.paramSetValues); reject {
+		tr2.rcvr_base.Logger.Debug(fmt.Sprintf("[dsid %06d] dropped by reject_if_param '%s'",
+			tr2.datasetId, k))
+		tr2.stats().inc(rcvrStatDatasetsDropped)
 		return
 	}
 
-	dl, dl_debug := computeDetailLevel(
-		tr2.rcvr_base.RcvrConfig.filterSettings,
+	// The custom ruleset (if any) that the dataset resolved to.
+	rsdef := fs.resolveRulesetDef(tr2.process.paramSetValues)
+
+	tr2.scrubPii(rsdef.piiSettings(tr2.rcvr_base.RcvrConfig.piiSettings))
+	tr2.filterDataCategories(rsdef.dataCategories())
+
+	tr2.rulesetMinRegion = rsdef.minRegionDuration()
+	tr2.attributeGroups = rsdef.attributeGroups()
+	tr2.threadIgnore = rsdef.threadIgnore()
+
+	tr2.repoNickname = fs.lookupRepoNickname(tr2.process.paramSetValues)
+
+	dl, rate, dl_debug := computeDetailLevelAndSampleRate(
+		fs,
 		tr2.process.paramSetValues,
 		tr2.process.qualifiedNames)
 
 	tr2.rcvr_base.Logger.Debug(dl_debug)
 
+	if shadow_dl, shadow_rate, shadow_debug, ok := computeShadowDetailLevel(
+		fs,
+		tr2.process.qualifiedNames); ok {
+		tr2.rcvr_base.Logger.Debug(shadow_debug)
+		tr2.filterDetail = detailLevelName(dl, rate)
+		tr2.filterShadowDetail = detailLevelName(shadow_dl, shadow_rate)
+	}
+
 	if dl == DetailLevelDrop {
+		tr2.stats().inc(rcvrStatDatasetsDropped)
+		return
+	}
+
+	if rate > 0 {
+		if !tr2.keepSample(rate) {
+			tr2.stats().inc(rcvrStatDatasetsDropped)
+			return
+		}
+		tr2.sampleRate = rate
+	}
+
+	if tr2.rcvr_base.RcvrConfig.EmitMetrics {
+		tr2.exportMetrics()
+	}
+
+	if tr2.rcvr_base.RcvrConfig.EmitLogs {
+		tr2.exportLogs()
+	}
+
+	// We may have only been instantiated in a metrics or logs pipeline.
+	if tr2.rcvr_base.TracesConsumer == nil {
 		return
 	}
 
 	traces := tr2.ToTraces(dl)
 
+	if fn := tr2.rcvr_base.RcvrConfig.PostProcessTraces; fn != nil {
+		traces = fn(traces)
+		if traces.SpanCount() == 0 {
+			tr2.stats().inc(rcvrStatDatasetsDropped)
+			return
+		}
+	}
+
 	err := tr2.rcvr_base.TracesConsumer.ConsumeTraces(tr2.rcvr_base.ctx, traces)
 	if err != nil {
 		tr2.rcvr_base.Logger.Error(err.Error())
+		return
 	}
+
+	tr2.stats().inc(rcvrStatDatasetsExported)
 }
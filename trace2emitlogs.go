@@ -0,0 +1,110 @@
+package trace2receiver
+
+import (
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+// TrLogRecord captures an "error" or "printf" event so that we can
+// emit it as an OTEL log record.
+type TrLogRecord struct {
+	time   time.Time
+	event  string
+	thread string
+	msg    string
+	fmt    string
+}
+
+// Remember an "error" or "printf" event if we are emitting logs.  We
+// use the `max_error_messages` limit for the total number of them.
+func (tr2 *trace2Dataset) addLogRecord(evt *TrEvent, msg string, fmt string) {
+	if tr2.rcvr_base == nil || !tr2.rcvr_base.RcvrConfig.EmitLogs {
+		return
+	}
+
+	maxRecords := DefaultMaxErrorMessages
+	if tr2.rcvr_base.RcvrConfig.MaxErrorMessages > 0 {
+		maxRecords = tr2.rcvr_base.RcvrConfig.MaxErrorMessages
+	}
+	if len(tr2.process.logRecords) >= maxRecords {
+		return
+	}
+
+	tr2.process.logRecords = append(tr2.process.logRecords, TrLogRecord{
+		time:   evt.mf_time,
+		event:  evt.mf_event,
+		thread: evt.mf_thread,
+		msg:    msg,
+		fmt:    fmt,
+	})
+}
+
+// Map the Trace2 event type to a log severity.
+func logSeverity(event string) (plog.SeverityNumber, string) {
+	if event == "error" {
+		return plog.SeverityNumberError, "ERROR"
+	}
+	return plog.SeverityNumberInfo, "INFO"
+}
+
+// Convert the captured "error" and "printf" events into OTEL log
+// records.  Each record is correlated with the process span using
+// the TraceID and the SpanID of the process.
+func (tr2 *trace2Dataset) ToLogs() plog.Logs {
+	pl := plog.NewLogs()
+
+	resourceLogs := pl.ResourceLogs().AppendEmpty()
+	resourceAttrs := resourceLogs.Resource().Attributes()
+	scopes := resourceLogs.ScopeLogs().AppendEmpty()
+
+	tr2.insertResourceServiceFields(resourceAttrs)
+	tr2.insertResourceTelemetrySDKFields(resourceAttrs)
+	tr2.insertResourceInstrumentationScope(scopes.Scope())
+
+	resourceAttrs.PutStr(string(Trace2CmdVersion), tr2.process.exeVersion)
+	tr2.insertResourceVersionParts(resourceAttrs)
+	resourceAttrs.PutStr(string(Trace2CmdSid), tr2.trace2SID)
+
+	for _, r := range tr2.process.logRecords {
+		lr := scopes.LogRecords().AppendEmpty()
+		lr.SetTimestamp(pcommon.NewTimestampFromTime(r.time))
+		lr.SetObservedTimestamp(pcommon.NewTimestampFromTime(r.time))
+
+		sevNum, sevText := logSeverity(r.event)
+		lr.SetSeverityNumber(sevNum)
+		lr.SetSeverityText(sevText)
+
+		lr.Body().SetStr(r.msg)
+
+		lr.SetTraceID(tr2.otelTraceID)
+		lr.SetSpanID(tr2.process.mainThread.lifetime.selfSpanID)
+
+		lr.Attributes().PutStr(string(Trace2LogEvent), r.event)
+		lr.Attributes().PutStr(string(Trace2LogThread), r.thread)
+		if len(r.fmt) > 0 {
+			lr.Attributes().PutStr(string(Trace2CmdErrFmt), r.fmt)
+		}
+	}
+
+	return pl
+}
+
+// Send the captured log records to the logs pipeline (if there is
+// one and we have anything to send).
+func (tr2 *trace2Dataset) exportLogs() {
+	if tr2.rcvr_base.LogsConsumer == nil {
+		return
+	}
+	if len(tr2.process.logRecords) == 0 {
+		return
+	}
+
+	logs := tr2.ToLogs()
+
+	err := tr2.rcvr_base.LogsConsumer.ConsumeLogs(tr2.rcvr_base.ctx, logs)
+	if err != nil {
+		tr2.rcvr_base.Logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+package trace2receiver
+
+import (
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+// Metric names for the process-level Trace2 stopwatch timers and
+// global counters.
+const (
+	metricNameProcessTimer   = "trace2.process.timer"
+	metricNameProcessCounter = "trace2.process.counter"
+)
+
+// Convert the process-level timers and counters in the dataset
+// into OTEL metrics.  Each timer becomes a histogram data point and
+// each counter becomes a (delta) sum data point, with the Trace2
+// category and name as data point attributes.  The data points
+// span the lifetime of the process.
+func (tr2 *trace2Dataset) ToMetrics() pmetric.Metrics {
+	pm := pmetric.NewMetrics()
+
+	resourceMetrics := pm.ResourceMetrics().AppendEmpty()
+	resourceAttrs := resourceMetrics.Resource().Attributes()
+	scopes := resourceMetrics.ScopeMetrics().AppendEmpty()
+
+	tr2.insertResourceServiceFields(resourceAttrs)
+	tr2.insertResourceTelemetrySDKFields(resourceAttrs)
+	tr2.insertResourceInstrumentationScope(scopes.Scope())
+
+	resourceAttrs.PutStr(string(Trace2CmdVersion), tr2.process.exeVersion)
+	tr2.insertResourceVersionParts(resourceAttrs)
+	resourceAttrs.PutStr(string(Trace2CmdSid), tr2.trace2SID)
+
+	startTime := pcommon.NewTimestampFromTime(tr2.process.mainThread.lifetime.startTime)
+	endTime := pcommon.NewTimestampFromTime(tr2.process.mainThread.lifetime.endTime)
+
+	if len(tr2.process.timers) > 0 {
+		m := scopes.Metrics().AppendEmpty()
+		m.SetName(metricNameProcessTimer)
+		m.SetUnit("s")
+		h := m.SetEmptyHistogram()
+		h.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+
+		for category, nmap := range tr2.process.timers {
+			for name, t := range nmap {
+				dp := h.DataPoints().AppendEmpty()
+				dp.SetStartTimestamp(startTime)
+				dp.SetTimestamp(endTime)
+				dp.SetCount(uint64(t.Intervals))
+				dp.SetSum(t.Total_sec)
+				dp.SetMin(t.Min_sec)
+				dp.SetMax(t.Max_sec)
+				dp.Attributes().PutStr(string(Trace2MetricCategory), category)
+				dp.Attributes().PutStr(string(Trace2MetricName), name)
+			}
+		}
+	}
+
+	if len(tr2.process.counters) > 0 {
+		m := scopes.Metrics().AppendEmpty()
+		m.SetName(metricNameProcessCounter)
+		s := m.SetEmptySum()
+		s.SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+		s.SetIsMonotonic(true)
+
+		for category, nmap := range tr2.process.counters {
+			for name, v := range nmap {
+				dp := s.DataPoints().AppendEmpty()
+				dp.SetStartTimestamp(startTime)
+				dp.SetTimestamp(endTime)
+				dp.SetIntValue(v)
+				dp.Attributes().PutStr(string(Trace2MetricCategory), category)
+				dp.Attributes().PutStr(string(Trace2MetricName), name)
+			}
+		}
+	}
+
+	return pm
+}
+
+// Send the process-level timers and counters to the metrics
+// pipeline (if there is one and we have anything to send).
+func (tr2 *trace2Dataset) exportMetrics() {
+	if tr2.rcvr_base.MetricsConsumer == nil {
+		return
+	}
+	if len(tr2.process.timers) == 0 && len(tr2.process.counters) == 0 {
+		return
+	}
+
+	metrics := tr2.ToMetrics()
+
+	err := tr2.rcvr_base.MetricsConsumer.ConsumeMetrics(tr2.rcvr_base.ctx, metrics)
+	if err != nil {
+		tr2.rcvr_base.Logger.Error(err.Error())
+	}
+}
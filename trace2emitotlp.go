@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/ptrace"
@@ -47,8 +50,17 @@ func (tr2 *trace2Dataset) insertResourceServiceFields(resourceAttrs pcommon.Map)
 	// Using the `<name>:<verb>%<mode` as the service name also has the
 	// nice property the service name is attached to every region span
 	// and that can help in some queries.
-
-	resourceAttrs.PutStr(string(semconv.ServiceNameKey), tr2.process.qualifiedNames.exeVerbMode)
+	//
+	// However, if there are multiple receiver instances (such as one
+	// for developer machines and one for CI), the operator may want to
+	// partition the data downstream by receiver instance instead.  So
+	// let the `service_name` config setting override it.
+
+	serviceName := tr2.process.qualifiedNames.exeVerbMode
+	if tr2.rcvr_base != nil && len(tr2.rcvr_base.RcvrConfig.ServiceName) > 0 {
+		serviceName = tr2.rcvr_base.RcvrConfig.ServiceName
+	}
+	resourceAttrs.PutStr(string(semconv.ServiceNameKey), serviceName)
 
 	// [3] Use the Git version number for `service.version` (and not the
 	// version number of this component).
@@ -62,6 +74,42 @@ func (tr2 *trace2Dataset) insertResourceServiceFields(resourceAttrs pcommon.Map)
 	// (This is the complete SID with slashes.)
 
 	resourceAttrs.PutStr(string(semconv.ServiceInstanceIDKey), tr2.trace2SID)
+
+	// [5] Add any static resource attributes from the config, such as
+	// `deployment.region`, to tag the data from this receiver instance.
+	// These cannot collide with the above (see `resource_attributes`).
+
+	if tr2.rcvr_base != nil {
+		for k, v := range tr2.rcvr_base.RcvrConfig.ResourceAttributes {
+			resourceAttrs.PutStr(k, v)
+		}
+	}
+}
+
+// The resource attributes that we set ourselves, which cannot be
+// overwritten by `resource_attributes`.  (We also reserve all keys
+// with the `trace2.` prefix.)
+var reservedResourceAttributes []string = []string{
+	string(semconv.ServiceNamespaceKey),
+	string(semconv.ServiceNameKey),
+	string(semconv.ServiceVersionKey),
+	string(semconv.ServiceInstanceIDKey),
+	string(semconv.TelemetrySDKNameKey),
+	string(semconv.TelemetrySDKLanguageKey),
+	string(semconv.TelemetrySDKVersionKey),
+}
+
+func validateResourceAttributes(attrs map[string]string) error {
+	for k := range attrs {
+		if len(k) == 0 {
+			return fmt.Errorf("receivers.trace2receiver.resource_attributes has empty key")
+		}
+		if strings.HasPrefix(k, "trace2.") || containsString(reservedResourceAttributes, k) {
+			return fmt.Errorf("receivers.trace2receiver.resource_attributes cannot set reserved key '%s'", k)
+		}
+	}
+
+	return nil
 }
 
 func (tr2 *trace2Dataset) insertResourceTelemetrySDKFields(resourceAttrs pcommon.Map) {
@@ -89,6 +137,18 @@ func (tr2 *trace2Dataset) insertResourceInstrumentationScope(instScope pcommon.I
 	instScope.SetVersion(Trace2ReceiverVersion)
 }
 
+// Add the numeric components of the Git version, if we could parse it.
+func (tr2 *trace2Dataset) insertResourceVersionParts(resourceAttrs pcommon.Map) {
+	v := tr2.process.exeVersionParts
+	if !v.ok {
+		return
+	}
+
+	resourceAttrs.PutInt(string(Trace2CmdVersionMajor), v.major)
+	resourceAttrs.PutInt(string(Trace2CmdVersionMinor), v.minor)
+	resourceAttrs.PutInt(string(Trace2CmdVersionPatch), v.patch)
+}
+
 func (tr2 *trace2Dataset) ToTraces(dl FilterDetailLevel) ptrace.Traces {
 	pt := ptrace.NewTraces()
 
@@ -104,36 +164,134 @@ func (tr2 *trace2Dataset) ToTraces(dl FilterDetailLevel) ptrace.Traces {
 	// also put some of the above values into our Trace2 attribute bag.
 
 	resourceAttrs.PutStr(string(Trace2CmdVersion), tr2.process.exeVersion)
+	tr2.insertResourceVersionParts(resourceAttrs)
 	resourceAttrs.PutStr(string(Trace2CmdSid), tr2.trace2SID)
+	resourceAttrs.PutStr(string(Trace2ReceiverVersionKey), Trace2ReceiverVersion)
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.EmitReceiverEndpoint {
+		resourceAttrs.PutStr(string(Trace2ReceiverEndpoint),
+			tr2.rcvr_base.RcvrConfig.receiverEndpoint())
+	}
+
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.EmitReceiverUptime {
+		resourceAttrs.PutInt(string(Trace2ReceiverUptimeSec),
+			int64(time.Since(tr2.rcvr_base.startTime).Seconds()))
+	}
+
+	// Optionally omit short regions (and reparent the spans within them).
+	// This only matters if we are emitting region spans.
+	var rs *regionSuppression
+	if WantRegionAndThreadSpans(dl) && !WantUnabridgedOutput(dl) {
+		rs = tr2.computeRegionSuppression()
+	}
+
+	// Optionally omit the spans of ignored (helper) threads.
+	var ts *threadSuppression
+	if WantRegionAndThreadSpans(dl) {
+		ts = tr2.computeThreadSuppression()
+	}
+
+	// Optionally collapse the regions into one aggregate span for each
+	// (category, label) pair if there are too many of them.
+	var sum *regionSummary
+	if WantRegionAndThreadSpans(dl) && !WantUnabridgedOutput(dl) {
+		sum = tr2.computeRegionSummary(rs, ts)
+	}
 
 	// Create an OTEL span for the entire process (aka the main thread).
 	exeSpan := scopes.Spans().AppendEmpty()
 	emitProcessSpan(&exeSpan, tr2, dl)
+	rs.fixupSpan(&exeSpan, &tr2.process.mainThread.lifetime)
+	if n := rs.count(); n > 0 {
+		exeSpan.Attributes().PutInt(string(Trace2ProcessElidedRegionCount), n)
+	}
+	if sum != nil {
+		exeSpan.Attributes().PutBool(string(Trace2ProcessRegionsSummarized), true)
+	}
+
+	// Optionally create zero-duration spans for the processes that
+	// invoked the top-level Git command (such as bash and sshd).
+	if tr2.rcvr_base != nil && tr2.rcvr_base.RcvrConfig.AncestryAsSpans && WantProcessAncestry(dl) {
+		emitAncestorSpans(scopes.Spans(), &exeSpan, tr2, dl)
+	}
 
 	if WantRegionAndThreadSpans(dl) {
 		// Create an OTEL span for the lifetime of each non-main thread.
 		for _, th := range tr2.threads {
+			if ts.isOmitted(&th.lifetime) {
+				continue
+			}
 			thSpan := scopes.Spans().AppendEmpty()
-			emitNonMainThreadSpan(&thSpan, th, tr2)
+			emitNonMainThreadSpan(&thSpan, th, tr2, dl)
+			rs.fixupSpan(&thSpan, &th.lifetime)
 		}
 
 		// Create OTEL spans for all completed regions (from all threads).
-		for _, r := range tr2.completedRegions {
-			rSpan := scopes.Spans().AppendEmpty()
-			emitRegionSpan(&rSpan, r, tr2)
+		// Or the aggregates, if we are summarizing them.
+		if sum != nil {
+			for _, g := range sum.groups {
+				gSpan := scopes.Spans().AppendEmpty()
+				emitRegionGroupSpan(&gSpan, g, tr2, dl)
+			}
+		} else {
+			for _, r := range tr2.completedRegions {
+				if rs.isSuppressed(r) || ts.isOmitted(&r.lifetime) {
+					continue
+				}
+				rSpan := scopes.Spans().AppendEmpty()
+				emitRegionSpan(&rSpan, r, tr2, dl)
+				rs.fixupSpan(&rSpan, &r.lifetime)
+				ts.fixupSpan(&rSpan)
+			}
 		}
 	}
 
 	if WantChildSpans(dl) {
+		var minChild time.Duration
+		if tr2.rcvr_base != nil && !WantUnabridgedOutput(dl) {
+			minChild = time.Duration(tr2.rcvr_base.RcvrConfig.MinChildMs) * time.Millisecond
+		}
+
 		// Create an OTEL span for each child process that this process created.
+		// Optionally skip the quick ones to reduce clutter.
 		for _, child := range tr2.children {
+			if minChild > 0 && child.lifetime.endTime.Sub(child.lifetime.startTime) < minChild {
+				continue
+			}
 			childSpan := scopes.Spans().AppendEmpty()
-			emitChildSpan(&childSpan, child, tr2)
+			emitChildSpan(&childSpan, child, tr2, dl)
+			rs.fixupSpan(&childSpan, &child.lifetime)
+			ts.fixupSpan(&childSpan)
+			sum.fixupSpan(&childSpan)
 		}
 
 		for _, exec := range tr2.exec {
 			execSpan := scopes.Spans().AppendEmpty()
-			emitExecSpan(&execSpan, exec, tr2)
+			emitExecSpan(&execSpan, exec, tr2, dl)
+			rs.fixupSpan(&execSpan, &exec.lifetime)
+			ts.fixupSpan(&execSpan)
+			sum.fixupSpan(&execSpan)
+		}
+	}
+
+	// Clamp any spans where the end precedes the start (because of
+	// clock skew between threads or out of order events) to zero
+	// duration, since some backends reject them.
+	if tr2.rcvr_base == nil || !tr2.rcvr_base.RcvrConfig.AllowNegativeDurations {
+		spans := scopes.Spans()
+		for k := 0; k < spans.Len(); k++ {
+			span := spans.At(k)
+			clampNegativeDuration(&span)
+		}
+	}
+
+	// Optionally truncate very long attribute values (such as large
+	// `data_json` values) so that we don't exceed backend limits.
+	if maxBytes := tr2.maxAttributeBytes(dl); maxBytes > 0 {
+		spans := scopes.Spans()
+		for k := 0; k < spans.Len(); k++ {
+			span := spans.At(k)
+			truncateSpanAttributes(&span, maxBytes)
 		}
 	}
 
@@ -175,21 +333,82 @@ func (tr2 *trace2Dataset) ToTraces(dl FilterDetailLevel) ptrace.Traces {
 // Populate the span with the basic essential values
 // required by OTEL.  This includes the OTLP TraceID,
 // SpanIDs, and timestamps.
-func emitSpanEssentials(span *ptrace.Span, r *TrSpanEssentials, tr2 *trace2Dataset) {
+func emitSpanEssentials(span *ptrace.Span, r *TrSpanEssentials, tr2 *trace2Dataset, dl FilterDetailLevel) {
+
+	maxLen := 0
+	if tr2.rcvr_base != nil && !WantUnabridgedOutput(dl) {
+		maxLen = tr2.rcvr_base.RcvrConfig.MaxDisplayNameLen
+	}
+	name, truncated := truncateDisplayName(r.displayName, maxLen)
+	span.SetName(name)
+	if truncated {
+		span.Attributes().PutStr(string(Trace2SpanFullName), r.displayName)
+	}
 
-	span.SetName(r.displayName)
-	span.SetStartTimestamp(pcommon.NewTimestampFromTime(r.startTime))
-	span.SetEndTimestamp(pcommon.NewTimestampFromTime(r.endTime))
+	// Optionally round both endpoints to a coarser resolution.
+	var resolution time.Duration
+	if tr2.rcvr_base != nil {
+		resolution = tr2.rcvr_base.RcvrConfig.timeResolution
+	}
+	span.SetStartTimestamp(pcommon.NewTimestampFromTime(roundSpanTime(r.startTime, resolution)))
+	span.SetEndTimestamp(pcommon.NewTimestampFromTime(roundSpanTime(r.endTime, resolution)))
 	span.SetKind(ptrace.SpanKindInternal)
 
 	span.SetSpanID(r.selfSpanID)
 	span.SetParentSpanID(r.parentSpanID)
 
 	span.SetTraceID(tr2.otelTraceID)
+
+	// Put the shadow comparison on every span, so that it is easy to
+	// see which spans a ruleset change would add or remove.
+	if len(tr2.filterShadowDetail) > 0 {
+		span.Attributes().PutStr(string(Trace2FilterDetail), tr2.filterDetail)
+		span.Attributes().PutStr(string(Trace2FilterShadowDetail), tr2.filterShadowDetail)
+	}
+}
+
+// If the span ends before it starts, make it a zero-duration span
+// and mark it.
+func clampNegativeDuration(span *ptrace.Span) {
+	if span.EndTimestamp() >= span.StartTimestamp() {
+		return
+	}
+
+	span.SetEndTimestamp(span.StartTimestamp())
+	span.Attributes().PutBool(string(Trace2SpanClockSkew), true)
+}
+
+// Round a span time to the configured resolution.  A resolution
+// of zero (or 1ns) leaves it unchanged.
+func roundSpanTime(t time.Time, resolution time.Duration) time.Time {
+	if resolution <= time.Nanosecond {
+		return t
+	}
+	return t.Round(resolution)
+}
+
+// The marker appended to a truncated display name.
+const displayNameEllipsis string = "..."
+
+// Truncate the display name to at most `maxLen` characters (including
+// the ellipsis marker) if it is longer than that.  A `maxLen` of zero
+// means no limit.
+func truncateDisplayName(name string, maxLen int) (string, bool) {
+	if maxLen <= 0 || utf8.RuneCountInString(name) <= maxLen {
+		return name, false
+	}
+
+	keep := maxLen - len(displayNameEllipsis)
+	if keep < 0 {
+		keep = 0
+	}
+
+	runes := []rune(name)
+	return string(runes[:keep]) + displayNameEllipsis, true
 }
 
 func emitProcessSpan(span *ptrace.Span, tr2 *trace2Dataset, dl FilterDetailLevel) {
-	emitSpanEssentials(span, &tr2.process.mainThread.lifetime, tr2)
+	emitSpanEssentials(span, &tr2.process.mainThread.lifetime, tr2, dl)
 	span.SetKind(ptrace.SpanKindServer)
 
 	// TODO Should we set "SpanStatus" based upon the exit code of the process?
@@ -207,25 +426,97 @@ func emitProcessSpan(span *ptrace.Span, tr2 *trace2Dataset, dl FilterDetailLevel
 	for k, v := range tr2.pii {
 		sm.PutStr(k, v)
 	}
+	if tr2.peerPid > 0 {
+		sm.PutInt(string(Trace2CmdPeerPid), tr2.peerPid)
+	}
 
 	sm.PutStr(string(Trace2CmdName), tr2.process.qualifiedNames.exe)
 	sm.PutStr(string(Trace2CmdNameVerb), tr2.process.qualifiedNames.exeVerb)
 	sm.PutStr(string(Trace2CmdNameVerbMode), tr2.process.qualifiedNames.exeVerbMode)
+	if len(tr2.process.operation) > 0 {
+		sm.PutStr(string(Trace2CmdOperation), tr2.process.operation)
+	}
 	sm.PutStr(string(Trace2CmdHierarchy), tr2.process.cmdHierarchy)
+	sm.PutInt(string(Trace2CmdSidDepth), int64(tr2.process.sidDepth))
 	sm.PutStr(string(Trace2CmdExitCode), fmt.Sprintf("%d", tr2.process.exeExitCode))
+	sm.PutBool(string(Trace2CmdCleanExit), tr2.process.cleanExit)
+	if tr2.process.incomplete {
+		sm.PutBool(string(Trace2CmdIncomplete), true)
+	}
+	if tr2.sampleRate > 0 {
+		sm.PutInt(string(Trace2CmdSampleRate), int64(tr2.sampleRate))
+	}
+	if tr2.truncatedRegions {
+		sm.PutBool(string(Trace2CmdTruncatedRegions), true)
+	}
+	if tr2.truncatedChildren {
+		sm.PutBool(string(Trace2CmdTruncatedChildren), true)
+	}
+	if tr2.truncatedThreads {
+		sm.PutBool(string(Trace2CmdTruncatedThreads), true)
+	}
+	if tr2.process.sawSignal {
+		sm.PutInt(string(Trace2CmdSignal), tr2.process.signo)
+		sm.PutStr(string(Trace2CmdSignalName), signalName(tr2.process.signo))
+	}
+	sm.PutBool(string(Trace2CmdUsedFSMonitor), tr2.process.usedFSMonitor)
+	if len(tr2.process.repoContext) > 0 {
+		sm.PutStr(string(Trace2CmdRepoContext), tr2.process.repoContext)
+	}
+	if tr2.process.slow {
+		sm.PutBool(string(Trace2CmdSlow), true)
+	}
+	sm.PutInt(string(Trace2ProcessChildCount), tr2.process.childCount)
+	sm.PutDouble(string(Trace2ProcessChildTotalSec), tr2.process.childTotalTime.Seconds())
+	sm.PutInt(string(Trace2ProcessHookCount), tr2.process.hookCount)
+	sm.PutInt(string(Trace2ProcessCredCount), tr2.process.credCount)
+	if len(tr2.process.outcome) > 0 {
+		sm.PutStr(string(Trace2CmdOutcome), tr2.process.outcome)
+	}
 
+	ag := tr2.attributeGroups
+
+	if len(tr2.process.cmdArgv) > 0 && ag.want(attributeGroupArgv, true) {
+		putArgvAttribute(sm, string(Trace2CmdArgv), tr2.process.cmdArgv, tr2.maxAttributeBytes(dl))
+	}
 	if len(tr2.process.cmdArgv) > 0 {
-		jargs, _ := json.Marshal(tr2.process.cmdArgv)
-		sm.PutStr(string(Trace2CmdArgv), string(jargs))
+		sm.PutInt(string(Trace2CmdArgc), int64(len(tr2.process.cmdArgv)))
+		sm.PutStr(string(Trace2CmdArgvShapeHash), tr2.process.argvShapeHash)
+	}
+	sm.PutStr(string(Trace2CmdFingerprint), tr2.process.fingerprint)
+
+	if tr2.process.haveStartupDelay {
+		sm.PutDouble(string(Trace2CmdStartupDelaySec), tr2.process.startupDelay.Seconds())
+	}
+
+	for k, v := range tr2.process.wellKnownData {
+		sm.PutInt(k, v)
+	}
+	for k, v := range tr2.process.wellKnownRates {
+		sm.PutDouble(k, v)
+	}
+
+	if tr2.process.rusage.haveMaxRss {
+		sm.PutInt(string(Trace2ProcessMaxRss), tr2.process.rusage.maxRss)
+	}
+	if tr2.process.rusage.haveUserTimeSec {
+		sm.PutDouble(string(Trace2ProcessUserTimeSec), tr2.process.rusage.userTimeSec)
+	}
+	if tr2.process.rusage.haveSystemTimeSec {
+		sm.PutDouble(string(Trace2ProcessSystemTimeSec), tr2.process.rusage.systemTimeSec)
 	}
 
-	if WantProcessAncestry(dl) {
+	if ag.want(attributeGroupAncestry, WantProcessAncestry(dl)) {
 		if len(tr2.process.cmdAncestry) > 0 {
 			jargs, _ := json.Marshal(tr2.process.cmdAncestry)
 			sm.PutStr(string(Trace2CmdAncestry), string(jargs))
 		}
 	}
 
+	if len(tr2.process.cmdAliasKey) > 0 {
+		sm.PutBool(string(Trace2CmdIsAlias), true)
+	}
+
 	if WantProcessAliases(dl) {
 		if len(tr2.process.cmdAliasKey) > 0 {
 			sm.PutStr(string(Trace2CmdAliasKey), tr2.process.cmdAliasKey)
@@ -237,30 +528,99 @@ func emitProcessSpan(span *ptrace.Span, tr2 *trace2Dataset, dl FilterDetailLevel
 		}
 	}
 
+	if WantProcessExitEvents(dl) {
+		if !tr2.process.exitTime.IsZero() {
+			sm.PutInt(string(Trace2CmdExitEventCode), tr2.process.exitCode)
+			sm.PutStr(string(Trace2CmdExitEventTime), tr2.process.exitTime.Format(time.RFC3339Nano))
+		}
+		if !tr2.process.atexitTime.IsZero() {
+			sm.PutInt(string(Trace2CmdAtexitEventCode), tr2.process.atexitCode)
+			sm.PutStr(string(Trace2CmdAtexitEventTime), tr2.process.atexitTime.Format(time.RFC3339Nano))
+		}
+		if !tr2.process.exitTime.IsZero() && !tr2.process.atexitTime.IsZero() {
+			sm.PutDouble(string(Trace2CmdAtexitOverheadSec),
+				tr2.process.atexitTime.Sub(tr2.process.exitTime).Seconds())
+		}
+	}
+
+	if WantDatasetEventTimes(dl) && !tr2.firstEventTime.IsZero() {
+		sm.PutStr(string(Trace2DatasetFirstEventTime), tr2.firstEventTime.Format(time.RFC3339Nano))
+		sm.PutStr(string(Trace2DatasetLastEventTime), tr2.lastEventTime.Format(time.RFC3339Nano))
+	}
+
 	if len(tr2.process.exeErrorFmt) > 0 {
 		sm.PutStr(string(Trace2CmdErrFmt), tr2.process.exeErrorFmt)
 	}
 	if len(tr2.process.exeErrorMsg) > 0 {
 		sm.PutStr(string(Trace2CmdErrMsg), tr2.process.exeErrorMsg)
 	}
+	if len(tr2.process.exeErrors) > 0 {
+		errs := sm.PutEmptySlice(string(Trace2CmdErrMessages))
+		for _, e := range tr2.process.exeErrors {
+			em := errs.AppendEmpty().SetEmptyMap()
+			em.PutStr("fmt", e.Fmt)
+			em.PutStr("msg", e.Msg)
+		}
+	}
+
+	if tr2.rcvr_base != nil && len(tr2.rcvr_base.RcvrConfig.CIIdParam) > 0 {
+		if id, ok := tr2.process.paramSetValues[tr2.rcvr_base.RcvrConfig.CIIdParam]; ok && len(id) > 0 {
+			sm.PutStr(string(Trace2CIBuildId), id)
+		}
+	}
+
+	if len(tr2.repoNickname) > 0 {
+		sm.PutStr(string(Trace2RepoNickname), tr2.repoNickname)
+	}
 
-	if tr2.process.repoSet != nil && len(tr2.process.repoSet) > 0 {
-		jargs, _ := json.Marshal(tr2.process.repoSet)
+	if tr2.process.repoSet != nil && len(tr2.process.repoSet) > 0 && ag.want(attributeGroupRepos, true) {
+		repoSet := tr2.process.repoSet
+		if len(tr2.worktreeScrubRules) > 0 {
+			repoSet = make(map[int64]string, len(tr2.process.repoSet))
+			for id, wt := range tr2.process.repoSet {
+				repoSet[id] = scrubWorktreePath(wt, tr2.worktreeScrubRules)
+			}
+		}
+		jargs, _ := json.Marshal(repoSet)
 		sm.PutStr(string(Trace2RepoSet), string(jargs))
 	}
 
-	if tr2.process.paramSetValues != nil && len(tr2.process.paramSetValues) > 0 {
-		jargs, _ := json.Marshal(tr2.process.paramSetValues)
-		sm.PutStr(string(Trace2ParamSet), string(jargs))
+	if tr2.process.paramSetValues != nil && len(tr2.process.paramSetValues) > 0 && ag.want(attributeGroupParams, true) {
+		// Strip out any config keys that might contain secrets.  (We
+		// still use the complete set internally for filtering.)
+		var fs *FilterSettings
+		if tr2.rcvr_base != nil {
+			fs = tr2.rcvr_base.RcvrConfig.getFilterSettings()
+		}
+		params := makeEmittableParamSet(fs, tr2.process.paramSetValues)
+		if len(params) > 0 {
+			jargs, _ := json.Marshal(params)
+			sm.PutStr(string(Trace2ParamSet), string(jargs))
+		}
+
+		if WantParamScopes(dl) {
+			scopes := make(map[string]string)
+			for k := range params {
+				if scope, ok := tr2.process.paramSetScopes[k]; ok {
+					scopes[k] = scope
+				}
+			}
+			if len(scopes) > 0 {
+				jargs, _ := json.Marshal(scopes)
+				sm.PutStr(string(Trace2ParamScopes), string(jargs))
+			}
+		}
 	}
 
-	if WantMainThreadTimersAndCounters(dl) {
-		// Emit per-thread counters and timers for the main thread because
-		// it is not handled by `emitNonMainThreadSpan()`.
+	// Emit per-thread counters and timers for the main thread because
+	// it is not handled by `emitNonMainThreadSpan()`.
+	if ag.want(attributeGroupTimers, WantMainThreadTimersAndCounters(dl)) {
 		if tr2.process.mainThread.timers != nil {
 			jargs, _ := json.Marshal(tr2.process.mainThread.timers)
 			sm.PutStr(string(Trace2ThreadTimers), string(jargs))
 		}
+	}
+	if ag.want(attributeGroupCounters, WantMainThreadTimersAndCounters(dl)) {
 		if tr2.process.mainThread.counters != nil {
 			jargs, _ := json.Marshal(tr2.process.mainThread.counters)
 			sm.PutStr(string(Trace2ThreadCounters), string(jargs))
@@ -268,23 +628,77 @@ func emitProcessSpan(span *ptrace.Span, tr2 *trace2Dataset, dl FilterDetailLevel
 	}
 
 	if WantProcessTimersCountersAndData(dl) {
+		sm.PutInt(string(Trace2CmdRegionCategoryCount), tr2.process.regionCategoryCount)
+		sm.PutInt(string(Trace2CmdRegionLabelCount), tr2.process.regionLabelCount)
+	}
+
+	if ag.want(attributeGroupData, WantProcessTimersCountersAndData(dl)) {
 		if tr2.process.dataValues != nil && len(tr2.process.dataValues) > 0 {
 			jargs, _ := json.Marshal(tr2.process.dataValues)
 			sm.PutStr(string(Trace2ProcessData), string(jargs))
 		}
+		if tr2.process.orphanedDataCount > 0 {
+			sm.PutInt(string(Trace2ProcessDataOrphanedCount), tr2.process.orphanedDataCount)
+		}
+	}
+
+	if ag.want(attributeGroupTimers, WantProcessTimersCountersAndData(dl)) {
 		if tr2.process.timers != nil {
 			jargs, _ := json.Marshal(tr2.process.timers)
 			sm.PutStr(string(Trace2ProcessTimers), string(jargs))
 		}
+	}
+
+	if ag.want(attributeGroupCounters, WantProcessTimersCountersAndData(dl)) {
 		if tr2.process.counters != nil {
 			jargs, _ := json.Marshal(tr2.process.counters)
 			sm.PutStr(string(Trace2ProcessCounters), string(jargs))
 		}
+		if len(tr2.process.counterTotals) > 0 {
+			jargs, _ := json.Marshal(tr2.process.counterTotals)
+			sm.PutStr(string(Trace2CmdCounterTotalsByCategory), string(jargs))
+		}
+	}
+}
+
+// Synthesize a chain of zero-duration spans for the `cmd_ancestry`
+// and make the process span a child of the immediate parent.  We only
+// do this for top-level Git commands, since a child Git command
+// already has a parent span (and its ancestry includes that process).
+func emitAncestorSpans(spans ptrace.SpanSlice, exeSpan *ptrace.Span, tr2 *trace2Dataset, dl FilterDetailLevel) {
+	if len(tr2.process.cmdAncestry) == 0 || tr2.process.mainThread.lifetime.parentSpanID != zeroSpanID {
+		return
+	}
+
+	exeSpan.SetParentSpanID(extractAncestorSpanID(tr2.trace2SID, 0))
+
+	for k, a := range tr2.process.cmdAncestry {
+		name, ok := a.(string)
+		if !ok {
+			name = fmt.Sprintf("%v", a)
+		}
+
+		se := TrSpanEssentials{
+			displayName: name,
+			startTime:   tr2.process.mainThread.lifetime.startTime,
+			endTime:     tr2.process.mainThread.lifetime.startTime,
+			selfSpanID:  extractAncestorSpanID(tr2.trace2SID, k),
+		}
+		if k+1 < len(tr2.process.cmdAncestry) {
+			se.parentSpanID = extractAncestorSpanID(tr2.trace2SID, k+1)
+		}
+
+		span := spans.AppendEmpty()
+		emitSpanEssentials(&span, &se, tr2, dl)
+
+		sm := span.Attributes()
+		sm.PutStr(string(Trace2SpanType), "ancestor")
+		sm.PutInt(string(Trace2AncestorDepth), int64(k))
 	}
 }
 
-func emitNonMainThreadSpan(span *ptrace.Span, th *TrThread, tr2 *trace2Dataset) {
-	emitSpanEssentials(span, &th.lifetime, tr2)
+func emitNonMainThreadSpan(span *ptrace.Span, th *TrThread, tr2 *trace2Dataset, dl FilterDetailLevel) {
+	emitSpanEssentials(span, &th.lifetime, tr2, dl)
 
 	sm := span.Attributes()
 	sm.PutStr(string(Trace2SpanType), "thread")
@@ -300,8 +714,8 @@ func emitNonMainThreadSpan(span *ptrace.Span, th *TrThread, tr2 *trace2Dataset)
 	}
 }
 
-func emitRegionSpan(span *ptrace.Span, r *TrRegion, tr2 *trace2Dataset) {
-	emitSpanEssentials(span, &r.lifetime, tr2)
+func emitRegionSpan(span *ptrace.Span, r *TrRegion, tr2 *trace2Dataset, dl FilterDetailLevel) {
+	emitSpanEssentials(span, &r.lifetime, tr2, dl)
 
 	sm := span.Attributes()
 	sm.PutStr(string(Trace2SpanType), "region")
@@ -317,17 +731,21 @@ func emitRegionSpan(span *ptrace.Span, r *TrRegion, tr2 *trace2Dataset) {
 		jargs, _ := json.Marshal(r.dataValues)
 		sm.PutStr(string(Trace2RegionData), string(jargs))
 	}
+
+	if WantRegionSourceLocation(dl) && len(r.file) > 0 {
+		sm.PutStr(string(Trace2RegionFile), r.file)
+		sm.PutInt(string(Trace2RegionLine), r.line)
+	}
 }
 
-func emitChildSpan(span *ptrace.Span, child *TrChild, tr2 *trace2Dataset) {
-	emitSpanEssentials(span, &child.lifetime, tr2)
+func emitChildSpan(span *ptrace.Span, child *TrChild, tr2 *trace2Dataset, dl FilterDetailLevel) {
+	emitSpanEssentials(span, &child.lifetime, tr2, dl)
 
 	sm := span.Attributes()
 	sm.PutStr(string(Trace2SpanType), "child")
 
 	if len(child.argv) > 0 {
-		jargs, _ := json.Marshal(child.argv)
-		sm.PutStr(string(Trace2ChildArgv), string(jargs))
+		putArgvAttribute(sm, string(Trace2ChildArgv), child.argv, tr2.maxAttributeBytes(dl))
 	}
 
 	// Azure automatically treats integer attributes as "customMeasurements"
@@ -346,17 +764,27 @@ func emitChildSpan(span *ptrace.Span, child *TrChild, tr2 *trace2Dataset) {
 	if child.class == "hook" {
 		sm.PutStr(string(Trace2ChildHookName), child.hookname)
 	}
+	if child.class == "cred" {
+		sm.PutStr(string(Trace2ChildCredHelper), child.credHelper)
+		sm.PutStr(string(Trace2ChildCredOp), child.credOp)
+	}
+	if d, ok := child.credBlockingTime(); ok {
+		sm.PutDouble(string(Trace2ChildBlockingSec), d.Seconds())
+	}
+
+	if len(child.cd) > 0 {
+		sm.PutStr(string(Trace2ChildCd), child.cd)
+	}
 }
 
-func emitExecSpan(span *ptrace.Span, e *TrExec, tr2 *trace2Dataset) {
-	emitSpanEssentials(span, &e.lifetime, tr2)
+func emitExecSpan(span *ptrace.Span, e *TrExec, tr2 *trace2Dataset, dl FilterDetailLevel) {
+	emitSpanEssentials(span, &e.lifetime, tr2, dl)
 
 	sm := span.Attributes()
 	sm.PutStr(string(Trace2SpanType), "exec")
 
 	if len(e.argv) > 0 {
-		jargs, _ := json.Marshal(e.argv)
-		sm.PutStr(string(Trace2ExecArgv), string(jargs))
+		putArgvAttribute(sm, string(Trace2ExecArgv), e.argv, tr2.maxAttributeBytes(dl))
 	}
 
 	sm.PutStr(string(Trace2ExecExe), e.exe)
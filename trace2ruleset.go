@@ -15,40 +15,44 @@ func debugDescribe(base string, lval string, rval string) string {
 func (fs *FilterSettings) lookupRulesetNameByRulesetKey(params map[string]string, debug_in string) (rs_dl_name string, ok bool, debug_out string) {
 	debug_out = debug_in
 
-	if len(fs.Keynames.RulesetKey) == 0 {
-		return "", false, debug_out
-	}
-
-	rs_dl_name, ok = params[fs.Keynames.RulesetKey]
-	if !ok || len(rs_dl_name) == 0 {
+	key, rs_dl_name, ok := fs.Keynames.RulesetKey.lookup(params)
+	if !ok {
 		return "", false, debug_out
 	}
 
 	// Acknowledge that we saw the ruleset key in the request and will try to use it.
-	debug_out = debugDescribe(debug_out, "rskey", rs_dl_name)
+	debug_out = debugDescribe(debug_out, debugKeyname("rskey", key, fs.Keynames.RulesetKey), rs_dl_name)
 
 	return rs_dl_name, true, debug_out
 }
 
 // Lookup ruleset or detail level name based upon the nickname (if the
 // key is defined in the filter settings and if the worktree sent
-// a def_param for it).
+// a def_param for it).  We try an exact match first and then the
+// glob patterns in the nickname table.
 func (fs *FilterSettings) lookupRulesetNameByNickname(params map[string]string, debug_in string) (rs_dl_name string, ok bool, debug_out string) {
 	debug_out = debug_in
 
-	if len(fs.Keynames.NicknameKey) == 0 {
-		return "", false, debug_out
-	}
-
-	nnvalue, ok := params[fs.Keynames.NicknameKey]
-	if !ok || len(nnvalue) == 0 {
+	key, nnvalue, ok := fs.Keynames.NicknameKey.lookup(params)
+	if !ok {
 		return "", false, debug_out
 	}
 
 	// Acknowledge that we saw the nickname in the request.
-	debug_out = debugDescribe(debug_out, "nickname", nnvalue)
+	debug_out = debugDescribe(debug_out, debugKeyname("nickname", key, fs.Keynames.NicknameKey), nnvalue)
 
 	rs_dl_name, ok = fs.Nicknames[nnvalue]
+	if !ok {
+		// Otherwise, try the nickname glob patterns and acknowledge
+		// which one matched.
+		var pattern string
+		pattern, ok = fs.findNicknameGlob(nnvalue)
+		if ok {
+			debug_out = debugDescribe(debug_out, nnvalue, pattern)
+			nnvalue = pattern
+			rs_dl_name = fs.Nicknames[pattern]
+		}
+	}
 	if !ok || len(rs_dl_name) == 0 {
 		// Acknowledge that the nickname was not valid.
 		debug_out := debugDescribe(debug_out, nnvalue, "UNKNOWN")
@@ -61,6 +65,28 @@ func (fs *FilterSettings) lookupRulesetNameByNickname(params map[string]string,
 	return rs_dl_name, true, debug_out
 }
 
+// Get the raw repo nickname (if the key is defined in the filter
+// settings and if the worktree sent a def_param for it), whether or
+// not it maps to a ruleset.  The filter settings are optional, so
+// `fs` may be nil.
+func (fs *FilterSettings) lookupRepoNickname(params map[string]string) string {
+	if fs == nil {
+		return ""
+	}
+
+	_, nnvalue, _ := fs.Keynames.NicknameKey.lookup(params)
+	return nnvalue
+}
+
+// When more than one key name is configured, include the one that
+// matched in the debug description, such as "rskey(otel.trace2.ruleset)".
+func debugKeyname(label string, key string, kl FilterKeynameList) string {
+	if len(kl) <= 1 {
+		return label
+	}
+	return fmt.Sprintf("%s(%s)", label, key)
+}
+
 // Lookup the name of the default ruleset or detail level from
 // the global defaults section in the filter settings if it has one.
 func (fs *FilterSettings) lookupDefaultRulesetName(debug_in string) (rs_dl_name string, ok bool, debug_out string) {
@@ -76,6 +102,27 @@ func (fs *FilterSettings) lookupDefaultRulesetName(debug_in string) (rs_dl_name
 	return fs.Defaults.RulesetName, true, debug_out
 }
 
+// Return the custom ruleset definition used by a dataset, or nil if
+// it did not resolve to a custom ruleset (such as when it resolved
+// to a detail level).
+func (fs *FilterSettings) resolveRulesetDef(params map[string]string) *RulesetDefinition {
+	if fs == nil {
+		return nil
+	}
+
+	rs_dl_name, ok, _ := fs.lookupRulesetName(params, "")
+	if !ok {
+		return nil
+	}
+
+	rsdef, ok := fs.rulesetDefs[rs_dl_name]
+	if !ok {
+		return nil
+	}
+
+	return rsdef
+}
+
 // Determine whether a ruleset or detail level was requested.
 func (fs *FilterSettings) lookupRulesetName(params map[string]string, debug_in string) (rs_dl_name string, ok bool, debug_out string) {
 	debug_out = debug_in
@@ -107,19 +154,20 @@ func useBuiltinDefaultDetailLevel(debug_in string) (dl FilterDetailLevel, debug_
 
 // Use the ruleset default detail level.  (This was set to the global
 // builtin default detail level if it wasn't set in the ruleset YML.)
-func (rsdef *RulesetDefinition) useRulesetDefaultDetailLevel(debug_in string) (dl FilterDetailLevel, debug_out string) {
-	dl, _ = getDetailLevel(rsdef.Defaults.DetailLevelName)
+func (rsdef *RulesetDefinition) useRulesetDefaultDetailLevel(debug_in string) (dl FilterDetailLevel, rate int, debug_out string) {
+	dl, rate, _ = parseDetailLevel(rsdef.Defaults.DetailLevelName)
 	// Acknowledge that we will use the ruleset default for this command.
 	debug_out = debugDescribe(debug_in, "ruleset-default", rsdef.Defaults.DetailLevelName)
-	return dl, debug_out
+	return dl, rate, debug_out
 }
 
 // Lookup the detail level for a command using the CmdMap in this ruleset.
 //
-// We try: `<exe>:<verb>#<mode>`, `<exe>:<verb>`, and `<exe>` until we find
-// a match.  Then fallback to the ruleset default.  We assume that the CmdMap
-// only has detail level values (and not links to other custom rulesets), so
-// we won't get lookup cycles.
+// We try: `<exe>:<verb>#<mode>`, `<exe>:<verb>`, the wildcard forms (see
+// `commandWildcardKeys()`), and `<exe>` until we find a match.  Then fallback
+// to the ruleset default.  We assume that the CmdMap only has detail level
+// values (and not links to other custom rulesets), so we won't get lookup
+// cycles.
 func (rsdef *RulesetDefinition) lookupCommandDetailLevelName(qn QualifiedNames, debug_in string) (string, bool, string) {
 	// See if there is an entry in the CmdMap for this Git command.
 	dl_name, ok := rsdef.Commands[qn.exeVerbMode]
@@ -132,6 +180,13 @@ func (rsdef *RulesetDefinition) lookupCommandDetailLevelName(qn QualifiedNames,
 		return dl_name, true, debugDescribe(debug_in, qn.exeVerb, dl_name)
 	}
 
+	for _, k := range commandWildcardKeys(qn) {
+		dl_name, ok = rsdef.Commands[k]
+		if ok {
+			return dl_name, true, debugDescribe(debug_in, k, dl_name)
+		}
+	}
+
 	dl_name, ok = rsdef.Commands[qn.exe]
 	if ok {
 		return dl_name, true, debugDescribe(debug_in, qn.exe, dl_name)
@@ -140,26 +195,74 @@ func (rsdef *RulesetDefinition) lookupCommandDetailLevelName(qn QualifiedNames,
 	return "", false, debug_in
 }
 
+// Return the wildcard command keys that could match this command, with
+// the most specific first.  A `*` may be used for the whole verb and/or
+// mode part of the key:
+//
+//	`<exe>:<verb>#*`  any mode of this verb
+//	`<exe>:*#<mode>`  this mode of any verb
+//	`<exe>:*#*`       any verb with a mode
+//	`<exe>:*`         any verb
+func commandWildcardKeys(qn QualifiedNames) []string {
+	var keys []string
+
+	if len(qn.exeVerb) == len(qn.exe) {
+		// The command does not have a verb, so only the plain
+		// `<exe>` key can match it.
+		return nil
+	}
+
+	if len(qn.exeVerbMode) > len(qn.exeVerb) {
+		mode := qn.exeVerbMode[len(qn.exeVerb)+1:]
+		keys = append(keys,
+			qn.exeVerb+"#*",
+			qn.exe+":*#"+mode,
+			qn.exe+":*#*")
+	}
+
+	return append(keys, qn.exe+":*")
+}
+
 // Compute the net-net detail level that we should use for this Git command.
 func computeDetailLevel(fs *FilterSettings, params map[string]string,
 	qn QualifiedNames) (FilterDetailLevel, string) {
 
+	dl, _, debug := computeDetailLevelAndSampleRate(fs, params, qn)
+	return dl, debug
+}
+
+// Compute the net-net detail level and the sample rate that we should
+// use for this Git command.  The sample rate is zero unless the detail
+// level was given as a sampling directive, such as "dl:sample:10".
+func computeDetailLevelAndSampleRate(fs *FilterSettings, params map[string]string,
+	qn QualifiedNames) (FilterDetailLevel, int, string) {
+
 	if fs == nil {
 		// No filter-spec, assume global builtin default detail level.
-		return useBuiltinDefaultDetailLevel("")
+		dl, debug := useBuiltinDefaultDetailLevel("")
+		return dl, 0, debug
 	}
 
 	rs_dl_name, ok, debug := fs.lookupRulesetName(params, "")
 	if !ok {
 		// No ruleset or detail level, assume global builtin default detail level.
-		return useBuiltinDefaultDetailLevel(debug)
+		dl, debug := useBuiltinDefaultDetailLevel(debug)
+		return dl, 0, debug
 	}
 
+	return fs.resolveDetailLevel(rs_dl_name, qn, debug)
+}
+
+// Compute the detail level and sample rate for this Git command using the
+// named ruleset or detail level.
+func (fs *FilterSettings) resolveDetailLevel(rs_dl_name string, qn QualifiedNames,
+	debug string) (FilterDetailLevel, int, string) {
+
 	// If the name is a detail level rather than a named ruleset, then we use it
 	// as is (since we don't do per-command filtering for detail levels).
-	dl, err := getDetailLevel(rs_dl_name)
+	dl, rate, err := parseDetailLevel(rs_dl_name)
 	if err == nil {
-		return dl, debug
+		return dl, rate, debug
 	}
 
 	// Try to look it up as a custom ruleset.
@@ -170,7 +273,8 @@ func computeDetailLevel(fs *FilterSettings, params map[string]string,
 
 		// We do not have a ruleset with that name.  Silently assume the builtin
 		// default detail level.
-		return useBuiltinDefaultDetailLevel(debug)
+		dl, debug := useBuiltinDefaultDetailLevel(debug)
+		return dl, 0, debug
 	}
 
 	// Acknowledge that we are trying command-level filtering starting with
@@ -184,9 +288,9 @@ func computeDetailLevel(fs *FilterSettings, params map[string]string,
 		return rsdef.useRulesetDefaultDetailLevel(debug)
 	}
 
-	dl, err = getDetailLevel(dl_name)
+	dl, rate, err = parseDetailLevel(dl_name)
 	if err == nil {
-		return dl, debug
+		return dl, rate, debug
 	}
 
 	// We should not get here because we validated the spelling of all
@@ -195,5 +299,19 @@ func computeDetailLevel(fs *FilterSettings, params map[string]string,
 	dl, _ = getDetailLevel(DetailLevelDefaultName)
 	debug = debugDescribe(debug, "BACKSTOP", DetailLevelDefaultName)
 
-	return dl, debug
+	return dl, 0, debug
+}
+
+// Compute the detail level (and sample rate) that the shadow ruleset
+// would have selected for this Git command, if one is configured.  This
+// does not affect filtering; it lets us compare a candidate ruleset
+// against the primary one using production traffic.
+func computeShadowDetailLevel(fs *FilterSettings, qn QualifiedNames) (FilterDetailLevel, int, string, bool) {
+	if fs == nil || len(fs.ShadowRuleset) == 0 {
+		return DetailLevelUnset, 0, "", false
+	}
+
+	debug := debugDescribe("", "shadow-ruleset", fs.ShadowRuleset)
+	dl, rate, debug := fs.resolveDetailLevel(fs.ShadowRuleset, qn, debug)
+	return dl, rate, debug, true
 }
@@ -27,14 +27,106 @@ const (
 	// The complete command line args of the process.
 	Trace2CmdArgv = attribute.Key("trace2.cmd.argv")
 
+	// The number of command line args of the process (including
+	// `argv[0]`).  Unlike `trace2.cmd.argv`, this is always emitted.
+	//
+	// Type: int
+	Trace2CmdArgc = attribute.Key("trace2.cmd.argc")
+
+	// A stable hash of the command line args with the operand values
+	// redacted (keeping the flag names), so that commands with the
+	// same shape can be grouped without emitting the args themselves.
+	// This is always emitted.
+	//
+	// Type: string
+	Trace2CmdArgvShapeHash = attribute.Key("trace2.cmd.argv_shape_hash")
+
+	// A stable hash of the qualified `<exe>:<verb>#<mode>` name, the
+	// number of command line args, and the sorted set of flag names
+	// (with their values redacted).  This gives a single key to group
+	// commands with the same shape.
+	//
+	// Type: string
+	Trace2CmdFingerprint = attribute.Key("trace2.cmd.fingerprint")
+
 	// The version string of the process executable as reported in the
 	// Trace2 "version" event.
 	Trace2CmdVersion = attribute.Key("trace2.cmd.version")
 
+	// The numeric major, minor, and patch components of the version
+	// string (ignoring any vendor suffix, such as `.windows.1`).
+	// These are omitted if the version string cannot be parsed.
+	//
+	// Type: int
+	Trace2CmdVersionMajor = attribute.Key("trace2.cmd.version_major")
+	Trace2CmdVersionMinor = attribute.Key("trace2.cmd.version_minor")
+	Trace2CmdVersionPatch = attribute.Key("trace2.cmd.version_patch")
+
 	// The command's exit code.  Zero if it completed without error.
 	// If this process was signalled, this should be 128+signo.
 	Trace2CmdExitCode = attribute.Key("trace2.cmd.exit_code")
 
+	// Whether we saw an actual "exit", "atexit", or "signal" event.
+	// If false, the process was killed or crashed and the exit code
+	// (-1) and end time were synthesized by the receiver.
+	//
+	// Type: bool
+	Trace2CmdCleanExit = attribute.Key("trace2.cmd.clean_exit")
+
+	// Whether the data for the command is partial.  This is set if
+	// the receiver had to synthesize the end of any region, thread,
+	// or child span (or the process span itself) or the process was
+	// terminated by a signal.  This is omitted when false.
+	//
+	// Type: bool
+	Trace2CmdIncomplete = attribute.Key("trace2.cmd.incomplete")
+
+	// Whether the receiver stopped accumulating regions, child
+	// processes, or threads for the command because it hit the
+	// `max_regions`, `max_children`, or `max_threads` cap.  These
+	// are omitted when false.
+	//
+	// Type: bool
+	Trace2CmdTruncatedRegions  = attribute.Key("trace2.cmd.truncated_regions")
+	Trace2CmdTruncatedChildren = attribute.Key("trace2.cmd.truncated_children")
+	Trace2CmdTruncatedThreads  = attribute.Key("trace2.cmd.truncated_threads")
+
+	// The N when the command was kept by a "dl:sample:<N>" sampling
+	// directive.  Each kept command represents roughly N commands, so
+	// backends can use this to scale counts.  This is omitted when the
+	// command was not sampled.
+	//
+	// Type: int
+	Trace2CmdSampleRate = attribute.Key("trace2.cmd.sample_rate")
+
+	// The signal number and symbolic name (such as "SIGPIPE") when
+	// the process was terminated by a signal.  The name is "SIG?" if
+	// we do not recognize the signal number.
+	//
+	// Type: int and string
+	Trace2CmdSignal     = attribute.Key("trace2.cmd.signal")
+	Trace2CmdSignalName = attribute.Key("trace2.cmd.signal_name")
+
+	// The normalized outcome of the command, such as "ok", "error",
+	// "fatal", "usage", "signalled", or "crash".
+	//
+	// Type: string
+	Trace2CmdOutcome = attribute.Key("trace2.cmd.outcome")
+
+	// The exit codes and times reported separately by the "exit"
+	// and "atexit" events.  Only emitted at `dl:verbose`.
+	Trace2CmdExitEventCode   = attribute.Key("trace2.cmd.exit.code")
+	Trace2CmdExitEventTime   = attribute.Key("trace2.cmd.exit.time")
+	Trace2CmdAtexitEventCode = attribute.Key("trace2.cmd.atexit.code")
+	Trace2CmdAtexitEventTime = attribute.Key("trace2.cmd.atexit.time")
+
+	// The time in seconds between the "exit" and "atexit" events,
+	// which is the cost of the atexit handlers.  Only emitted at
+	// `dl:verbose` when we saw both events.
+	//
+	// Type: float
+	Trace2CmdAtexitOverheadSec = attribute.Key("trace2.cmd.atexit_overhead_sec")
+
 	// The base filename of the process executable (with the pathname and
 	// `.exe` suffix stripped off), for example `git` or `git-remote-https`.
 	Trace2CmdName = attribute.Key("trace2.cmd.name")
@@ -52,19 +144,44 @@ const (
 	// commands that do not have a mode, this should just be the verb.
 	Trace2CmdNameVerbMode = attribute.Key("trace2.cmd.name_verb_mode")
 
+	// A friendly name for the verb from the `operation_names` filter
+	// setting, such as `receive-objects` for `index-pack`.  This is
+	// the verb itself if it is not mapped.
+	Trace2CmdOperation = attribute.Key("trace2.cmd.operation")
+
 	// The verb hierarchy for the command as reported by Git itself.
 	// For example when `git index-pack` is launched by `git fetch`,
 	// the child process will report a verb of `index-pack` and a
 	// hierarchy of `fetch/index-pack`.
 	Trace2CmdHierarchy = attribute.Key("trace2.cmd.hierarchy")
 
+	// The depth of the command in the Git process tree, from the
+	// number of `/` separated parts in the Trace2 SID.  This is 0
+	// for a top-level Git command and greater than 0 for a child
+	// Git process (such as one started by `git submodule foreach`).
+	//
+	// Type: int
+	Trace2CmdSidDepth = attribute.Key("trace2.cmd.sid_depth")
+
 	// The format string of one error message from the command.
 	Trace2CmdErrFmt = attribute.Key("trace2.cmd.error.format")
 	Trace2CmdErrMsg = attribute.Key("trace2.cmd.error.message")
 
+	// The first few error messages from the command.  This is limited
+	// by the `max_error_messages` config setting.
+	//
+	// Type: array of {"fmt": string, "msg": string}
+	Trace2CmdErrMessages = attribute.Key("trace2.cmd.error.messages")
+
 	Trace2CmdAliasKey   = attribute.Key("trace2.cmd.alias.key")
 	Trace2CmdAliasValue = attribute.Key("trace2.cmd.alias.value")
 
+	// Set on the process span (at all detail levels) when the command
+	// was run via an alias.  It is omitted otherwise.
+	//
+	// Type: bool
+	Trace2CmdIsAlias = attribute.Key("trace2.cmd.is_alias")
+
 	// Optional process hierarchy that invoked this Git command.
 	// Usually contains things like "bash" and "sshd".  This data
 	// is read from "/proc" on Linux, for example.  It may be
@@ -74,12 +191,72 @@ const (
 	// Type: array of string
 	Trace2CmdAncestry = attribute.Key("trace2.cmd.ancestry")
 
+	// The position of a synthesized ancestor span in the
+	// `cmd_ancestry`, where 0 is the immediate parent of the Git
+	// command.  See `ancestry_as_spans`.
+	//
+	// Type: int
+	Trace2AncestorDepth = attribute.Key("trace2.ancestor.depth")
+
+	// Whether the command used the filesystem monitor.  This is
+	// inferred from the configured `fsmonitor_indicators`.
+	//
+	// Type: bool
+	Trace2CmdUsedFSMonitor = attribute.Key("trace2.cmd.used_fsmonitor")
+
+	// Whether the command ran in a worktree or outside of any repo
+	// ("worktree" or "none").  This is inferred from the "def_repo"
+	// events and the verb and is omitted if we cannot tell (such as
+	// for commands in a bare repo).
+	//
+	// Type: string
+	Trace2CmdRepoContext = attribute.Key("trace2.cmd.repo_context")
+
+	// Set on the process span when the command ran longer than the
+	// `slow_threshold_sec` config setting.  It is omitted otherwise.
+	//
+	// Type: bool
+	Trace2CmdSlow = attribute.Key("trace2.cmd.slow")
+
+	// The number of distinct region categories and distinct
+	// (category, label) pairs used by the command.
+	//
+	// Type: int
+	Trace2CmdRegionCategoryCount = attribute.Key("trace2.cmd.region_category_count")
+	Trace2CmdRegionLabelCount    = attribute.Key("trace2.cmd.region_label_count")
+
+	// The time in seconds between the start of the command and the
+	// start of its earliest region.  This is omitted when there are
+	// no regions.
+	//
+	// Type: float
+	Trace2CmdStartupDelaySec = attribute.Key("trace2.cmd.startup_delay_sec")
+
+	// The sum of the process-level counter values within each
+	// counter category.
+	//
+	// Type: JSON map[string]int64
+	Trace2CmdCounterTotalsByCategory = attribute.Key("trace2.cmd.counter_totals_by_category")
+
 	// Trace2 classification of the span.  For example: "process",
 	// "thread", "child", or "region".
 	//
 	// Type: string
 	Trace2SpanType = attribute.Key("trace2.span.type")
 
+	// The full display name of the span when the span name was
+	// truncated because of `max_display_name_len`.
+	//
+	// Type: string
+	Trace2SpanFullName = attribute.Key("trace2.span.full_name")
+
+	// Whether the end time of the span preceded the start time
+	// (because of clock skew or out of order events) and the span
+	// was clamped to zero duration.  This is omitted when false.
+	//
+	// Type: bool
+	Trace2SpanClockSkew = attribute.Key("trace2.span.clock_skew")
+
 	Trace2ChildPid        = attribute.Key("trace2.child.pid")
 	Trace2ChildExitCode   = attribute.Key("trace2.child.exitcode")
 	Trace2ChildArgv       = attribute.Key("trace2.child.argv")
@@ -87,28 +264,180 @@ const (
 	Trace2ChildHookName   = attribute.Key("trace2.child.hook")
 	Trace2ChildReadyState = attribute.Key("trace2.child.ready")
 
+	// The working directory of the child process, if Git sent one.
+	Trace2ChildCd = attribute.Key("trace2.child.cd")
+
+	// The name of the credential helper (such as "manager" for
+	// `git credential-manager`) and the operation that Git asked it
+	// to do ("get", "store", or "erase") for a credential helper child
+	// (`class:cred`).  Either may be "unknown".
+	//
+	// Type: string
+	Trace2ChildCredHelper = attribute.Key("trace2.child.cred.helper")
+	Trace2ChildCredOp     = attribute.Key("trace2.child.cred.op")
+
+	// How long a credential helper child (`class:cred`) blocked the
+	// parent, such as while waiting for an interactive prompt.  This is
+	// the time until the child reported a ready state, if it did, or
+	// until it exited.  Only emitted for credential helpers that the
+	// parent waited for.
+	//
+	// Type: float64
+	Trace2ChildBlockingSec = attribute.Key("trace2.child.blocking_sec")
+
 	Trace2RegionMessage = attribute.Key("trace2.region.message")
 	Trace2RegionNesting = attribute.Key("trace2.region.nesting")
 	Trace2RegionRepoId  = attribute.Key("trace2.region.repoid")
 	Trace2RegionData    = attribute.Key("trace2.region.data")
+	Trace2RegionFile    = attribute.Key("trace2.region.file")
+	Trace2RegionLine    = attribute.Key("trace2.region.line")
+
+	// The data values of suppressed (short) descendant regions that
+	// were promoted to this span.  See `promote_suppressed_region_data`.
+	//
+	// Type: JSON map[string]map[string]interface{}
+	Trace2RegionInheritedData = attribute.Key("trace2.region.inherited_data")
+
+	// The number of regions and the sum of their durations in an
+	// aggregate span for one (category, label) pair.  See
+	// `region_summarize_threshold`.
+	//
+	// Type: int
+	Trace2RegionCount = attribute.Key("trace2.region.count")
+	// Type: float64
+	Trace2RegionTotalSec = attribute.Key("trace2.region.total_sec")
 
 	Trace2ExecExe      = attribute.Key("trace2.exec.exe")
 	Trace2ExecArgv     = attribute.Key("trace2.exec.argv")
 	Trace2ExecExitCode = attribute.Key("trace2.exec.exitcode")
 
+	// The CI build id from the `def_param` named in `ci_id_param`.
+	// This can be used to correlate Git telemetry with CI runs.
+	//
+	// Type: string
+	Trace2CIBuildId = attribute.Key("trace2.ci.build_id")
+
+	// The detail level used for the command and the one that the
+	// `shadow_ruleset` would have selected.  These are emitted on
+	// every span, but only when a shadow ruleset is configured.
+	//
+	// Type: string
+	Trace2FilterDetail       = attribute.Key("trace2.filter.detail")
+	Trace2FilterShadowDetail = attribute.Key("trace2.filter.shadow_detail")
+
 	Trace2RepoSet  = attribute.Key("trace2.repo.set")
 	Trace2ParamSet = attribute.Key("trace2.param.set")
 
+	// The repo nickname sent by the worktree (using the filter
+	// settings `nickname_key`), even if it does not map to a ruleset.
+	//
+	// Type: string
+	Trace2RepoNickname = attribute.Key("trace2.repo.nickname")
+
+	// The config scope (such as "local", "worktree", or "submodule")
+	// of each value in `trace2.param.set`.  Keys that came from
+	// environment variables do not have a scope and are omitted.
+	// This is emitted at `dl:verbose` and above.
+	//
+	// Type: string (JSON map)
+	Trace2ParamScopes = attribute.Key("trace2.param.scopes")
+
 	Trace2ProcessData     = attribute.Key("trace2.process.data")
 	Trace2ProcessTimers   = attribute.Key("trace2.process.timers")
 	Trace2ProcessCounters = attribute.Key("trace2.process.counters")
 
+	// Resource usage of the process from the "rusage" data events.
+	// These are emitted at all detail levels.
+	//
+	// Type: int (max_rss) or float (times)
+	Trace2ProcessMaxRss        = attribute.Key("trace2.process.max_rss")
+	Trace2ProcessUserTimeSec   = attribute.Key("trace2.process.user_time_sec")
+	Trace2ProcessSystemTimeSec = attribute.Key("trace2.process.system_time_sec")
+
+	// The number of region-level data events that could not be
+	// matched with their region and were attached to the process
+	// (with an "orphaned:" category prefix) instead.
+	Trace2ProcessDataOrphanedCount = attribute.Key("trace2.process.data.orphaned_count")
+
+	// The number of region spans that were omitted because they were
+	// shorter than `min_region_ms` (or the ruleset's
+	// `min_region_duration_sec`).
+	//
+	// Type: int
+	Trace2ProcessElidedRegionCount = attribute.Key("trace2.process.elided_region_count")
+
+	// Set on the process span when the region spans were collapsed into
+	// one aggregate span for each (category, label) pair because there
+	// were more than `region_summarize_threshold` of them.
+	//
+	// Type: bool
+	Trace2ProcessRegionsSummarized = attribute.Key("trace2.process.regions_summarized")
+
+	// The number of child processes (and the sum of their elapsed
+	// times), and the number of them that were hooks or credential
+	// helpers.  These are emitted at all detail levels.
+	//
+	// Type: int (counts) or float (time)
+	Trace2ProcessChildCount    = attribute.Key("trace2.process.child_count")
+	Trace2ProcessChildTotalSec = attribute.Key("trace2.process.child_total_sec")
+	Trace2ProcessHookCount     = attribute.Key("trace2.process.hook_count")
+	Trace2ProcessCredCount     = attribute.Key("trace2.process.cred_count")
+
+	// The Trace2 event type ("error" or "printf") and thread name of
+	// each OTEL log record.  Error records also have the format string
+	// in `trace2.cmd.error.format`.
+	Trace2LogEvent  = attribute.Key("trace2.log.event")
+	Trace2LogThread = attribute.Key("trace2.log.thread")
+
+	// The Trace2 category and name of the timer or counter in each
+	// data point when emitting OTEL metrics.
+	Trace2MetricCategory = attribute.Key("trace2.metric.category")
+	Trace2MetricName     = attribute.Key("trace2.metric.name")
+
+	// The per-thread timers and counters of a thread, serialized like
+	// the process-level ones.  These are emitted on the thread span
+	// (at `dl:verbose`) or, for the main thread, on the process span.
+	//
+	// Type: JSON map[string]map[string]...
 	Trace2ThreadTimers   = attribute.Key("trace2.thread.timers")
 	Trace2ThreadCounters = attribute.Key("trace2.thread.counters")
 
+	// The earliest and latest event timestamps in the data stream.
+	// These are distinct from the process span start and end times.
+	Trace2DatasetFirstEventTime = attribute.Key("trace2.dataset.first_event_time")
+	Trace2DatasetLastEventTime  = attribute.Key("trace2.dataset.last_event_time")
+
 	Trace2GoArch = attribute.Key("trace2.machine.arch")
 	Trace2GoOS   = attribute.Key("trace2.machine.os")
 
+	// The pathname of the Unix domain socket or Windows named pipe
+	// (or the `tcp_listen` address) of the receiver instance that
+	// handled the telemetry.
+	Trace2ReceiverEndpoint = attribute.Key("trace2.receiver.endpoint")
+
+	// The version of the trace2receiver module that produced the
+	// telemetry.  See `Trace2ReceiverVersion`.
+	//
+	// Type: string
+	Trace2ReceiverVersionKey = attribute.Key("trace2.receiver.version")
+
+	// The number of seconds since the receiver instance was started.
+	// This can be used to correlate telemetry gaps with restarts.
+	//
+	// Type: int
+	Trace2ReceiverUptimeSec = attribute.Key("trace2.receiver.uptime_sec")
+
 	Trace2PiiHostname = attribute.Key("trace2.pii.hostname")
 	Trace2PiiUsername = attribute.Key("trace2.pii.username")
+
+	// The hex SHA-256 hash of the salted hostname or username.  See
+	// the `hostname_hash` and `username_hash` PII settings.
+	Trace2PiiHostnameHash = attribute.Key("trace2.pii.hostname_hash")
+	Trace2PiiUsernameHash = attribute.Key("trace2.pii.username_hash")
+
+	// The OS-reported PID of the client process.  See the `peer_pid`
+	// PII setting.
+	//
+	// Type: int
+	Trace2CmdPeerPid = attribute.Key("trace2.cmd.peer_pid")
 )
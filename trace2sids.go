@@ -2,6 +2,7 @@ package trace2receiver
 
 import (
 	"crypto/sha256"
+	"fmt"
 	"strings"
 )
 
@@ -34,8 +35,14 @@ var zeroSpanID [8]byte
 // uniformly distributed) extract substrings from the hashes in well-defined
 // ways (so that other worker threads will compute the same values on the
 // SIDs from other processes).
-func extractIDsfromSID(rawSid string) (tid [16]byte, spid [8]byte, spidParent [8]byte) {
+//
+// We also return the depth of the command in the process tree (the
+// number of `<sid_k>` parts after `<sid_0>`), so 0 for a top-level Git
+// command and 1 or more for a child Git process (such as one started
+// by `git submodule foreach` or a hook).
+func extractIDsfromSID(rawSid string) (tid [16]byte, spid [8]byte, spidParent [8]byte, depth int) {
 	sidArray := strings.Split(rawSid, "/")
+	depth = len(sidArray) - 1
 
 	// Compute the hash on <sid_0> for the TraceID, since all child
 	// processes will have <sid_0> in their SIDs.
@@ -63,3 +70,13 @@ func extractIDsfromSID(rawSid string) (tid [16]byte, spid [8]byte, spidParent [8
 
 	return
 }
+
+// Synthesize the SpanID of the k-th entry in the `cmd_ancestry` of a
+// top-level Git command (where 0 is the immediate parent).  We hash
+// the SID with the depth, so that repeated exports of the same command
+// get the same SpanIDs.
+func extractAncestorSpanID(rawSid string, k int) (spid [8]byte) {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s/ancestor/%d", rawSid, k)))
+	copy(spid[:], hash[16:24])
+	return
+}
@@ -45,3 +45,29 @@ func getPeerUsername(conn *net.UnixConn) (string, error) {
 
 	return u.Username, nil
 }
+
+// Get the PID of the process on the other end of the unix domain
+// socket connection.  On Darwin this is not part of the "Xucred"
+// data, so we have to ask for it separately.
+func getPeerPid(conn *net.UnixConn) (int64, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var pid int
+	var piderr error
+
+	err = raw.Control(
+		func(fd uintptr) {
+			pid, piderr = unix.GetsockoptInt(int(fd),
+				unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+			err = piderr
+		})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(pid), nil
+}
@@ -47,3 +47,28 @@ func getPeerUsername(conn *net.UnixConn) (string, error) {
 
 	return u.Username, nil
 }
+
+// Get the PID of the process on the other end of the unix domain
+// socket connection.
+func getPeerPid(conn *net.UnixConn) (int64, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var cred *unix.Ucred
+	var crederr error
+
+	err = raw.Control(
+		func(fd uintptr) {
+			cred, crederr = unix.GetsockoptUcred(int(fd),
+				unix.SOL_SOCKET, unix.SO_PEERCRED)
+			err = crederr
+		})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(cred.Pid), nil
+}
@@ -0,0 +1,87 @@
+//go:build linux
+// +build linux
+
+package trace2receiver
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Verify that we read one event per message on a SOCK_SEQPACKET socket.
+func Test_UnixSocket_Seqpacket(t *testing.T) {
+	path := makeTestSocketPath(t)
+
+	rcvr, ch := makeTestUnixSocketReceiver(t, path, unixNetworkSeqpacket,
+		createDefaultConfig().(*Config))
+
+	// The host is only used to report a fatal error.
+	assert.Nil(t, rcvr.Start(context.Background(), nil))
+	defer rcvr.Shutdown(context.Background())
+
+	conn, err := net.Dial(unixNetworkSeqpacket, path)
+	assert.Nil(t, err)
+	for _, s := range []string{
+		x_make_version(),
+		x_make_start(),
+		x_make_atexit(), // Should be last
+	} {
+		// Without a trailing newline.
+		_, err = conn.Write([]byte(s))
+		assert.Nil(t, err)
+	}
+	conn.Close()
+
+	td := waitForTestTraces(t, ch)
+	assert.Equal(t, 1, td.SpanCount())
+}
+
+// Verify that we treat a message that is too large for our buffer
+// (and that the kernel truncated) as an error.
+func Test_UnixSocket_SeqpacketTruncated(t *testing.T) {
+	defer func(n int) { seqpacketMaxMessageSize = n }(seqpacketMaxMessageSize)
+	seqpacketMaxMessageSize = 64
+
+	path := makeTestSocketPath(t)
+	l, err := net.ListenUnix(unixNetworkSeqpacket,
+		&net.UnixAddr{Name: path, Net: unixNetworkSeqpacket})
+	assert.Nil(t, err)
+	defer l.Close()
+
+	client, err := net.Dial(unixNetworkSeqpacket, path)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	server, err := l.AcceptUnix()
+	assert.Nil(t, err)
+	defer server.Close()
+
+	rcvr, _ := makeTestUnixSocketReceiver(t, path, unixNetworkSeqpacket,
+		createDefaultConfig().(*Config))
+	core, logs := observer.New(zap.ErrorLevel)
+	rcvr.Base.Logger = zap.New(core)
+	tr2 := NewTrace2Dataset(rcvr.Base)
+
+	// A message that fits is fine.
+	_, err = client.Write([]byte("# comment"))
+	assert.Nil(t, err)
+
+	// A message that does not is an error.
+	_, err = client.Write([]byte("# " + strings.Repeat("x", seqpacketMaxMessageSize)))
+	assert.Nil(t, err)
+
+	assert.True(t, rcvr.readMessages(server, tr2, time.Now()))
+	assert.Equal(t, 1, logs.FilterMessageSnippet("message larger than").Len())
+
+	// And a clean hang up is not.
+	tr2 = NewTrace2Dataset(&Rcvr_Base{Logger: zap.NewNop(), RcvrConfig: rcvr.Base.RcvrConfig})
+	client.Close()
+	assert.False(t, rcvr.readMessages(server, tr2, time.Now()))
+}
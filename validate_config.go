@@ -0,0 +1,187 @@
+package trace2receiver
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ValidateConfigFiles loads and validates the given filter settings,
+// ruleset, and PII settings YML files (and the ruleset files that the
+// filter settings refer to) using the same validation as the receiver,
+// and returns all of the errors that it finds rather than stopping at
+// the first one.  The kind of each file is inferred from its keys.
+//
+// This is intended for a preflight check (such as a `--validate-config`
+// option in a custom collector) so that mistakes can be found before
+// the files are deployed rather than when the collector fails to start.
+// It is stricter than the receiver, in that it also reports duplicate
+// and unknown top-level keys (which the receiver silently ignores).
+func ValidateConfigFiles(paths ...string) []error {
+	var errs []error
+	seen := make(map[string]bool)
+
+	for _, path := range paths {
+		for _, err := range validateConfigFile(path, "") {
+			// A ruleset may be listed explicitly and referenced by a
+			// filter settings file, so only report each error once.
+			if !seen[err.Error()] {
+				seen[err.Error()] = true
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// The kinds of YML files that we know how to validate.
+const (
+	ymlKindFilter  = "filter settings"
+	ymlKindRuleset = "ruleset"
+	ymlKindPii     = "pii settings"
+)
+
+var ymlKindKeys = map[string]map[string]bool{
+	ymlKindFilter:  mapstructureKeys(FilterSettings{}),
+	ymlKindRuleset: mapstructureKeys(RulesetDefinition{}),
+	ymlKindPii:     mapstructureKeys(PiiSettings{}),
+}
+
+// Get the set of `mapstructure` field names of a struct.
+func mapstructureKeys(v interface{}) map[string]bool {
+	keys := make(map[string]bool)
+
+	t := reflect.TypeOf(v)
+	for k := 0; k < t.NumField(); k++ {
+		name, _, _ := strings.Cut(t.Field(k).Tag.Get("mapstructure"), ",")
+		if len(name) > 0 {
+			keys[name] = true
+		}
+	}
+
+	return keys
+}
+
+// Guess the kind of YML file from its top-level keys.  The filter
+// settings and rulesets both have a `defaults` section, so we look
+// inside it to tell them apart.  Returns "" if we cannot tell.
+func classifyYml(m map[interface{}]interface{}) string {
+	score := make(map[string]int)
+
+	for k, v := range m {
+		key := fmt.Sprint(k)
+		if key == "defaults" {
+			if dm, ok := v.(map[interface{}]interface{}); ok {
+				if _, ok := dm["ruleset"]; ok {
+					score[ymlKindFilter]++
+				}
+				if _, ok := dm["detail"]; ok {
+					score[ymlKindRuleset]++
+				}
+			}
+			continue
+		}
+		for kind, keys := range ymlKindKeys {
+			if keys[key] {
+				score[kind]++
+			}
+		}
+	}
+
+	best := ""
+	for _, kind := range []string{ymlKindFilter, ymlKindRuleset, ymlKindPii} {
+		if score[kind] > 0 && (len(best) == 0 || score[kind] > score[best]) {
+			best = kind
+		}
+	}
+
+	return best
+}
+
+// Validate a single YML file.  If `kind` is empty, infer it from
+// the content of the file.
+func validateConfigFile(path string, kind string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{fmt.Errorf("could not read YML '%s': '%s'",
+			path, err.Error())}
+	}
+
+	// Use the strict parser here to catch duplicate keys.  (The
+	// receiver would silently use the last one.)
+	m := make(map[interface{}]interface{})
+	err = yaml.UnmarshalStrict(data, &m)
+	if err != nil {
+		return []error{fmt.Errorf("could not parse YAML '%s': '%s'",
+			path, err.Error())}
+	}
+
+	if len(kind) == 0 {
+		kind = classifyYml(m)
+		if len(kind) == 0 {
+			return []error{fmt.Errorf("could not tell what kind of settings file '%s' is",
+				path)}
+		}
+	}
+
+	var errs []error
+
+	var unknown []string
+	for k := range m {
+		if key := fmt.Sprint(k); !ymlKindKeys[kind][key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Errorf("%s '%s' has unknown key '%s'",
+			kind, path, key))
+	}
+
+	switch kind {
+	case ymlKindFilter:
+		// Check each of the referenced rulesets on its own so that we
+		// can report problems in all of them.  (The filter settings
+		// parser stops at the first bad one.)
+		if fs, err := parseYmlBuffer[FilterSettings](data, path); err == nil {
+			rulesets, _, err := expandRulesetGlob(fs.Rulesets)
+			if err != nil {
+				rulesets = nil // reported by the filter settings parser
+			}
+
+			var names []string
+			for k_rs_name := range rulesets {
+				names = append(names, k_rs_name)
+			}
+			sort.Strings(names)
+
+			for _, k_rs_name := range names {
+				rsPath, err := expandPathname(rulesets[k_rs_name])
+				if err != nil || len(rsPath) == 0 {
+					continue // reported by the filter settings parser
+				}
+				errs = append(errs, validateConfigFile(rsPath, ymlKindRuleset)...)
+			}
+		}
+		if _, err = parseFilterSettingsFromBuffer(data, path); err != nil {
+			errs = append(errs, err)
+		}
+
+	case ymlKindRuleset:
+		if _, err = parseRulesetFromBuffer(data, path); err != nil {
+			errs = append(errs, err)
+		}
+
+	case ymlKindPii:
+		if _, err = parsePiiFromBuffer(data, path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,142 @@
+package trace2receiver
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DataValueRule maps a Trace2 "data" event (category,key) pair to
+// an OTEL attribute name.  When enabled, the value will be emitted
+// on the process span as a first-class numeric attribute rather than
+// being buried in the `trace2.process.data` JSON blob (which is
+// only emitted at `dl:process` and above).
+//
+// If `Rate` is set, we also emit the value divided by the duration
+// of the process as `<attribute>_per_sec`, such as the transfer
+// throughput of a fetch.
+type DataValueRule struct {
+	Category  string `mapstructure:"category"`
+	Key       string `mapstructure:"key"`
+	Attribute string `mapstructure:"attribute"`
+	Rate      bool   `mapstructure:"rate"`
+}
+
+// The suffix appended to the attribute name of a data value rule
+// to form the name of the derived rate attribute.
+const dataValueRateSuffix string = "_per_sec"
+
+// The builtin curated set of well-known (category,key) pairs that
+// Git emits.  Operators can extend this set using the
+// `data_value_rules` config setting.
+var wellKnownDataValueRules []DataValueRule = []DataValueRule{
+	{"fsync", "fsync/writeout-only", "trace2.data.fsync.writeout_only", false},
+	{"fsync", "fsync/hardware-flush", "trace2.data.fsync.hardware_flush", false},
+	{"index", "read/cache_nr", "trace2.data.index.entries", false},
+	{"index", "read/version", "trace2.data.index.version", false},
+	{"pack-objects", "write_pack_file/wrote", "trace2.data.pack.objects_written", true},
+	{"fetch-pack", "total_rounds", "trace2.data.fetch.negotiation_rounds", false},
+	{"negotiation_v2", "total_rounds", "trace2.data.fetch.negotiation_rounds", false},
+	{"progress", "total_bytes", "trace2.data.transfer.bytes", true},
+	{"status", "count/changed", "trace2.data.status.changed", false},
+	{"status", "count/untracked", "trace2.data.status.untracked", false},
+	{"status", "count/ignored", "trace2.data.status.ignored", false},
+}
+
+// Validate a list of data value rules from the `config.yml`.
+func validateDataValueRules(rules []DataValueRule) error {
+	for k, r := range rules {
+		if len(r.Category) == 0 || len(r.Key) == 0 || len(r.Attribute) == 0 {
+			return fmt.Errorf("receivers.trace2receiver.data_value_rules[%d] is incomplete", k)
+		}
+	}
+
+	return nil
+}
+
+// Convert a generic data value into an integer, if possible.  "data"
+// events contain either a string or an int64.  "data_json" events
+// decode numbers as float64.
+func dataValueToInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), true
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		return i, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Find the value of the data event with this (category,key) pair.
+//
+// Process-level data events are preferred.  But Git emits most data
+// events inside of a region, so also look at the completed regions.
+func (tr2 *trace2Dataset) lookupDataValue(category string, key string) (interface{}, bool) {
+	if kmap, ok := tr2.process.dataValues[category]; ok {
+		if v, ok := kmap[key]; ok {
+			return v, true
+		}
+	}
+
+	for _, r := range tr2.completedRegions {
+		if kmap, ok := r.dataValues[category]; ok {
+			if v, ok := kmap[key]; ok {
+				return v, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// Extract the values of the well-known data events (and any custom
+// data value rules) into `tr2.process.wellKnownData` and the derived
+// rates into `tr2.process.wellKnownRates`.  We do this after the end
+// time of the process is known (or synthesized).  The rates are
+// omitted if the duration of the process is zero or unknown.
+func (tr2 *trace2Dataset) extractWellKnownData(custom []DataValueRule) {
+	rules := make([]DataValueRule, 0, len(wellKnownDataValueRules)+len(custom))
+	rules = append(rules, wellKnownDataValueRules...)
+	rules = append(rules, custom...)
+
+	for _, r := range rules {
+		v, ok := tr2.lookupDataValue(r.Category, r.Key)
+		if !ok {
+			continue
+		}
+		i, ok := dataValueToInt64(v)
+		if !ok {
+			continue
+		}
+		if tr2.process.wellKnownData == nil {
+			tr2.process.wellKnownData = make(map[string]int64)
+		}
+		tr2.process.wellKnownData[r.Attribute] = i
+
+		if !r.Rate {
+			continue
+		}
+		sec, ok := tr2.processDurationSec()
+		if !ok {
+			continue
+		}
+		if tr2.process.wellKnownRates == nil {
+			tr2.process.wellKnownRates = make(map[string]float64)
+		}
+		tr2.process.wellKnownRates[r.Attribute+dataValueRateSuffix] = float64(i) / sec
+	}
+}
+
+// Get the duration of the process in seconds, if it is positive.
+func (tr2 *trace2Dataset) processDurationSec() (float64, bool) {
+	lt := &tr2.process.mainThread.lifetime
+	if lt.startTime.IsZero() || lt.endTime.IsZero() {
+		return 0, false
+	}
+
+	sec := lt.endTime.Sub(lt.startTime).Seconds()
+	return sec, sec > 0
+}